@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// PromptRequest represents a prompt passed from an input reader to the main loop
+type PromptRequest struct {
+	Prompt string            `json:"prompt"`
+	Model  string            `json:"model,omitempty"`
+	Tools  []string          `json:"tools,omitempty"`
+	Files  []string          `json:"files,omitempty"`
+	Stores []string          `json:"stores,omitempty"` // FileSearchStores (replace the configured stores)
+	Reply  chan PromptResult `json:"-"`
+
+	// context of the caller (MCP tool call), its cancellation cancels the request
+	Context context.Context `json:"-"`
+
+	// conversation managed by the caller (OpenAI-compatible API), processed without chat session
+	Contents          []*genai.Content `json:"-"`
+	SystemInstruction string           `json:"-"`
+	StreamText        func(string)     `json:"-"`
+
+	// slash command entered in terminal (e.g. '/model pro'), executed instead of a prompt
+	Command string `json:"-"`
+}
+
+// PromptResult represents the result of a prompt, returned to API callers
+type PromptResult struct {
+	Model    string       `json:"model"`
+	Slug     string       `json:"slug"`
+	Markdown string       `json:"markdown"`
+	HTML     string       `json:"html"`
+	Error    string       `json:"error,omitempty"`
+	Usage    *TokenUsage  `json:"usage,omitempty"`
+	History  HistoryFiles `json:"history"`
+
+	statusCode int                            // HTTP status code (if not OK)
+	response   *genai.GenerateContentResponse // complete Gemini response
+}
+
+// TokenUsage represents the token usage of a response
+type TokenUsage struct {
+	PromptTokens     int32 `json:"promptTokens"`
+	CachedTokens     int32 `json:"cachedTokens"`
+	ToolUsePrompt    int32 `json:"toolUsePromptTokens"`
+	CandidatesTokens int32 `json:"candidatesTokens"`
+	ThoughtsTokens   int32 `json:"thoughtsTokens"`
+	TotalTokens      int32 `json:"totalTokens"`
+}
+
+// HistoryFiles holds the paths of the history files written for a response
+type HistoryFiles struct {
+	Markdown string `json:"markdown,omitempty"`
+	HTML     string `json:"html,omitempty"`
+	Ansi     string `json:"ansi,omitempty"`
+	JSON     string `json:"json,omitempty"` // structured output
+}
+
+/*
+hasOverrides reports whether the prompt request overrides the configured model, tools or files.
+*/
+func (request PromptRequest) hasOverrides() bool {
+	return request.Model != "" || request.Tools != nil || len(request.Files) > 0 || request.Stores != nil
+}
+
+/*
+applyPromptRequestOverrides applies the per-request model, tools and files to the program configuration.
+The caller is responsible for saving and restoring the configuration (progConfig, filesToHandle).
+Overrides are not supported in chat mode, because model, tools and files are bound to the chat session.
+*/
+func applyPromptRequestOverrides(request PromptRequest, chatmode bool) error {
+	if chatmode {
+		return errors.New("per-request model, tools and files are not supported in chat mode")
+	}
+
+	if request.Model != "" {
+		progConfig.GeminiAiModel = resolveModelAlias(request.Model)
+	}
+	if len(request.Stores) > 0 && isVertexBackend() {
+		return errors.New("FileSearchStores not available with Vertex AI backend")
+	}
+	if request.Tools != nil {
+		err := applyToolOverrides(request.Tools)
+		if err != nil {
+			return err
+		}
+	}
+	if len(request.Files) > 0 {
+		requestFiles := buildGivenFiles(request.Files, nil)
+		for _, requestFile := range requestFiles {
+			if requestFile.State == "error" {
+				return fmt.Errorf("file [%s]: %s", requestFile.Filepath, requestFile.ErrorMessage)
+			}
+		}
+		filesToHandle = append(append([]FileToHandle{}, filesToHandle...), requestFiles...)
+	}
+	return nil
+}
+
+/*
+buildPromptResult builds the result returned to API callers after the response has been handled.
+Markdown and HTML are read from the current prompt/response files (HTML as complete page).
+*/
+func buildPromptResult(resp *genai.GenerateContentResponse, respErr error, slug string, history HistoryFiles) PromptResult {
+	result := PromptResult{
+		Model:    progConfig.GeminiAiModel,
+		Slug:     slug,
+		History:  history,
+		response: resp,
+	}
+
+	if respErr != nil {
+		result.Error = respErr.Error()
+	}
+
+	if resp != nil && resp.UsageMetadata != nil {
+		result.Usage = &TokenUsage{
+			PromptTokens:     resp.UsageMetadata.PromptTokenCount,
+			CachedTokens:     resp.UsageMetadata.CachedContentTokenCount,
+			ToolUsePrompt:    resp.UsageMetadata.ToolUsePromptTokenCount,
+			CandidatesTokens: resp.UsageMetadata.CandidatesTokenCount,
+			ThoughtsTokens:   resp.UsageMetadata.ThoughtsTokenCount,
+			TotalTokens:      resp.UsageMetadata.TotalTokenCount,
+		}
+	}
+
+	markdownData, err := os.ReadFile(progConfig.MarkdownPromptResponseFile)
+	if err != nil {
+		fmt.Printf("error [%v] at os.ReadFile()\n", err)
+	}
+	result.Markdown = string(markdownData)
+
+	htmlData, err := os.ReadFile(progConfig.HTMLPromptResponseFile)
+	if err != nil {
+		fmt.Printf("error [%v] at os.ReadFile()\n", err)
+	}
+	result.HTML = string(htmlData)
+
+	return result
+}
+
+/*
+secureLocalAPI protects a JSON API endpoint of the localhost server against requests of arbitrary web pages:
+browser requests are only accepted from the configured localhost origins (InputLocalhostAllowedOrigins), every
+request needs the bearer token (InputLocalhostAPIToken) and request bodies must be JSON. Errors are written
+with the error format of the endpoint (writeError). Shared by the JSON API and the OpenAI-compatible endpoint.
+*/
+func secureLocalAPI(config ProgConfig, writeError func(w http.ResponseWriter, statusCode int, message string), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			if !slices.Contains(config.InputLocalhostAllowedOrigins, origin) {
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			// CORS preflight (e.g. from browser)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if config.InputLocalhostAPIToken == "" {
+			writeError(w, http.StatusForbidden, "JSON API disabled, no InputLocalhostAPIToken configured")
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(config.InputLocalhostAPIToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "content type must be 'application/json'")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+/*
+isLocalhostOrigin reports whether the origin (e.g. 'http://localhost:8080') refers to the local host.
+*/
+func isLocalhostOrigin(origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil || (originURL.Scheme != "http" && originURL.Scheme != "https") || originURL.Path != "" {
+		return false
+	}
+	switch originURL.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+/*
+confineAPIFile resolves a file given via JSON API within the files root (InputLocalhostFilesRoot). Paths outside
+of the root (also via symbolic links) and denied paths (WorkspaceDenyList) are rejected.
+*/
+func confineAPIFile(config ProgConfig, name string) (string, error) {
+	root := config.InputLocalhostFilesRoot
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("files root not available")
+	}
+
+	target := filepath.FromSlash(name)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(target))
+	if err != nil {
+		return "", fmt.Errorf("file [%s] not found", name)
+	}
+	for _, path := range []string{filepath.Clean(target), resolved} {
+		relative, ok := workspaceRelativePath(root, path)
+		if !ok {
+			return "", fmt.Errorf("file [%s] outside of files root", name)
+		}
+		if pathMatchesDenyList(config.WorkspaceDenyList, relative) {
+			return "", fmt.Errorf("access to file [%s] denied", name)
+		}
+	}
+	return resolved, nil
+}
+
+/*
+handlePromptAPI creates the HTTP handler of the synchronous JSON API ('POST /v1/prompt'). The request body
+holds prompt, model (alias or name), tools and file paths (within InputLocalhostFilesRoot). The handler blocks
+until the response has been processed by the main loop and returns Markdown, HTML, slug, token usage and history
+file paths as JSON.
+*/
+func handlePromptAPI(config ProgConfig, promptChannel chan PromptRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+			return
+		}
+		defer func() { _ = r.Body.Close() }()
+
+		var request PromptRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON request: %v", err))
+			return
+		}
+		if strings.TrimSpace(request.Prompt) == "" {
+			writeJSONError(w, http.StatusBadRequest, "prompt empty")
+			return
+		}
+		for i, file := range request.Files {
+			request.Files[i], err = confineAPIFile(config, file)
+			if err != nil {
+				writeJSONError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+
+		// buffered, the main loop must never block on a vanished caller
+		request.Reply = make(chan PromptResult, 1)
+
+		select {
+		case promptChannel <- request:
+		case <-r.Context().Done():
+			return
+		}
+
+		var result PromptResult
+		select {
+		case result = <-request.Reply:
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if result.statusCode != 0 {
+			w.WriteHeader(result.statusCode)
+		} else if result.Error != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(result)
+	}
+}
+
+/*
+writeJSONError writes an error message as JSON object with the given HTTP status code.
+*/
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
+	"google.golang.org/genai"
+)
+
+// backend names (GeminiBackend)
+const (
+	backendGeminiAPI = "gemini"
+	backendVertexAI  = "vertex"
+)
+
+// vertexScope is the OAuth scope of Vertex AI requests
+const vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+
+/*
+isVertexBackend reports whether requests go to Vertex AI (instead of the Gemini Developer API).
+*/
+func isVertexBackend() bool {
+	return progConfig.GeminiBackend == backendVertexAI
+}
+
+/*
+backendDescription returns a short description of the configured backend, e.g. 'Vertex AI (my-project, global)'.
+*/
+func backendDescription() string {
+	if isVertexBackend() {
+		return fmt.Sprintf("Vertex AI (%s, %s)", progConfig.VertexProject, progConfig.VertexLocation)
+	}
+	return "Gemini API"
+}
+
+/*
+backendModelName converts a model name into the form of the configured backend. Vertex AI addresses Google
+models as publisher models (e.g. 'gemini-3-flash-preview'), 'models/...' would denote a Model Registry model.
+*/
+func backendModelName(model string) string {
+	if isVertexBackend() {
+		return strings.TrimPrefix(model, "models/")
+	}
+	return model
+}
+
+/*
+validateVertexConfiguration validates the Vertex AI configuration (project and location default to the
+environment variables GOOGLE_CLOUD_PROJECT and GOOGLE_CLOUD_LOCATION) and converts the configured model
+names into publisher model names.
+*/
+func validateVertexConfiguration() error {
+	if progConfig.VertexProject == "" {
+		progConfig.VertexProject = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if progConfig.VertexProject == "" {
+		return fmt.Errorf("empty VertexProject not allowed (GeminiBackend: vertex)")
+	}
+	if progConfig.VertexLocation == "" {
+		progConfig.VertexLocation = os.Getenv("GOOGLE_CLOUD_LOCATION")
+	}
+	if progConfig.VertexLocation == "" {
+		progConfig.VertexLocation = "global"
+	}
+	for _, uri := range progConfig.VertexFileURIs {
+		if !strings.HasPrefix(uri, "gs://") {
+			return fmt.Errorf("invalid VertexFileURIs entry [%s] (gs://bucket/object expected)", uri)
+		}
+	}
+
+	for _, model := range []*string{&progConfig.GeminiLiteAiModel, &progConfig.GeminiFlashAiModel, &progConfig.GeminiProAiModel,
+		&progConfig.GeminiFlashImageAiModel, &progConfig.GeminiProImageAiModel, &progConfig.GeminiDefaultAiModel} {
+		*model = backendModelName(*model)
+	}
+	return nil
+}
+
+/*
+backendFeatureAvailable reports whether a feature of the Gemini Developer API is available with the
+configured backend, and prints a note with an optional alternative if not.
+*/
+func backendFeatureAvailable(feature string, alternative string) bool {
+	if !isVertexBackend() {
+		return true
+	}
+	fmt.Printf("Note: %s not available with Vertex AI backend", feature)
+	if alternative != "" {
+		fmt.Printf(" (%s)", alternative)
+	}
+	fmt.Printf("\n")
+	return false
+}
+
+/*
+configureVertexClient sets backend, project, location and credentials of a Vertex AI client. Credentials
+are read from a service account JSON file (VertexCredentials) or detected as Application Default
+Credentials. The authorization is added to the given HTTP client (shared transport).
+*/
+func configureVertexClient(ctx context.Context, clientConfig *genai.ClientConfig) error {
+	clientConfig.APIKey = ""
+	clientConfig.Backend = genai.BackendVertexAI
+	clientConfig.Project = progConfig.VertexProject
+	clientConfig.Location = progConfig.VertexLocation
+
+	detectOptions := &credentials.DetectOptions{Scopes: []string{vertexScope}}
+	if progConfig.VertexCredentials != "" {
+		credentialsJSON, err := getCredentials(progConfig.VertexCredentials)
+		if err != nil {
+			return fmt.Errorf("error [%w] getting Vertex AI credentials", err)
+		}
+		detectOptions.CredentialsJSON = credentialsJSON
+	}
+	creds, err := credentials.DetectDefault(detectOptions)
+	if err != nil {
+		return fmt.Errorf("error [%w] detecting Vertex AI credentials", err)
+	}
+	clientConfig.Credentials = creds
+
+	err = httptransport.AddAuthorizationMiddleware(clientConfig.HTTPClient, creds)
+	if err != nil {
+		return fmt.Errorf("error [%w] adding Vertex AI authorization", err)
+	}
+	setQuotaProjectHeader(ctx, clientConfig, creds)
+	return nil
+}
+
+/*
+setQuotaProjectHeader bills requests to the quota project of the credentials (user credentials of ADC).
+*/
+func setQuotaProjectHeader(ctx context.Context, clientConfig *genai.ClientConfig, creds *auth.Credentials) {
+	quotaProject, err := creds.QuotaProjectID(ctx)
+	if err != nil || quotaProject == "" {
+		return
+	}
+	if clientConfig.HTTPOptions.Headers == nil {
+		clientConfig.HTTPOptions.Headers = http.Header{}
+	}
+	clientConfig.HTTPOptions.Headers.Set("X-Goog-User-Project", quotaProject)
+}
+
+/*
+backendEndpointBase returns the base of the generateContent endpoint of the configured backend (for display).
+*/
+func backendEndpointBase() string {
+	baseURL := progConfig.GeminiBaseURL
+	if isVertexBackend() {
+		if baseURL == "" {
+			baseURL = "https://aiplatform.googleapis.com/"
+			if progConfig.VertexLocation != "global" {
+				baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com/", progConfig.VertexLocation)
+			}
+		}
+		return fmt.Sprintf("%s/v1beta1/projects/%s/locations/%s/publishers/google/", strings.TrimSuffix(baseURL, "/"),
+			progConfig.VertexProject, progConfig.VertexLocation)
+	}
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com/"
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/v1beta/"
+}
+
+/*
+includedRemoteFiles returns the remote files included in the prompt (-include-files): all files of the Google
+file store (Gemini API) or the Cloud Storage files configured in VertexFileURIs (Vertex AI).
+*/
+func includedRemoteFiles(ctx context.Context, client *genai.Client) ([]*genai.Part, error) {
+	parts := []*genai.Part{}
+	if isVertexBackend() {
+		for _, uri := range progConfig.VertexFileURIs {
+			parts = append(parts, genai.NewPartFromURI(uri, gcsFileMimeType(uri)))
+		}
+		return parts, nil
+	}
+	for file, err := range client.Files.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, genai.NewPartFromFile(*file))
+	}
+	return parts, nil
+}
+
+/*
+gcsFileMimeType returns the MIME type of a Cloud Storage file derived from its extension (the file is not
+read). Replacement MIME types (e.g. 'text/x-perl -> text/plain') are applied.
+*/
+func gcsFileMimeType(uri string) string {
+	mimeType := mime.TypeByExtension(path.Ext(uri))
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	if replacement, ok := ReplacementMIMETypeMap[mimeType]; ok {
+		return replacement
+	}
+	return mimeType
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// BatchRequest represents one line (one prompt) of a batch request file (JSONL)
+type BatchRequest struct {
+	Prompt string   `json:"prompt"`
+	Model  string   `json:"model,omitempty"` // e.g. 'flash', 'pro' or full model name
+	Tools  []string `json:"tools,omitempty"` // e.g. 'google-search', 'url-context', 'code-execution', 'google-maps'
+}
+
+// batchJobName holds the name of the batch job whose results are currently processed
+var batchJobName string
+
+/*
+readBatchRequests reads all batch requests from a JSONL file (one JSON object per line).
+Empty lines are ignored. Each request must contain a prompt.
+*/
+func readBatchRequests(filename string) ([]BatchRequest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var batchRequests []BatchRequest
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var batchRequest BatchRequest
+		err = json.Unmarshal([]byte(line), &batchRequest)
+		if err != nil {
+			return nil, fmt.Errorf("error [%w] unmarshalling line %d", err, lineNumber)
+		}
+		if strings.TrimSpace(batchRequest.Prompt) == "" {
+			return nil, fmt.Errorf("empty prompt in line %d not allowed", lineNumber)
+		}
+		batchRequests = append(batchRequests, batchRequest)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return batchRequests, nil
+}
+
+/*
+resolveModelAlias resolves a model alias (lite, flash, pro, flash-image, pro-image, default) into the
+configured model name. Any other value is treated as full model name.
+*/
+func resolveModelAlias(alias string) string {
+	switch strings.ToLower(alias) {
+	case "":
+		return progConfig.GeminiAiModel
+	case "lite":
+		return progConfig.GeminiLiteAiModel
+	case "flash":
+		return progConfig.GeminiFlashAiModel
+	case "pro":
+		return progConfig.GeminiProAiModel
+	case "flash-image":
+		return progConfig.GeminiFlashImageAiModel
+	case "pro-image":
+		return progConfig.GeminiProImageAiModel
+	case "default":
+		return progConfig.GeminiDefaultAiModel
+	}
+	if !strings.HasPrefix(alias, "models/") {
+		alias = "models/" + alias
+	}
+	return backendModelName(alias)
+}
+
+/*
+applyToolOverrides replaces the configured grounding tools with the given list of tools.
+Tool names correspond to the command line options (e.g. 'google-search').
+*/
+func applyToolOverrides(tools []string) error {
+	progConfig.GeminiGroundingWithGoogleSearch = false
+	progConfig.GeminiGroundingWithURLContext = false
+	progConfig.GeminiGroundingWithCodeExecution = false
+	progConfig.GeminiGroundigWithGoogleMaps = false
+
+	for _, tool := range tools {
+		switch strings.ToLower(strings.TrimSpace(tool)) {
+		case "google-search":
+			progConfig.GeminiGroundingWithGoogleSearch = true
+		case "url-context":
+			progConfig.GeminiGroundingWithURLContext = true
+		case "code-execution":
+			progConfig.GeminiGroundingWithCodeExecution = true
+		case "google-maps":
+			progConfig.GeminiGroundigWithGoogleMaps = true
+		default:
+			return fmt.Errorf("unsupported tool [%s]", tool)
+		}
+	}
+	return nil
+}
+
+/*
+submitBatchJob submits all prompts from the given JSONL file as batch jobs (inlined requests). A batch job runs
+one model, so the requests are grouped by model into one job per model (in order of first use). Files given
+via command line are added to each prompt. Batch jobs are processed asynchronously (target turnaround time is
+24 hours) at 50% of the standard price.
+*/
+func submitBatchJob(filename string) {
+	batchRequests, err := readBatchRequests(filename)
+	if err != nil {
+		log.Fatalf("error [%v] reading batch requests from file [%s]", err, filename)
+	}
+	if len(batchRequests) == 0 {
+		fmt.Printf("  nothing to do, no batch requests found\n")
+		return
+	}
+
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	// file contents (from command line) are part of each request
+	fileContents := []*genai.Content{}
+	for _, fileToHandle := range filesToHandle {
+		if fileToHandle.State == "error" {
+			continue
+		}
+		content, err := convertFileToContent(fileToHandle.Filepath)
+		if err != nil {
+			fmt.Printf("error [%v] converting file to content\n", err)
+			continue
+		}
+		fileContents = append(fileContents, content)
+	}
+
+	models := []string{}
+	inlinedRequestsByModel := map[string][]*genai.InlinedRequest{}
+	for i, batchRequest := range batchRequests {
+		// apply per-request overrides to a copy of the configuration
+		savedConfig := progConfig
+		model := resolveModelAlias(batchRequest.Model)
+		progConfig.GeminiAiModel = model
+		if batchRequest.Tools != nil {
+			err = applyToolOverrides(batchRequest.Tools)
+			if err != nil {
+				log.Fatalf("error [%v] in batch request #%d", err, i+1)
+			}
+		}
+		isImageRequest := strings.Contains(model, "image")
+		modelConfig := generateGeminiModelConfig(isImageRequest, "", includeStores)
+		progConfig = savedConfig
+
+		contents := append([]*genai.Content{}, fileContents...)
+		contents = append(contents, genai.NewContentFromText(strings.TrimSpace(batchRequest.Prompt), "user"))
+
+		inlinedRequest := &genai.InlinedRequest{
+			Contents: contents,
+			Config:   modelConfig,
+			Metadata: map[string]string{
+				"prompt": strings.TrimSpace(batchRequest.Prompt),
+				"model":  model,
+				"tools":  strings.Join(batchRequest.Tools, ","),
+			},
+		}
+		if _, found := inlinedRequestsByModel[model]; !found {
+			models = append(models, model)
+		}
+		inlinedRequestsByModel[model] = append(inlinedRequestsByModel[model], inlinedRequest)
+		fmt.Printf("  #%d: %s (%s)\n", i+1, wrapString(strings.TrimSpace(batchRequest.Prompt), progConfig.AnsiOutputLineLength, 6), model)
+	}
+
+	displayName := progName + "-" + time.Now().Format("20060102-150405")
+	for _, model := range models {
+		jobDisplayName := displayName
+		if len(models) > 1 {
+			jobDisplayName += "-" + strings.TrimPrefix(model, "models/")
+		}
+		batchJob, err := client.Batches.Create(ctx, model,
+			&genai.BatchJobSource{
+				InlinedRequests: inlinedRequestsByModel[model],
+			},
+			&genai.CreateBatchJobConfig{
+				DisplayName: jobDisplayName,
+			})
+		if err != nil {
+			log.Fatalf("error [%v] creating batch job for model [%s]", err, model)
+		}
+
+		fmt.Printf("\n  Batch job created successfully:\n")
+		fmt.Printf("  Name    : %s\n", batchJob.Name)
+		fmt.Printf("  Display : %s\n", batchJob.DisplayName)
+		fmt.Printf("  Model   : %s (%d %s)\n", model, len(inlinedRequestsByModel[model]),
+			pluralize(len(inlinedRequestsByModel[model]), "request"))
+		fmt.Printf("  State   : %s\n", batchJob.State)
+	}
+}
+
+/*
+showBatchJobStatus shows the details of a batch job.
+*/
+func showBatchJobStatus(name string, indent string) {
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	batchJob, err := client.Batches.Get(ctx, name, nil)
+	if err != nil {
+		log.Fatalf("error [%v] getting batch job '%s'", err, name)
+	}
+
+	fmt.Printf("%sName        : %s\n", indent, batchJob.Name)
+	fmt.Printf("%sDisplayName : %s\n", indent, batchJob.DisplayName)
+	fmt.Printf("%sModel       : %s\n", indent, batchJob.Model)
+	fmt.Printf("%sState       : %s\n", indent, batchJob.State)
+	if !batchJob.CreateTime.IsZero() {
+		fmt.Printf("%sCreateTime  : %s\n", indent, batchJob.CreateTime.Local().Format(time.RFC850))
+	}
+	if !batchJob.StartTime.IsZero() {
+		fmt.Printf("%sStartTime   : %s\n", indent, batchJob.StartTime.Local().Format(time.RFC850))
+	}
+	if !batchJob.EndTime.IsZero() {
+		fmt.Printf("%sEndTime     : %s\n", indent, batchJob.EndTime.Local().Format(time.RFC850))
+	}
+	if batchJob.CompletionStats != nil {
+		fmt.Printf("%sCompletion  : %d successful, %d failed, %d incomplete\n", indent,
+			batchJob.CompletionStats.SuccessfulCount, batchJob.CompletionStats.FailedCount, batchJob.CompletionStats.IncompleteCount)
+	}
+	if batchJob.Dest != nil && len(batchJob.Dest.InlinedResponses) > 0 {
+		fmt.Printf("%sResponses   : %d\n", indent, len(batchJob.Dest.InlinedResponses))
+	}
+	if batchJob.Error != nil {
+		fmt.Printf("%sError       : %s\n", indent, batchJob.Error.Message)
+	}
+}
+
+/*
+listBatchJobs lists all batch jobs in a detailed block format.
+*/
+func listBatchJobs(indent string) {
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	fmt.Printf("\n%sFull Batch Job Name (ID)\n", indent)
+	fmt.Printf("%s  Display Name, Model\n", indent)
+	fmt.Printf("%s  State, Create Time, End Time\n\n", indent)
+
+	found := false
+	for batchJob, err := range client.Batches.All(ctx) {
+		if err != nil {
+			log.Fatalf("error [%v] retrieving batch jobs", err)
+		}
+
+		found = true
+		createTimeStr := batchJob.CreateTime.Local().Format("20060102-150405")
+		endTimeStr := "-"
+		if !batchJob.EndTime.IsZero() {
+			endTimeStr = batchJob.EndTime.Local().Format("20060102-150405")
+		}
+
+		// block format
+		fmt.Printf("%s%s\n", indent, batchJob.Name)
+		fmt.Printf("%s  %s, %s\n", indent, batchJob.DisplayName, batchJob.Model)
+		fmt.Printf("%s  %s, %s, %s\n\n", indent, batchJob.State, createTimeStr, endTimeStr)
+	}
+
+	if !found {
+		fmt.Printf("%sno batch jobs found\n", indent+"  ")
+	}
+}
+
+/*
+cancelBatchJob cancels a pending or running batch job.
+*/
+func cancelBatchJob(name string) {
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	err = client.Batches.Cancel(ctx, name, nil)
+	if err != nil {
+		log.Fatalf("error [%v] cancelling batch job '%s'", err, name)
+	}
+
+	fmt.Printf("  Batch job '%s' cancelled successfully.\n", name)
+}
+
+/*
+deleteBatchJob deletes a batch job (including its results).
+*/
+func deleteBatchJob(name string) {
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	_, err = client.Batches.Delete(ctx, name, nil)
+	if err != nil {
+		log.Fatalf("error [%v] deleting batch job '%s'", err, name)
+	}
+
+	fmt.Printf("  Batch job '%s' deleted successfully.\n", name)
+}
+
+/*
+fetchBatchJobResults fetches the results of a finished batch job. Each result is processed like a regular
+response (prompt/response files, terminal output, history), so that each batch item lands in the history
+directories with its own slug. Opening output applications is suppressed for batch results.
+*/
+func fetchBatchJobResults(name string) {
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	batchJob, err := client.Batches.Get(ctx, name, nil)
+	if err != nil {
+		log.Fatalf("error [%v] getting batch job '%s'", err, name)
+	}
+
+	switch batchJob.State {
+	case genai.JobStateSucceeded, genai.JobStatePartiallySucceeded:
+	default:
+		fmt.Printf("  Batch job '%s' not finished successfully (state = %s), nothing to fetch.\n", name, batchJob.State)
+		return
+	}
+	if batchJob.Dest == nil || len(batchJob.Dest.InlinedResponses) == 0 {
+		fmt.Printf("  Batch job '%s' contains no inlined responses.\n", name)
+		return
+	}
+
+	// do not open an application for each batch result
+	progConfig.MarkdownOutput = false
+	progConfig.HTMLOutput = false
+
+	batchJobName = batchJob.Name
+	defer func() { batchJobName = "" }()
+
+	for i, inlinedResponse := range batchJob.Dest.InlinedResponses {
+		savedConfig := progConfig
+
+		// the model of the job answered all requests (output and usage ledger)
+		if batchJob.Model != "" {
+			progConfig.GeminiAiModel = batchJob.Model
+		}
+
+		prompt := fmt.Sprintf("batch request #%d", i+1)
+		if inlinedResponse.Metadata != nil {
+			if inlinedResponse.Metadata["prompt"] != "" {
+				prompt = inlinedResponse.Metadata["prompt"]
+			}
+			if batchJob.Model == "" && inlinedResponse.Metadata["model"] != "" {
+				progConfig.GeminiAiModel = inlinedResponse.Metadata["model"]
+			}
+			if inlinedResponse.Metadata["tools"] != "" {
+				_ = applyToolOverrides(strings.Split(inlinedResponse.Metadata["tools"], ","))
+			}
+		}
+
+		fmt.Printf("\n  Batch result #%d of %d:\n", i+1, len(batchJob.Dest.InlinedResponses))
+
+		// processing timestamps from batch job (offset ensures unique history filenames)
+		startProcessing = batchJob.StartTime
+		finishProcessing = batchJob.EndTime.Local().Add(time.Duration(i) * time.Second)
+
+		var respErr error
+		switch {
+		case inlinedResponse.Error != nil:
+			respErr = fmt.Errorf("batch request #%d failed: %s", i+1, inlinedResponse.Error.Message)
+		case inlinedResponse.Response == nil:
+			respErr = fmt.Errorf("batch request #%d: no response received", i+1)
+		}
+
+		processPrompt(prompt, false, 0)
+		handleResponse(inlinedResponse.Response, respErr, prompt)
+
+		progConfig = savedConfig
+	}
+}
+
+/*
+handleStandaloneBatchActions handles batch submit, status, list, cancel, delete and fetch.
+*/
+func handleStandaloneBatchActions() {
+	if (*batchSubmit != "" || *batchStatus != "" || *batchList || *batchCancel != "" || *batchDelete != "" || *batchFetch != "") &&
+		!backendFeatureAvailable("Batch mode with inlined requests", "Vertex AI batch jobs require Cloud Storage or BigQuery sources") {
+		os.Exit(1)
+	}
+
+	switch {
+	case *batchSubmit != "":
+		fmt.Printf("\nSubmitting batch requests from '%s':\n", *batchSubmit)
+		submitBatchJob(*batchSubmit)
+		fmt.Printf("\n")
+		os.Exit(0)
+
+	case *batchStatus != "":
+		fmt.Printf("\nStatus of batch job '%s':\n", *batchStatus)
+		showBatchJobStatus(*batchStatus, "  ")
+		fmt.Printf("\n")
+		os.Exit(0)
+
+	case *batchList:
+		fmt.Printf("\nListing batch jobs:\n")
+		listBatchJobs("  ")
+		fmt.Printf("\n")
+		os.Exit(0)
+
+	case *batchCancel != "":
+		fmt.Printf("\nCancelling batch job '%s':\n", *batchCancel)
+		cancelBatchJob(*batchCancel)
+		fmt.Printf("\n")
+		os.Exit(0)
+
+	case *batchDelete != "":
+		fmt.Printf("\nDeleting batch job '%s':\n", *batchDelete)
+		deleteBatchJob(*batchDelete)
+		fmt.Printf("\n")
+		os.Exit(0)
+
+	case *batchFetch != "":
+		fmt.Printf("\nFetching results of batch job '%s':\n", *batchFetch)
+		fetchBatchJobResults(*batchFetch)
+		fmt.Printf("\n")
+		os.Exit(0)
+	}
+}
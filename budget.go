@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// budgetLimit represents one configured budget limit and its current consumption
+type budgetLimit struct {
+	name  string // e.g. 'daily token budget'
+	used  float64
+	limit float64
+	cost  bool // limit in currency (otherwise tokens)
+}
+
+/*
+budgetConfigured reports whether any budget limit is configured.
+*/
+func budgetConfigured() bool {
+	return progConfig.BudgetDailyTokens > 0 || progConfig.BudgetMonthlyTokens > 0 ||
+		progConfig.BudgetDailyCost > 0 || progConfig.BudgetMonthlyCost > 0
+}
+
+/*
+currentBudgetLimits sums up the token usage and estimated cost of the current day and month from the
+usage ledger and returns the configured budget limits with their consumption.
+*/
+func currentBudgetLimits(now time.Time) ([]budgetLimit, error) {
+	entries, err := readLedgerEntries()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var dayTokens, monthTokens, dayCost, monthCost float64
+	today := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+	for _, entry := range entries {
+		timestamp := entry.Timestamp.Local()
+		if timestamp.Format("2006-01") != month {
+			continue
+		}
+		cost, _ := estimateLedgerEntryCost(entry)
+		monthTokens += float64(entry.TotalTokens)
+		monthCost += cost
+		if timestamp.Format("2006-01-02") == today {
+			dayTokens += float64(entry.TotalTokens)
+			dayCost += cost
+		}
+	}
+
+	limits := []budgetLimit{}
+	if progConfig.BudgetDailyTokens > 0 {
+		limits = append(limits, budgetLimit{name: "daily token budget", used: dayTokens, limit: float64(progConfig.BudgetDailyTokens)})
+	}
+	if progConfig.BudgetMonthlyTokens > 0 {
+		limits = append(limits, budgetLimit{name: "monthly token budget", used: monthTokens, limit: float64(progConfig.BudgetMonthlyTokens)})
+	}
+	if progConfig.BudgetDailyCost > 0 {
+		limits = append(limits, budgetLimit{name: "daily cost budget", used: dayCost, limit: progConfig.BudgetDailyCost, cost: true})
+	}
+	if progConfig.BudgetMonthlyCost > 0 {
+		limits = append(limits, budgetLimit{name: "monthly cost budget", used: monthCost, limit: progConfig.BudgetMonthlyCost, cost: true})
+	}
+	return limits, nil
+}
+
+/*
+formatBudgetLimit formats the consumption of a budget limit (e.g. '8.12 of 10.00 USD (81%)').
+*/
+func formatBudgetLimit(limit budgetLimit) string {
+	percent := limit.used * 100.0 / limit.limit
+	if limit.cost {
+		return fmt.Sprintf("%.2f of %.2f %s (%.0f%%)", limit.used, limit.limit, progConfig.UsageCurrency, percent)
+	}
+	return fmt.Sprintf("%.0f of %.0f tokens (%.0f%%)", limit.used, limit.limit, percent)
+}
+
+/*
+checkBudget checks the configured budget limits before a request is sent to Gemini. A warning is printed
+if the consumption reaches BudgetWarnThreshold. An error is returned if a budget is exhausted, unless the
+check is overridden with option -ignore-budget.
+*/
+func checkBudget() error {
+	if !budgetConfigured() {
+		return nil
+	}
+
+	limits, err := currentBudgetLimits(time.Now())
+	if err != nil {
+		return fmt.Errorf("error [%w] reading usage ledger for budget check", err)
+	}
+
+	exhausted := []string{}
+	for _, limit := range limits {
+		percent := limit.used * 100.0 / limit.limit
+		switch {
+		case limit.used >= limit.limit:
+			exhausted = append(exhausted, fmt.Sprintf("%s exhausted: %s", limit.name, formatBudgetLimit(limit)))
+		case progConfig.BudgetWarnThreshold > 0 && percent >= float64(progConfig.BudgetWarnThreshold):
+			fmt.Printf("Warning: %s at %s\n", limit.name, formatBudgetLimit(limit))
+		}
+	}
+
+	if len(exhausted) == 0 {
+		return nil
+	}
+	if *ignoreBudget {
+		fmt.Printf("Warning: %s (overridden with -ignore-budget)\n", strings.Join(exhausted, ", "))
+		return nil
+	}
+	return fmt.Errorf("%s, request not sent (override with -ignore-budget)", strings.Join(exhausted, ", "))
+}
+
+/*
+showBudget prints the configured budget limits and their current consumption.
+*/
+func showBudget(indent string) {
+	limits, err := currentBudgetLimits(time.Now())
+	if err != nil {
+		fmt.Printf("%serror [%v] reading usage ledger\n", indent, err)
+		return
+	}
+	for _, limit := range limits {
+		fmt.Printf("%s%-20s : %s\n", indent, limit.name, formatBudgetLimit(limit))
+	}
+}
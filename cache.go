@@ -25,10 +25,7 @@ func createAIModelSpecificCache(filesToUpload []FileToHandle) {
 
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -62,10 +59,12 @@ func createAIModelSpecificCache(filesToUpload []FileToHandle) {
 	}
 
 	// create cached content
-	cachedContent, err := client.Caches.Create(ctx, progConfig.GeminiAiModel, &genai.CreateCachedContentConfig{
-		TTL:         time.Duration(progConfig.GeminiCacheTimeToLive) * time.Hour,
-		DisplayName: progConfig.GeminiCacheName,
-		Contents:    []*genai.Content{{Role: "user", Parts: parts}},
+	cachedContent, _, err := withRetry(ctx, "Cache creation", func() (*genai.CachedContent, error) {
+		return client.Caches.Create(ctx, progConfig.GeminiAiModel, &genai.CreateCachedContentConfig{
+			TTL:         time.Duration(progConfig.GeminiCacheTimeToLive) * time.Hour,
+			DisplayName: progConfig.GeminiCacheName,
+			Contents:    []*genai.Content{{Role: "user", Parts: parts}},
+		})
 	})
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI cache", err)
@@ -73,6 +72,9 @@ func createAIModelSpecificCache(filesToUpload []FileToHandle) {
 
 	// add cached content details
 	cacheToHandle.CachedContent = *cachedContent
+
+	// record cached tokens and storage hours in usage ledger
+	appendCacheToLedger(cachedContent)
 }
 
 /*
@@ -81,10 +83,7 @@ deleteAIModelSpecificCache deletes AI model specific cache.
 func deleteAIModelSpecificCache() {
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -118,10 +117,7 @@ func listAIModelSpecificCache(indent string) (string, string) {
 
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+var (
+	// citationMarkerRegex matches inline citation markers (candidate number, source number).
+	citationMarkerRegex = regexp.MustCompile(`<!-- AI_CITATION_(\d+)_(\d+) -->`)
+	// sourceMarkerRegex matches anchor markers in front of the numbered list of sources.
+	sourceMarkerRegex = regexp.MustCompile(`<!-- AI_SOURCE_(\d+)_(\d+) -->`)
+)
+
+// citationInsertion represents a citation marker to insert at a byte offset of a text part
+type citationInsertion struct {
+	partIndex int
+	offset    int
+	marker    string
+}
+
+// codeRegion is a byte range of a text part containing code (fenced code block or inline code span)
+type codeRegion struct {
+	start  int
+	end    int
+	fenced bool
+}
+
+/*
+insertCitationMarkers returns a copy of the candidate with inline citation markers inserted into the text.
+Each grounding support (text segment + indices of grounding chunks) is resolved into markers placed at the
+end of the segment. The segment indices are UTF-8 byte offsets within the referenced part. If the offsets
+do not match the segment text, the segment text is searched instead. Insertion points are always aligned
+to rune boundaries and never inside code (see moveOutOfCode). The original candidate (which may be part of
+the chat history) remains unchanged.
+*/
+func insertCitationMarkers(candidate *genai.Candidate, candidateNumber int) *genai.Candidate {
+	if candidate.Content == nil || candidate.GroundingMetadata == nil || len(candidate.GroundingMetadata.GroundingSupports) == 0 {
+		return candidate
+	}
+
+	// copy candidate, content and parts (text of parts will be modified)
+	annotated := *candidate
+	content := *candidate.Content
+	content.Parts = make([]*genai.Part, len(candidate.Content.Parts))
+	for i, part := range candidate.Content.Parts {
+		partCopy := *part
+		content.Parts[i] = &partCopy
+	}
+	annotated.Content = &content
+
+	insertions := []citationInsertion{}
+	codeRegions := map[int][]codeRegion{}
+	for _, support := range candidate.GroundingMetadata.GroundingSupports {
+		if support.Segment == nil || len(support.GroundingChunkIndices) == 0 {
+			continue
+		}
+		partIndex, offset, ok := locateSegmentEnd(content.Parts, support.Segment)
+		if !ok {
+			continue
+		}
+		regions, found := codeRegions[partIndex]
+		if !found {
+			regions = findCodeRegions(content.Parts[partIndex].Text)
+			codeRegions[partIndex] = regions
+		}
+		offset, ok = moveOutOfCode(content.Parts[partIndex].Text, offset, regions)
+		if !ok {
+			continue
+		}
+
+		// build markers (e.g. '[7][8]'), chunk indices are zero-based
+		indices := append([]int32{}, support.GroundingChunkIndices...)
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+		var marker strings.Builder
+		for _, index := range indices {
+			marker.WriteString(fmt.Sprintf("<!-- AI_CITATION_%d_%d -->", candidateNumber, index+1))
+		}
+		insertions = append(insertions, citationInsertion{partIndex: partIndex, offset: offset, marker: marker.String()})
+	}
+
+	// insert from back to front, so that pending offsets remain valid (markers at the same offset keep the
+	// order of the supports)
+	sort.SliceStable(insertions, func(i, j int) bool {
+		if insertions[i].partIndex != insertions[j].partIndex {
+			return insertions[i].partIndex < insertions[j].partIndex
+		}
+		return insertions[i].offset < insertions[j].offset
+	})
+	for i := len(insertions) - 1; i >= 0; i-- {
+		insertion := insertions[i]
+		part := content.Parts[insertion.partIndex]
+		part.Text = part.Text[:insertion.offset] + insertion.marker + part.Text[insertion.offset:]
+	}
+
+	return &annotated
+}
+
+/*
+locateSegmentEnd determines the part and the byte offset of the end of a grounding segment.
+The byte offsets given by Gemini are used if they are consistent with the segment text, otherwise the
+segment text is searched in all (non-thought) text parts. The returned offset is aligned to a rune boundary.
+*/
+func locateSegmentEnd(parts []*genai.Part, segment *genai.Segment) (int, int, bool) {
+	partIndex := int(segment.PartIndex)
+	offset := int(segment.EndIndex)
+
+	valid := partIndex >= 0 && partIndex < len(parts) && !parts[partIndex].Thought &&
+		offset > 0 && offset <= len(parts[partIndex].Text)
+	if valid && segment.Text != "" {
+		valid = strings.HasSuffix(parts[partIndex].Text[:offset], segment.Text)
+	}
+
+	if !valid {
+		if segment.Text == "" {
+			return 0, 0, false
+		}
+		found := false
+		for i, part := range parts {
+			if part.Thought || part.Text == "" {
+				continue
+			}
+			index := strings.Index(part.Text, segment.Text)
+			if index >= 0 {
+				partIndex = i
+				offset = index + len(segment.Text)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, 0, false
+		}
+	}
+
+	// never split a multi-byte character (e.g. German umlauts)
+	text := parts[partIndex].Text
+	for offset < len(text) && !utf8.RuneStart(text[offset]) {
+		offset++
+	}
+
+	return partIndex, offset, true
+}
+
+/*
+findCodeRegions returns the fenced code blocks (lines starting with ```) and the inline code spans (text between
+backtick runs of equal length) of a Markdown text. An unclosed code block extends to the end of the text.
+*/
+func findCodeRegions(text string) []codeRegion {
+	regions := []codeRegion{}
+	fenceStart := -1
+	proseStart := 0
+	for lineStart := 0; lineStart < len(text); {
+		lineEnd := len(text)
+		if index := strings.IndexByte(text[lineStart:], '\n'); index >= 0 {
+			lineEnd = lineStart + index + 1
+		}
+		if strings.HasPrefix(strings.TrimSpace(text[lineStart:lineEnd]), "```") {
+			if fenceStart < 0 {
+				regions = append(regions, findCodeSpans(text, proseStart, lineStart)...)
+				fenceStart = lineStart
+			} else {
+				regions = append(regions, codeRegion{start: fenceStart, end: lineEnd, fenced: true})
+				fenceStart = -1
+				proseStart = lineEnd
+			}
+		}
+		lineStart = lineEnd
+	}
+	if fenceStart >= 0 {
+		return append(regions, codeRegion{start: fenceStart, end: len(text), fenced: true})
+	}
+	return append(regions, findCodeSpans(text, proseStart, len(text))...)
+}
+
+/*
+findCodeSpans returns the inline code spans between the byte offsets from and to. A backtick run without a
+closing run of the same length is no code span.
+*/
+func findCodeSpans(text string, from int, to int) []codeRegion {
+	regions := []codeRegion{}
+	for i := from; i < to; {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		length := backtickRunLength(text, i, to)
+		closing := -1
+		for j := i + length; j < to; {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+			runLength := backtickRunLength(text, j, to)
+			if runLength == length {
+				closing = j + runLength
+				break
+			}
+			j += runLength
+		}
+		if closing < 0 {
+			i += length
+			continue
+		}
+		regions = append(regions, codeRegion{start: i, end: closing})
+		i = closing
+	}
+	return regions
+}
+
+/*
+backtickRunLength returns the number of consecutive backticks starting at byte offset i (up to offset to).
+*/
+func backtickRunLength(text string, i int, to int) int {
+	length := 0
+	for i+length < to && text[i+length] == '`' {
+		length++
+	}
+	return length
+}
+
+/*
+moveOutOfCode moves an insertion offset out of code: behind an inline code span, or in front of a fenced code
+block (end of the preceding text, a marker on a fence line would break the block). It reports false if there is
+no text in front of the code block.
+*/
+func moveOutOfCode(text string, offset int, regions []codeRegion) (int, bool) {
+	for _, region := range regions {
+		switch {
+		case region.fenced && offset >= region.start && offset <= region.end:
+			before := len(strings.TrimRight(text[:region.start], " \t\r\n"))
+			if before == 0 {
+				return 0, false
+			}
+			return moveOutOfCode(text, before, regions)
+		case !region.fenced && offset > region.start && offset < region.end:
+			return region.end, true
+		}
+	}
+	return offset, true
+}
+
+/*
+replaceCitationMarkersForMarkdown replaces the citation markers with plain numbered references
+(e.g. '[7][8]'), used for Markdown and ANSI output. Source anchors are removed.
+*/
+func replaceCitationMarkersForMarkdown(md string) string {
+	md = citationMarkerRegex.ReplaceAllString(md, "[$2]")
+	md = sourceMarkerRegex.ReplaceAllString(md, "")
+	return md
+}
+
+/*
+replaceCitationMarkersForHTML replaces the citation markers with clickable anchors pointing to the
+corresponding entry in the list of sources.
+*/
+func replaceCitationMarkersForHTML(htmlData string) string {
+	htmlData = citationMarkerRegex.ReplaceAllString(htmlData, `<a class="footnote-ref" href="#source-$1-$2">[$2]</a>`)
+	htmlData = sourceMarkerRegex.ReplaceAllString(htmlData, `<span id="source-$1-$2"></span>`)
+	return htmlData
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// citationSupport is a grounding support of a test case (segment end, segment text, chunk indices)
+type citationSupport struct {
+	endIndex int32
+	text     string
+	chunks   []int32
+}
+
+func TestInsertCitationMarkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		supports []citationSupport
+		want     string
+	}{
+		{
+			name:     "byte offsets",
+			text:     "Die Größe ist groß. Weiter.",
+			supports: []citationSupport{{endIndex: 22, text: "Die Größe ist groß.", chunks: []int32{0}}},
+			want:     "Die Größe ist groß.[1] Weiter.",
+		},
+		{
+			name:     "end inside umlaut",
+			text:     "Größe",
+			supports: []citationSupport{{endIndex: 3, chunks: []int32{0}}},
+			want:     "Grö[1]ße",
+		},
+		{
+			name:     "rune offsets (searched)",
+			text:     "Über Äpfel. Rest",
+			supports: []citationSupport{{endIndex: 11, text: "Über Äpfel.", chunks: []int32{1}}},
+			want:     "Über Äpfel.[2] Rest",
+		},
+		{
+			name:     "end inside inline code span",
+			text:     "Nutze `go test ./...` hier.",
+			supports: []citationSupport{{endIndex: 12, chunks: []int32{0}}},
+			want:     "Nutze `go test ./...`[1] hier.",
+		},
+		{
+			name:     "end inside double backtick span",
+			text:     "Mit ``a`b`` geht es.",
+			supports: []citationSupport{{endIndex: 7, chunks: []int32{0}}},
+			want:     "Mit ``a`b``[1] geht es.",
+		},
+		{
+			name:     "end inside fenced block",
+			text:     "Intro text.\n\n```go\nfmt.Println()\n```\nEnd.",
+			supports: []citationSupport{{endIndex: 25, chunks: []int32{0}}},
+			want:     "Intro text.[1]\n\n```go\nfmt.Println()\n```\nEnd.",
+		},
+		{
+			name:     "fenced block without preceding text",
+			text:     "```\ncode\n```\nEnd.",
+			supports: []citationSupport{{endIndex: 6, chunks: []int32{0}}},
+			want:     "```\ncode\n```\nEnd.",
+		},
+		{
+			name: "unsorted supports",
+			text: "Eins. Zwei. Drei.",
+			supports: []citationSupport{
+				{endIndex: 17, text: "Drei.", chunks: []int32{2}},
+				{endIndex: 5, text: "Eins.", chunks: []int32{0}},
+				{endIndex: 11, text: "Zwei.", chunks: []int32{1}},
+			},
+			want: "Eins.[1] Zwei.[2] Drei.[3]",
+		},
+		{
+			name: "overlapping supports",
+			text: "Eins. Zwei. Drei.",
+			supports: []citationSupport{
+				{endIndex: 11, text: "Eins. Zwei.", chunks: []int32{3, 0}},
+				{endIndex: 11, text: "Zwei.", chunks: []int32{1}},
+				{endIndex: 17, text: "Zwei. Drei.", chunks: []int32{2}},
+			},
+			want: "Eins. Zwei.[1][4][2] Drei.[3]",
+		},
+		{
+			name:     "segment not found",
+			text:     "Eins.",
+			supports: []citationSupport{{endIndex: 40, text: "Zwei.", chunks: []int32{0}}},
+			want:     "Eins.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidate := &genai.Candidate{
+				Content:           genai.NewContentFromText(test.text, genai.RoleModel),
+				GroundingMetadata: &genai.GroundingMetadata{},
+			}
+			for _, support := range test.supports {
+				candidate.GroundingMetadata.GroundingSupports = append(candidate.GroundingMetadata.GroundingSupports, &genai.GroundingSupport{
+					Segment:               &genai.Segment{EndIndex: support.endIndex, Text: support.text},
+					GroundingChunkIndices: support.chunks,
+				})
+			}
+
+			annotated := insertCitationMarkers(candidate, 1)
+			got := replaceCitationMarkersForMarkdown(annotated.Content.Parts[0].Text)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if candidate.Content.Parts[0].Text != test.text {
+				t.Errorf("original candidate modified: %q", candidate.Content.Parts[0].Text)
+			}
+		})
+	}
+}
+
+func TestFindCodeRegions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []codeRegion
+	}{
+		{name: "no code", text: "Größe ohne Code", want: []codeRegion{}},
+		{name: "inline span", text: "a `b` c", want: []codeRegion{{start: 2, end: 5}}},
+		{name: "inline span after umlaut", text: "ä `b`", want: []codeRegion{{start: 3, end: 6}}},
+		{name: "double backticks", text: "``a`b`` c", want: []codeRegion{{start: 0, end: 7}}},
+		{name: "unclosed backtick", text: "a `b c", want: []codeRegion{}},
+		{name: "fenced block", text: "x\n```\n`y`\n```\nz `w`", want: []codeRegion{{start: 2, end: 14, fenced: true}, {start: 16, end: 19}}},
+		{name: "unclosed fenced block", text: "`x`\n```go\ncode", want: []codeRegion{{start: 0, end: 3}, {start: 4, end: 14, fenced: true}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := findCodeRegions(test.text)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -13,7 +15,15 @@ import (
 // ProgConfig represents program configuration
 type ProgConfig struct {
 	// Gemini configuration
-	GeminiAPIKey string `yaml:"GeminiAPIKey"`
+	GeminiAPIKey  string `yaml:"GeminiAPIKey"`
+	GeminiBaseURL string `yaml:"GeminiBaseURL"` // empty = Google default endpoint
+	GeminiBackend string `yaml:"GeminiBackend"` // 'gemini' (Gemini Developer API) or 'vertex' (Vertex AI)
+
+	// Vertex AI configuration (GeminiBackend: vertex)
+	VertexProject     string   `yaml:"VertexProject"`
+	VertexLocation    string   `yaml:"VertexLocation"`
+	VertexCredentials string   `yaml:"VertexCredentials"` // service account JSON (empty = Application Default Credentials)
+	VertexFileURIs    []string `yaml:"VertexFileURIs"`    // Cloud Storage files (gs://) included via -include-files
 
 	GeminiAiModel           string // one of the following models
 	GeminiLiteAiModel       string `yaml:"GeminiLiteAiModel"`
@@ -23,19 +33,30 @@ type ProgConfig struct {
 	GeminiProImageAiModel   string `yaml:"GeminiProImageAiModel"`
 	GeminiDefaultAiModel    string `yaml:"GeminiDefaultAiModel"`
 
+	GeminiFallbackAiModels []string `yaml:"GeminiFallbackAiModels"` // tried in order on quota or unavailability errors
+
 	GeminiResponseModalities []string `yaml:"GeminiResponseModalities"`
 	GeminiImageAspectRatio   string   `yaml:"GeminiImageAspectRatio"`
 	GeminiImageResolution    string   `yaml:"GeminiImageResolution"`
 
 	GeminiCandidateCount  *int32   `yaml:"GeminiCandidateCount"`
 	GeminiPureResponse    bool     `yaml:"GeminiPureResponse"`
+	GeminiStreamResponse  bool     `yaml:"GeminiStreamResponse"`
 	GeminiMaxOutputTokens *int32   `yaml:"GeminiMaxOutputTokens"`
 	GeminiTemperature     *float32 `yaml:"GeminiTemperature"`
 	GeminiTopP            *float32 `yaml:"GeminiTopP"`
 	GeminiTopK            *float32 `yaml:"GeminiTopK"`
 
+	// Auto-continuation of responses cut off at the output token limit (finish reason MAX_TOKENS)
+	GeminiAutoContinue         bool `yaml:"GeminiAutoContinue"`
+	GeminiAutoContinueMaxSteps int  `yaml:"GeminiAutoContinueMaxSteps"` // maximum number of continuations per response
+
+	// Structured output: responses as JSON according to a JSON Schema (empty = Markdown text)
+	GeminiResponseSchemaFile string `yaml:"GeminiResponseSchemaFile"`
+
 	GeminiGroundingWithCodeExecution    bool     `yaml:"GeminiGroundingWithCodeExecution"`
 	GeminiGroundingWithGoogleSearch     bool     `yaml:"GeminiGroundingWithGoogleSearch"`
+	GeminiGoogleSearchExcludeDomains    []string `yaml:"GeminiGoogleSearchExcludeDomains"` // Vertex AI only
 	GeminiGroundingWithURLContext       bool     `yaml:"GeminiGroundingWithURLContext"`
 	GeminiGroundigWithGoogleMaps        bool     `yaml:"GeminiGroundigWithGoogleMaps"`
 	GeminiGroundingWithFileSearchStores []string `yaml:"GeminiGroundingWithFileSearchStores"`
@@ -84,11 +105,21 @@ type ProgConfig struct {
 	HTMLFooter                   string              `yaml:"HTMLFooter"`
 
 	// Input configuration
-	InputFromTerminal  bool   `yaml:"InputFromTerminal"`
-	InputFromFile      bool   `yaml:"InputFromFile"`
-	InputFile          string `yaml:"InputFile"`
-	InputFromLocalhost bool   `yaml:"InputFromLocalhost"`
-	InputLocalhostPort int    `yaml:"InputLocalhostPort"`
+	InputFromTerminal    bool   `yaml:"InputFromTerminal"`
+	InputFromFile        bool   `yaml:"InputFromFile"`
+	InputFile            string `yaml:"InputFile"`
+	InputFromLocalhost   bool   `yaml:"InputFromLocalhost"`
+	InputLocalhostPort   int    `yaml:"InputLocalhostPort"`
+	InputLocalhostOpenAI bool   `yaml:"InputLocalhostOpenAI"`
+
+	// JSON API protection (bearer token, browser origins, files root)
+	InputLocalhostAPIToken       string   `yaml:"InputLocalhostAPIToken"`       // 'pass:', 'env:' or 'file:' (empty = JSON API disabled)
+	InputLocalhostAllowedOrigins []string `yaml:"InputLocalhostAllowedOrigins"` // localhost origins of web pages (CORS)
+	InputLocalhostFilesRoot      string   `yaml:"InputLocalhostFilesRoot"`      // files of API requests (empty = current directory)
+
+	// Chat session configuration
+	ChatSessionHistory   bool   `yaml:"ChatSessionHistory"`
+	ChatSessionDirectory string `yaml:"ChatSessionDirectory"`
 
 	// Notification configuration
 	NotifyPrompt                     bool `yaml:"NotifyPrompt"`
@@ -105,44 +136,104 @@ type ProgConfig struct {
 	NotifyResponseApplicationOther   string `yaml:"NotifyResponseApplicationOther"`
 
 	// General configuration
-	GeneralInternetProxy string   `yaml:"GeneralInternetProxy"`
-	MIMETypeReplacements []string `yaml:"MIMETypeReplacements"`
+	GeneralInternetProxy  string   `yaml:"GeneralInternetProxy"`
+	GeneralCABundle       string   `yaml:"GeneralCABundle"`
+	GeneralConnectTimeout int      `yaml:"GeneralConnectTimeout"` // seconds
+	GeneralRequestTimeout int      `yaml:"GeneralRequestTimeout"` // seconds (0 = no timeout)
+	MIMETypeReplacements  []string `yaml:"MIMETypeReplacements"`
+
+	// Retry configuration
+	RetryMaxAttempts int `yaml:"RetryMaxAttempts"`
+	RetryBaseDelay   int `yaml:"RetryBaseDelay"`
+	RetryMaxDelay    int `yaml:"RetryMaxDelay"`
+	RetryJitter      int `yaml:"RetryJitter"`
+
+	// Usage ledger configuration
+	UsageLedger     bool                  `yaml:"UsageLedger"`
+	UsageLedgerFile string                `yaml:"UsageLedgerFile"`
+	UsageCurrency   string                `yaml:"UsageCurrency"`
+	UsagePrices     map[string]ModelPrice `yaml:"UsagePrices"`
+
+	// Trace configuration
+	TraceRequests bool   `yaml:"TraceRequests"`
+	TraceFile     string `yaml:"TraceFile"`
+	TraceMaxSize  int    `yaml:"TraceMaxSize"`  // MB (0 = no rotation)
+	TraceMaxFiles int    `yaml:"TraceMaxFiles"` // number of rotated trace files kept
+
+	// Pre-flight token count configuration
+	PreflightTokenCount   bool `yaml:"PreflightTokenCount"`
+	PreflightAbortOnLimit bool `yaml:"PreflightAbortOnLimit"`
+
+	// Budget configuration (0 = no limit)
+	BudgetDailyTokens   int64   `yaml:"BudgetDailyTokens"`
+	BudgetMonthlyTokens int64   `yaml:"BudgetMonthlyTokens"`
+	BudgetDailyCost     float64 `yaml:"BudgetDailyCost"`
+	BudgetMonthlyCost   float64 `yaml:"BudgetMonthlyCost"`
+	BudgetWarnThreshold int     `yaml:"BudgetWarnThreshold"` // percent of budget
+
+	// Fake server configuration
+	FakeServerAddress string `yaml:"FakeServerAddress"`
+	FakeServerScript  string `yaml:"FakeServerScript"` // JSONL with scripted responses (e.g. trace file)
+
+	// Function calling configuration (local functions, see section 'Functions')
+	FunctionCalling         bool                 `yaml:"FunctionCalling"`
+	FunctionCallingMaxSteps int                  `yaml:"FunctionCallingMaxSteps"`
+	FunctionCallingApproval bool                 `yaml:"FunctionCallingApproval"` // ask before each call
+	FunctionCallingTimeout  int                  `yaml:"FunctionCallingTimeout"`  // seconds (0 = no timeout)
+	Functions               []FunctionDefinition `yaml:"Functions"`
+
+	// Workspace tools configuration (built-in read-only functions, restricted to the sandbox root)
+	WorkspaceTools       bool     `yaml:"WorkspaceTools"`
+	WorkspaceRoot        string   `yaml:"WorkspaceRoot"`        // empty = current directory
+	WorkspaceMaxFileSize int      `yaml:"WorkspaceMaxFileSize"` // KiB
+	WorkspaceMaxResults  int      `yaml:"WorkspaceMaxResults"`
+	WorkspaceDenyList    []string `yaml:"WorkspaceDenyList"`
+
+	// MCP client configuration (tools of Model Context Protocol servers, stdio transport)
+	MCPTools   bool              `yaml:"MCPTools"`
+	MCPServers []MCPServerConfig `yaml:"MCPServers"`
 
 	// System instruction
 	UserSystemInstruction    string `yaml:"UserSystemInstruction"`
 	IncludeSystemInstruction bool   `yaml:"IncludeSystemInstruction"`
+
+	// Profiles (named overrides of the base configuration, selected with -profile)
+	Profiles      map[string]yaml.Node `yaml:"Profiles"`
+	ActiveProfile string               `yaml:"-"`
 }
 
 // progConfig contains program configuration
 var progConfig = ProgConfig{}
 
 /*
-loadConfiguration loads program configuration from a YAML file. It reads the specified YAML file,
-unmarshals it into the global `progConfig` struct, performs extensive validation checks on the loaded
-values, sets OS-specific configurations (e.g., application paths), and resolves secrets like the
-Gemini API key and proxy credentials using the `getPassword` helper. It returns an error if reading,
-unmarshalling, validation, or secret retrieval fails.
+loadConfiguration loads program configuration from its layers (built-in defaults, global configuration,
+project configuration file, profile, environment variables) into the global `progConfig` struct, performs
+extensive validation checks on the loaded values, sets OS-specific configurations (e.g., application
+paths), and resolves secrets like the Gemini API key and proxy credentials using the `getPassword` helper.
+It returns an error if reading, unmarshalling, validation, or secret retrieval fails.
 */
-func loadConfiguration(configFile string) error {
+func loadConfiguration(configFile string, configRequired bool, profileName string) error {
 	operatingSystem := runtime.GOOS
 
-	source, err := os.ReadFile(configFile)
-	if err != nil {
-		return fmt.Errorf("error [%w] reading configuration file", err)
-	}
-	err = yaml.Unmarshal(source, &progConfig)
+	err := loadConfigurationLayers(configFile, configRequired, profileName)
 	if err != nil {
-		return fmt.Errorf("error [%w] unmarshalling configuration file", err)
+		return err
 	}
 
 	// gemini
-	if progConfig.GeminiAPIKey == "" {
+	switch progConfig.GeminiBackend {
+	case "":
+		progConfig.GeminiBackend = backendGeminiAPI
+	case backendGeminiAPI, backendVertexAI:
+	default:
+		return fmt.Errorf("invalid GeminiBackend [%s] ('gemini' or 'vertex')", progConfig.GeminiBackend)
+	}
+	if progConfig.GeminiAPIKey == "" && !isVertexBackend() {
 		return fmt.Errorf("empty GeminiAPIKey not allowed")
 	}
 	if progConfig.GeminiCandidateCount == nil || *progConfig.GeminiCandidateCount <= 0 {
 		return fmt.Errorf("empty or invalid GeminiCandidateCount not allowed")
 	}
-
 	// markdown
 	if progConfig.MarkdownPromptResponseFile == "" {
 		return fmt.Errorf("empty MarkdownPromptResponseFile not allowed")
@@ -193,6 +284,11 @@ func loadConfiguration(configFile string) error {
 		return fmt.Errorf("empty HTMLHistoryDirectory not allowed")
 	}
 
+	// chat session
+	if progConfig.ChatSessionHistory && progConfig.ChatSessionDirectory == "" {
+		return fmt.Errorf("empty ChatSessionDirectory not allowed")
+	}
+
 	// input
 	if progConfig.InputFromFile && progConfig.InputFile == "" {
 		return fmt.Errorf("empty InputFile not allowed")
@@ -226,10 +322,33 @@ func loadConfiguration(configFile string) error {
 		return fmt.Errorf("empty operating system specific NotifyResponseApplication not allowed")
 	}
 
-	// get api-key (password)
-	progConfig.GeminiAPIKey, err = getPassword(progConfig.GeminiAPIKey)
-	if err != nil {
-		return fmt.Errorf("error [%w] getting api-key", err)
+	// get api-key (password), Vertex AI uses project credentials instead
+	if isVertexBackend() {
+		err = validateVertexConfiguration()
+		if err != nil {
+			return err
+		}
+	} else {
+		progConfig.GeminiAPIKey, err = getPassword(progConfig.GeminiAPIKey)
+		if err != nil {
+			return fmt.Errorf("error [%w] getting api-key", err)
+		}
+		if len(progConfig.GeminiGoogleSearchExcludeDomains) > 0 {
+			fmt.Printf("Note: GeminiGoogleSearchExcludeDomains ignored (not supported by Gemini API backend)\n")
+		}
+	}
+
+	// get bearer token of JSON API (password)
+	if progConfig.InputLocalhostAPIToken != "" {
+		progConfig.InputLocalhostAPIToken, err = getPassword(progConfig.InputLocalhostAPIToken)
+		if err != nil {
+			return fmt.Errorf("error [%w] getting JSON API token", err)
+		}
+	}
+	for _, origin := range progConfig.InputLocalhostAllowedOrigins {
+		if !isLocalhostOrigin(origin) {
+			return fmt.Errorf("invalid InputLocalhostAllowedOrigins entry [%s] (localhost origins only)", origin)
+		}
 	}
 
 	// get internet proxy (password)
@@ -240,6 +359,33 @@ func loadConfiguration(configFile string) error {
 		}
 	}
 
+	if progConfig.GeminiBaseURL != "" {
+		baseURL, err := url.Parse(progConfig.GeminiBaseURL)
+		if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+			return fmt.Errorf("invalid GeminiBaseURL [%s]", progConfig.GeminiBaseURL)
+		}
+	}
+
+	if progConfig.GeneralCABundle != "" && !fileExists(progConfig.GeneralCABundle) {
+		return fmt.Errorf("CA bundle [%s] not found", progConfig.GeneralCABundle)
+	}
+	if progConfig.GeneralConnectTimeout < 0 || progConfig.GeneralRequestTimeout < 0 {
+		return fmt.Errorf("negative timeout values not allowed")
+	}
+
+	err = validateFunctionDefinitions()
+	if err != nil {
+		return err
+	}
+	err = validateWorkspaceConfiguration()
+	if err != nil {
+		return err
+	}
+	err = validateMCPConfiguration()
+	if err != nil {
+		return err
+	}
+
 	// MIME type replacement
 	if len(progConfig.MIMETypeReplacements) > 0 {
 		mimeMap, err := parseMIMETypeReplacements(progConfig.MIMETypeReplacements)
@@ -249,14 +395,50 @@ func loadConfiguration(configFile string) error {
 		ReplacementMIMETypeMap = mimeMap
 	}
 
+	// retry
+	if progConfig.RetryMaxAttempts < 0 || progConfig.RetryBaseDelay < 0 || progConfig.RetryMaxDelay < 0 {
+		return fmt.Errorf("negative retry values not allowed")
+	}
+	if progConfig.RetryJitter < 0 || progConfig.RetryJitter > 100 {
+		return fmt.Errorf("invalid RetryJitter [%d] (0-100 percent)", progConfig.RetryJitter)
+	}
+
+	// usage ledger
+	if progConfig.UsageLedger && progConfig.UsageLedgerFile == "" {
+		return fmt.Errorf("empty UsageLedgerFile not allowed")
+	}
+
+	// trace
+	if progConfig.TraceRequests && progConfig.TraceFile == "" {
+		return fmt.Errorf("empty TraceFile not allowed")
+	}
+	if progConfig.TraceMaxSize < 0 || progConfig.TraceMaxFiles < 0 {
+		return fmt.Errorf("negative trace values not allowed")
+	}
+
+	// fake server
+	if progConfig.FakeServerScript != "" && !fileExists(progConfig.FakeServerScript) {
+		return fmt.Errorf("fake server script [%s] not found", progConfig.FakeServerScript)
+	}
+
+	// budget
+	if progConfig.BudgetDailyTokens < 0 || progConfig.BudgetMonthlyTokens < 0 || progConfig.BudgetDailyCost < 0 || progConfig.BudgetMonthlyCost < 0 {
+		return fmt.Errorf("negative budget values not allowed")
+	}
+	if progConfig.BudgetWarnThreshold < 0 || progConfig.BudgetWarnThreshold > 100 {
+		return fmt.Errorf("invalid BudgetWarnThreshold [%d] (0-100 percent)", progConfig.BudgetWarnThreshold)
+	}
+	if budgetConfigured() && !progConfig.UsageLedger {
+		return fmt.Errorf("budget limits require UsageLedger")
+	}
+
 	// thinking
 	if progConfig.GeminiMaxThinkingBudget != nil && progConfig.GeminiThinkingLevel != "" {
 		return fmt.Errorf("do not set both thinking budget and thinking level")
 	}
 	if progConfig.GeminiThinkingLevel != "" {
 		switch strings.ToLower(progConfig.GeminiThinkingLevel) {
-		case "low":
-		case "high":
+		case "minimal", "low", "medium", "high":
 		default:
 			return fmt.Errorf("unsupported thinking level [%s]", progConfig.GeminiThinkingLevel)
 		}
@@ -290,16 +472,50 @@ func loadConfiguration(configFile string) error {
 	return nil
 }
 
+/*
+validateEffectiveConfiguration checks configuration values which can be changed by cli parameters. It is called
+after the cli parameters have been applied (overwriteConfigValues).
+*/
+func validateEffectiveConfiguration() error {
+	if progConfig.GeminiAutoContinue && progConfig.GeminiAutoContinueMaxSteps < 1 {
+		return fmt.Errorf("GeminiAutoContinueMaxSteps must be at least 1")
+	}
+	return nil
+}
+
 /*
 showConfiguration shows / prints the loaded program configuration to the console. It displays the current
 program configuration settings to the user in the console for review.
 */
 func showConfiguration() {
 	// general notes
-	fmt.Printf("\nNotes concerning the freely available version of 'Google Gemini AI':\n")
-	fmt.Printf("  See the help page for the 'Google Gemini AI' terms of service.\n")
-	fmt.Printf("  All input data will be used by Google to improve 'Gemini AI'.\n")
-	fmt.Printf("  Therefore, do not process any private or confidential data.\n")
+	if isVertexBackend() {
+		fmt.Printf("\nNotes concerning 'Vertex AI':\n")
+		fmt.Printf("  All input data is processed under the terms of your GCP project.\n")
+		fmt.Printf("  Project  : %s\n", progConfig.VertexProject)
+		fmt.Printf("  Location : %s\n", progConfig.VertexLocation)
+		if progConfig.VertexCredentials != "" {
+			fmt.Printf("  Auth     : service account (%s)\n", progConfig.VertexCredentials)
+		} else {
+			fmt.Printf("  Auth     : Application Default Credentials\n")
+		}
+	} else {
+		fmt.Printf("\nNotes concerning the freely available version of 'Google Gemini AI':\n")
+		fmt.Printf("  See the help page for the 'Google Gemini AI' terms of service.\n")
+		fmt.Printf("  All input data will be used by Google to improve 'Gemini AI'.\n")
+		fmt.Printf("  Therefore, do not process any private or confidential data.\n")
+	}
+
+	if len(progConfig.Profiles) > 0 {
+		fmt.Printf("\nProfiles:\n")
+		for _, name := range profileNames() {
+			marker := ""
+			if name == progConfig.ActiveProfile {
+				marker = " (active)"
+			}
+			fmt.Printf("  %s%s\n", name, marker)
+		}
+	}
 
 	fmt.Printf("\nInput from:\n")
 	if progConfig.InputFromTerminal {
@@ -310,6 +526,12 @@ func showConfiguration() {
 	}
 	if progConfig.InputFromLocalhost {
 		fmt.Printf("  localhost : %v (port)\n", progConfig.InputLocalhostPort)
+		if progConfig.InputLocalhostOpenAI {
+			fmt.Printf("  OpenAI    : http://localhost:%v/v1/chat/completions\n", progConfig.InputLocalhostPort)
+		}
+		if progConfig.InputLocalhostAPIToken == "" {
+			fmt.Printf("  JSON API  : disabled (no InputLocalhostAPIToken)\n")
+		}
 	}
 
 	fmt.Printf("\nRendering:\n")
@@ -331,6 +553,9 @@ func showConfiguration() {
 	if progConfig.HTMLHistory {
 		fmt.Printf("  HTML     : %v\n", progConfig.HTMLHistoryDirectory)
 	}
+	if progConfig.ChatSessionHistory {
+		fmt.Printf("  Sessions : %v\n", progConfig.ChatSessionDirectory)
+	}
 
 	fmt.Printf("\nOutput:\n")
 	if progConfig.AnsiOutput {
@@ -343,6 +568,77 @@ func showConfiguration() {
 		fmt.Printf("  HTML     : execute application\n")
 	}
 
+	if progConfig.RetryMaxAttempts > 1 {
+		fmt.Printf("\nRetry:\n")
+		fmt.Printf("  Attempts : %d (delay %d-%d secs, jitter %d%%)\n", progConfig.RetryMaxAttempts,
+			progConfig.RetryBaseDelay, progConfig.RetryMaxDelay, progConfig.RetryJitter)
+	}
+	if progConfig.UsageLedger {
+		fmt.Printf("\nUsage Ledger:\n")
+		fmt.Printf("  File     : %s\n", progConfig.UsageLedgerFile)
+		fmt.Printf("  Prices   : %d %s (%s)\n", len(progConfig.UsagePrices), pluralize(len(progConfig.UsagePrices), "model"), progConfig.UsageCurrency)
+	}
+	if progConfig.TraceRequests {
+		fmt.Printf("\nTrace:\n")
+		fmt.Printf("  File     : %s (rotated at %d MB, %d files kept)\n", progConfig.TraceFile, progConfig.TraceMaxSize, progConfig.TraceMaxFiles)
+	}
+	if progConfig.PreflightTokenCount {
+		fmt.Printf("\nPre-flight Token Count:\n")
+		fmt.Printf("  On limit : %s\n", map[bool]string{true: "abort", false: "warn"}[progConfig.PreflightAbortOnLimit])
+	}
+	if len(progConfig.GeminiGoogleSearchExcludeDomains) > 0 && isVertexBackend() {
+		fmt.Printf("\nGoogle Search Excluded Domains:\n")
+		for _, domain := range progConfig.GeminiGoogleSearchExcludeDomains {
+			fmt.Printf("  %s\n", domain)
+		}
+	}
+	if progConfig.GeminiBaseURL != "" {
+		fmt.Printf("\nAPI Endpoint:\n")
+		fmt.Printf("  Base URL : %s\n", progConfig.GeminiBaseURL)
+	}
+	if budgetConfigured() {
+		fmt.Printf("\nBudget (warn at %d%%):\n", progConfig.BudgetWarnThreshold)
+		showBudget("  ")
+	}
+	if len(progConfig.GeminiFallbackAiModels) > 0 {
+		fmt.Printf("\nFallback Models:\n")
+		for _, model := range progConfig.GeminiFallbackAiModels {
+			fmt.Printf("  %s\n", resolveModelAlias(model))
+		}
+	}
+
+	if progConfig.WorkspaceTools {
+		root, err := workspaceRootDir()
+		if err != nil {
+			root = fmt.Sprintf("error [%v]", err)
+		}
+		fmt.Printf("\nWorkspace Tools (read_file, list_directory, glob, grep, file_stat):\n")
+		fmt.Printf("  Root     : %s\n", root)
+		fmt.Printf("  Limits   : %d KiB per file, %d results\n", progConfig.WorkspaceMaxFileSize, progConfig.WorkspaceMaxResults)
+		fmt.Printf("  Denied   : %s\n", strings.Join(progConfig.WorkspaceDenyList, ", "))
+	}
+	if progConfig.MCPTools {
+		fmt.Printf("\nMCP Servers:\n")
+		for _, server := range progConfig.MCPServers {
+			state := "not started"
+			if client, ok := mcpClients[server.Name]; ok {
+				state = fmt.Sprintf("%s, %d %s", client.serverInfo, len(client.tools), pluralize(len(client.tools), "tool"))
+			}
+			fmt.Printf("  %-20s : %s (%s)\n", server.Name, server.Command, state)
+		}
+	}
+	if progConfig.FunctionCalling {
+		fmt.Printf("\nFunction Calling (max. %d %s, approval: %v):\n", progConfig.FunctionCallingMaxSteps,
+			pluralize(progConfig.FunctionCallingMaxSteps, "step"), progConfig.FunctionCallingApproval)
+		for _, function := range progConfig.Functions {
+			target := function.Command
+			if function.HTTPEndpoint != "" {
+				target = "POST " + function.HTTPEndpoint
+			}
+			fmt.Printf("  %-20s : %s\n", function.Name, target)
+		}
+	}
+
 	if len(progConfig.MIMETypeReplacements) > 0 {
 		fmt.Printf("\nMIME Type Replacements:\n")
 		for _, replacement := range progConfig.MIMETypeReplacements {
@@ -391,6 +687,13 @@ func initializeProgram() {
 			writeAssets(progConfig.HTMLHistoryDirectory)
 		}
 	}
+	if progConfig.ChatSessionHistory {
+		err = os.Mkdir(progConfig.ChatSessionDirectory, 0750)
+		if err != nil && !os.IsExist(err) {
+			fmt.Printf("error [%v] at os.Mkdir()\n", err)
+			os.Exit(1)
+		}
+	}
 }
 
 /*
@@ -467,9 +770,9 @@ func generateGeminiModelConfig(isImageRequest bool, cacheName string, storeNames
 		// FrequencyPenalty
 		// Seed *int32
 		// ResponseMIMEType string
-		ResponseMIMEType: "text/plain", // always expected: plain text with markdown tags
+		ResponseMIMEType: "text/plain", // plain text with markdown tags (structured output: application/json)
 		// ResponseSchema *Schema
-		// ResponseJsonSchema any
+		// ResponseJsonSchema any (structured output, see below)
 		// RoutingConfig *GenerationConfigRoutingConfig
 		// ModelSelectionConfig *ModelSelectionConfig
 		// SafetySettings []*SafetySetting
@@ -484,6 +787,11 @@ func generateGeminiModelConfig(isImageRequest bool, cacheName string, storeNames
 		// ThinkingConfig *ThinkingConfig
 		// ImageConfig *ImageConfig
 	}
+	// structured output: JSON according to the given JSON Schema (not for image models)
+	if structuredOutputActive() && !isImageRequest {
+		generateContentConfig.ResponseMIMEType = "application/json"
+		generateContentConfig.ResponseJsonSchema = responseSchema
+	}
 	// configure AI model parameters
 	if progConfig.GeminiCandidateCount != nil {
 		generateContentConfig.CandidateCount = *progConfig.GeminiCandidateCount
@@ -525,7 +833,11 @@ func generateGeminiModelConfig(isImageRequest bool, cacheName string, storeNames
 		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{CodeExecution: &genai.ToolCodeExecution{}})
 	}
 	if progConfig.GeminiGroundingWithGoogleSearch {
-		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{GoogleSearch: &genai.GoogleSearch{}})
+		googleSearch := &genai.GoogleSearch{}
+		if isVertexBackend() {
+			googleSearch.ExcludeDomains = progConfig.GeminiGoogleSearchExcludeDomains
+		}
+		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{GoogleSearch: googleSearch})
 	}
 	if progConfig.GeminiGroundingWithURLContext {
 		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{URLContext: &genai.URLContext{}})
@@ -533,6 +845,9 @@ func generateGeminiModelConfig(isImageRequest bool, cacheName string, storeNames
 	if progConfig.GeminiGroundigWithGoogleMaps {
 		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{GoogleMaps: &genai.GoogleMaps{}})
 	}
+	if functionCallingActive() && !isImageRequest {
+		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{FunctionDeclarations: functionDeclarations()})
+	}
 	if len(storeNames) > 0 {
 		generateContentConfig.Tools = append(generateContentConfig.Tools, &genai.Tool{
 			FileSearch: &genai.FileSearch{
@@ -628,6 +943,9 @@ func printGeminiModelConfig(geminiModelConfig *genai.GenerateContentConfig, term
 	if geminiModelConfig.ResponseMIMEType != "" {
 		fmt.Printf("  ResponseMIMEType  : %v\n", geminiModelConfig.ResponseMIMEType)
 	}
+	if geminiModelConfig.ResponseJsonSchema != nil {
+		fmt.Printf("  ResponseJsonSchema: %v\n", progConfig.GeminiResponseSchemaFile)
+	}
 	if geminiModelConfig.Tools != nil {
 		for _, tool := range geminiModelConfig.Tools {
 			if tool.GoogleSearch != nil {
@@ -642,6 +960,13 @@ func printGeminiModelConfig(geminiModelConfig *genai.GenerateContentConfig, term
 			if tool.CodeExecution != nil {
 				fmt.Printf("  Tool              : CodeExecution\n")
 			}
+			if len(tool.FunctionDeclarations) > 0 {
+				names := []string{}
+				for _, declaration := range tool.FunctionDeclarations {
+					names = append(names, declaration.Name)
+				}
+				fmt.Printf("  Tool              : Functions: %s\n", strings.Join(names, ", "))
+			}
 			if tool.FileSearch != nil {
 				// TODO: formatting (separate lines for each store?)
 				fmt.Printf("  Tool              : FileSearchStores: %s\n",
@@ -687,6 +1012,12 @@ func showCompactConfiguration(modelInfo *genai.Model, modelConfig *genai.Generat
 	}
 	outLimit := fmt.Sprintf("%dk", modelInfo.OutputTokenLimit/1024)
 	fmt.Printf("Model  : %s (Limits: %s In / %s Out)\n", modelInfo.Name, inLimit, outLimit)
+	if progConfig.ActiveProfile != "" {
+		fmt.Printf("Profile: %s\n", progConfig.ActiveProfile)
+	}
+	if isVertexBackend() {
+		fmt.Printf("Backend: %s\n", backendDescription())
+	}
 
 	// Config
 	configParts := []string{fmt.Sprintf("%d Candidate(s)", *progConfig.GeminiCandidateCount)}
@@ -712,6 +1043,17 @@ func showCompactConfiguration(modelInfo *genai.Model, modelConfig *genai.Generat
 	if progConfig.GeminiGroundigWithGoogleMaps {
 		activeTools = append(activeTools, "GoogleMaps")
 	}
+	if progConfig.FunctionCalling && len(progConfig.Functions) > 0 {
+		activeTools = append(activeTools, fmt.Sprintf("Functions (%s)", strings.Join(functionNames(), ", ")))
+	}
+	if progConfig.WorkspaceTools {
+		activeTools = append(activeTools, "Workspace")
+	}
+	if progConfig.MCPTools {
+		for _, summary := range mcpServerSummaries() {
+			activeTools = append(activeTools, "MCP "+summary)
+		}
+	}
 	if len(activeTools) > 0 {
 		fmt.Printf("Tools  : %s\n", strings.Join(activeTools, ", "))
 	}
@@ -767,6 +1109,15 @@ func showCompactConfiguration(modelInfo *genai.Model, modelConfig *genai.Generat
 	if progConfig.GeminiPureResponse {
 		modeStr += ", Pure-Response"
 	}
+	if progConfig.GeminiStreamResponse {
+		modeStr += ", Streaming"
+	}
+	if autoContinueActive() {
+		modeStr += ", Auto-Continue"
+	}
+	if structuredOutputActive() {
+		modeStr += ", Structured (" + filepath.Base(progConfig.GeminiResponseSchemaFile) + ")"
+	}
 	fmt.Printf("Mode   : %s\n", modeStr)
 
 	// Output
@@ -784,7 +1135,11 @@ func showCompactConfiguration(modelInfo *genai.Model, modelConfig *genai.Generat
 
 	fmt.Printf("Quit   : CTRL-C\n")
 	fmt.Printf("-----------------------------------------------------------------------\n")
-	fmt.Printf("Free Tier: Data is used by Google to improve AI. No confidential data!\n")
-	fmt.Printf("Paid Tier: Data is private and not used for training (GCP terms apply).\n")
+	if isVertexBackend() {
+		fmt.Printf("Vertex AI: Data is processed under your GCP project's terms (%s).\n", progConfig.VertexProject)
+	} else {
+		fmt.Printf("Free Tier: Data is used by Google to improve AI. No confidential data!\n")
+		fmt.Printf("Paid Tier: Data is private and not used for training (GCP terms apply).\n")
+	}
 	fmt.Printf("-----------------------------------------------------------------------\n\n")
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configOrigins holds the origin of each configuration value (key = YAML key), e.g. 'global config'
+var configOrigins = map[string]string{}
+
+// configEnvPrefix is the prefix of environment variables overriding configuration values
+const configEnvPrefix = "GEMPRO_"
+
+/*
+globalConfigDir returns the directory of the global configuration ('$XDG_CONFIG_HOME/gem-pro' or
+'~/.config/gem-pro').
+*/
+func globalConfigDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "gem-pro")
+}
+
+/*
+globalConfigFile returns the path of the global configuration file (empty if no home directory).
+*/
+func globalConfigFile() string {
+	dir := globalConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+/*
+createGlobalConfigDir creates the global configuration directory with the default configuration
+(config.yaml, gem-pro-image.yaml), README, logo, user system instruction and prompt input page, if it
+doesn't exist yet.
+*/
+func createGlobalConfigDir() {
+	directory := globalConfigDir()
+	if directory == "" || dirExists(directory) {
+		return
+	}
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		fmt.Printf("error [%v] at os.MkdirAll()\n", err)
+		return
+	}
+	writeConfig(directory)
+	writeReadme(directory)
+	writeGemProPng(directory)
+	writeUserSystemInstruction(directory)
+	writePromptInput(directory)
+	fmt.Printf("Note: global configuration created in '%s'.\n", directory)
+}
+
+/*
+loadConfigurationLayers builds the configuration from its layers, each layer overriding the previous:
+built-in defaults, global configuration (~/.config/gem-pro/config.yaml), project configuration (e.g.
+gem-pro.yaml in the current directory), profile (-profile) and environment variables (GEMPRO_*). CLI flags
+are applied later (overwriteConfigValues). Empty values in a layer don't override lower layers, unknown
+keys are reported (strict decoding).
+*/
+func loadConfigurationLayers(projectFile string, projectRequired bool, profileName string) error {
+	configOrigins = map[string]string{}
+	for _, key := range configKeys() {
+		configOrigins[key] = "default"
+	}
+
+	err := applyConfigSource(gemProYaml, "default")
+	if err != nil {
+		return fmt.Errorf("error [%w] in built-in defaults", err)
+	}
+
+	globalFile := globalConfigFile()
+	if globalFile != "" && fileExists(globalFile) {
+		err = applyConfigFile(globalFile, "global config")
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case fileExists(projectFile):
+		err = applyConfigFile(projectFile, "project config")
+		if err != nil {
+			return err
+		}
+	case projectRequired:
+		return fmt.Errorf("configuration file [%s] not found", projectFile)
+	}
+
+	if profileName != "" {
+		err = applyProfile(profileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return applyConfigEnvironment()
+}
+
+/*
+applyConfigFile applies a YAML configuration file as layer.
+*/
+func applyConfigFile(filename string, origin string) error {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error [%w] reading configuration file", err)
+	}
+	err = applyConfigSource(source, origin)
+	if err != nil {
+		return fmt.Errorf("error [%w] in configuration file [%s]", err, filename)
+	}
+	return nil
+}
+
+/*
+applyConfigSource parses YAML source and applies it as configuration layer.
+*/
+func applyConfigSource(source []byte, origin string) error {
+	var document yaml.Node
+	err := yaml.Unmarshal(source, &document)
+	if err != nil {
+		return err
+	}
+	if len(document.Content) == 0 {
+		return nil // empty file
+	}
+	return applyConfigNode(document.Content[0], origin)
+}
+
+/*
+applyConfigNode applies a YAML mapping as configuration layer: keys with empty values are skipped, the
+remaining keys are decoded strictly into the configuration and their origin is recorded.
+*/
+func applyConfigNode(mapping *yaml.Node, origin string) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("configuration is not a mapping of keys and values")
+	}
+
+	layer := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	keys := []string{}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		layer.Content = append(layer.Content, key, value)
+		keys = append(keys, key.Value)
+	}
+
+	data, err := yaml.Marshal(layer)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&progConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		configOrigins[key] = origin
+	}
+	return nil
+}
+
+/*
+applyConfigEnvironment applies environment variables as configuration layer. The variable name is the
+YAML key in upper snake case with prefix GEMPRO_ (e.g. GeminiDefaultAiModel -> GEMPRO_GEMINI_DEFAULT_AI_MODEL),
+the value is parsed as YAML (e.g. 'true', '0.7', '[TEXT, IMAGE]').
+*/
+func applyConfigEnvironment() error {
+	for _, key := range configKeys() {
+		name := configEnvName(key)
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		var valueNode yaml.Node
+		err := yaml.Unmarshal([]byte(value), &valueNode)
+		if err != nil || len(valueNode.Content) == 0 {
+			return fmt.Errorf("invalid value of environment variable [%s]", name)
+		}
+		mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, valueNode.Content[0],
+		}}
+		err = applyConfigNode(mapping, "env "+name)
+		if err != nil {
+			return fmt.Errorf("error [%w] in environment variable [%s]", err, name)
+		}
+	}
+	return nil
+}
+
+/*
+configEnvName returns the environment variable of a configuration key, e.g. 'HTMLOutput' -> 'GEMPRO_HTML_OUTPUT'.
+*/
+func configEnvName(key string) string {
+	runes := []rune(key)
+	var name strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previousLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return configEnvPrefix + name.String()
+}
+
+/*
+configKeys returns the YAML keys of all configuration fields (in declaration order), profiles excluded.
+*/
+func configKeys() []string {
+	keys := []string{}
+	configType := reflect.TypeOf(progConfig)
+	for i := 0; i < configType.NumField(); i++ {
+		tag, _, _ := strings.Cut(configType.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" || tag == "Profiles" {
+			continue
+		}
+		keys = append(keys, tag)
+	}
+	return keys
+}
+
+/*
+recordFlagOrigins records the origin of configuration values overridden by CLI flags.
+*/
+func recordFlagOrigins(setFlags map[string]bool) {
+	flagKeys := map[string][]string{
+		"candidates":       {"GeminiCandidateCount"},
+		"code-execution":   {"GeminiGroundingWithCodeExecution"},
+		"google-search":    {"GeminiGroundingWithGoogleSearch"},
+		"url-context":      {"GeminiGroundingWithURLContext"},
+		"google-maps":      {"GeminiGroundigWithGoogleMaps"},
+		"pure-response":    {"GeminiPureResponse"},
+		"function-calling": {"FunctionCalling"},
+		"workspace-tools":  {"WorkspaceTools"},
+		"mcp-tools":        {"MCPTools"},
+		"stream":           {"GeminiStreamResponse"},
+		"auto-continue":    {"GeminiAutoContinue"},
+		"schema":           {"GeminiResponseSchemaFile"},
+		"out":              {"MarkdownPromptResponseFile", "HTMLPromptResponseFile", "AnsiPromptResponseFile"},
+	}
+	for flagName, keys := range flagKeys {
+		if !setFlags[flagName] {
+			continue
+		}
+		for _, key := range keys {
+			configOrigins[key] = "flag -" + flagName
+		}
+	}
+}
+
+/*
+showEffectiveConfiguration prints each configuration value with its origin (default, global config,
+project config, profile, environment variable or CLI flag). Secrets are redacted.
+*/
+func showEffectiveConfiguration() {
+	fmt.Printf("\nEffective configuration:\n")
+	fmt.Printf("  Global config  : %s\n", globalConfigFile())
+	fmt.Printf("  Project config : %s\n", *config)
+	if progConfig.ActiveProfile != "" {
+		fmt.Printf("  Profile        : %s\n", progConfig.ActiveProfile)
+	}
+	fmt.Printf("  AI model       : %s\n", progConfig.GeminiAiModel)
+	fmt.Printf("\n")
+
+	configValue := reflect.ValueOf(progConfig)
+	configType := configValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		key, _, _ := strings.Cut(configType.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || key == "Profiles" {
+			continue
+		}
+		value := formatConfigValue(configValue.Field(i))
+		if (key == "GeminiAPIKey" || key == "GeneralInternetProxy" || key == "InputLocalhostAPIToken") && value != "" {
+			value = "[REDACTED]"
+		}
+		fmt.Printf("  %-40s : %-40s (%s)\n", key, value, configOrigins[key])
+	}
+	fmt.Printf("\n")
+}
+
+/*
+formatConfigValue formats a configuration value as single line (unset pointers as empty value, multi-line
+texts shortened to their first line).
+*/
+func formatConfigValue(value reflect.Value) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return ""
+		}
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return fmt.Sprintf("%v", value.Interface())
+		}
+		return string(data)
+	}
+	text := fmt.Sprintf("%v", value.Interface())
+	if first, _, found := strings.Cut(text, "\n"); found {
+		return fmt.Sprintf("%s ... (%d lines)", first, strings.Count(text, "\n")+1)
+	}
+	return text
+}
+
+/*
+handleStandaloneConfigActions prints the effective configuration (CLI flags applied) with origins.
+*/
+func handleStandaloneConfigActions(setFlags map[string]bool) {
+	if *showEffectiveConfig {
+		overwriteConfigValues(setFlags)
+		showEffectiveConfiguration()
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigEnvName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "HTMLOutput", want: "GEMPRO_HTML_OUTPUT"},
+		{key: "GeminiDefaultAiModel", want: "GEMPRO_GEMINI_DEFAULT_AI_MODEL"},
+		{key: "GeminiAPIKey", want: "GEMPRO_GEMINI_API_KEY"},
+		{key: "GeminiBaseURL", want: "GEMPRO_GEMINI_BASE_URL"},
+		{key: "GeminiGroundingWithURLContext", want: "GEMPRO_GEMINI_GROUNDING_WITH_URL_CONTEXT"},
+		{key: "MCPTools", want: "GEMPRO_MCP_TOOLS"},
+		{key: "AnsiOutputLineLength", want: "GEMPRO_ANSI_OUTPUT_LINE_LENGTH"},
+		{key: "GeminiResponseSchemaFile", want: "GEMPRO_GEMINI_RESPONSE_SCHEMA_FILE"},
+		{key: "Model2Name", want: "GEMPRO_MODEL2_NAME"},
+		{key: "Trace", want: "GEMPRO_TRACE"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			got := configEnvName(test.key)
+			if got != test.want {
+				t.Errorf("configEnvName(%q) = %q, want %q", test.key, got, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigEnvNameUnique(t *testing.T) {
+	names := map[string]string{}
+	for _, key := range configKeys() {
+		name := configEnvName(key)
+		if other, found := names[name]; found {
+			t.Errorf("configuration keys %q and %q share environment variable %q", other, key, name)
+		}
+		names[name] = key
+	}
+}
+
+/*
+TestConfigurationLayers checks the precedence of the configuration layers (defaults < global config <
+project config < profile < environment < CLI flags) for one key, set in different combinations of layers.
+*/
+func TestConfigurationLayers(t *testing.T) {
+	tests := []struct {
+		name       string
+		global     bool
+		project    bool
+		profile    bool
+		env        bool
+		flag       bool
+		wantValue  string
+		wantOrigin string
+	}{
+		{name: "defaults", wantValue: "", wantOrigin: "default"},
+		{name: "global", global: true, wantValue: "global.json", wantOrigin: "global config"},
+		{name: "project over global", global: true, project: true, wantValue: "project.json", wantOrigin: "project config"},
+		{name: "profile over project", global: true, project: true, profile: true, wantValue: "profile.json", wantOrigin: "profile test"},
+		{name: "env over profile", global: true, project: true, profile: true, env: true,
+			wantValue: "env.json", wantOrigin: "env GEMPRO_GEMINI_RESPONSE_SCHEMA_FILE"},
+		{name: "flag over env", global: true, project: true, profile: true, env: true, flag: true,
+			wantValue: "flag.json", wantOrigin: "flag -schema"},
+		{name: "flag over defaults", flag: true, wantValue: "flag.json", wantOrigin: "flag -schema"},
+		{name: "env over global", global: true, env: true, wantValue: "env.json", wantOrigin: "env GEMPRO_GEMINI_RESPONSE_SCHEMA_FILE"},
+	}
+
+	savedConfig := progConfig
+	savedSchemaFile := *schemaFile
+	defer func() {
+		progConfig = savedConfig
+		*schemaFile = savedSchemaFile
+	}()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", dir)
+			t.Setenv("GEMPRO_GEMINI_RESPONSE_SCHEMA_FILE", "")
+
+			if test.global {
+				err := os.MkdirAll(filepath.Join(dir, "gem-pro"), 0700)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, filepath.Join(dir, "gem-pro", "config.yaml"), "GeminiResponseSchemaFile: global.json\n")
+			}
+			projectFile := filepath.Join(dir, "gem-pro.yaml")
+			projectSource := "Profiles:\n  test:\n    GeminiCandidateCount: 2\n"
+			if test.profile {
+				projectSource += "    GeminiResponseSchemaFile: profile.json\n"
+			}
+			if test.project {
+				projectSource += "GeminiResponseSchemaFile: project.json\n"
+			}
+			writeTestFile(t, projectFile, projectSource)
+			if test.env {
+				t.Setenv("GEMPRO_GEMINI_RESPONSE_SCHEMA_FILE", "env.json")
+			}
+
+			progConfig = ProgConfig{}
+			err := loadConfigurationLayers(projectFile, true, "test")
+			if err != nil {
+				t.Fatalf("error [%v] loading configuration layers", err)
+			}
+			setFlags := map[string]bool{}
+			if test.flag {
+				*schemaFile = "flag.json"
+				setFlags["schema"] = true
+			}
+			overwriteConfigValues(setFlags)
+
+			if progConfig.GeminiResponseSchemaFile != test.wantValue {
+				t.Errorf("got value %q, want %q", progConfig.GeminiResponseSchemaFile, test.wantValue)
+			}
+			if configOrigins["GeminiResponseSchemaFile"] != test.wantOrigin {
+				t.Errorf("got origin %q, want %q", configOrigins["GeminiResponseSchemaFile"], test.wantOrigin)
+			}
+		})
+	}
+}
+
+/*
+writeTestFile writes a file needed by a test, an error fails the test.
+*/
+func writeTestFile(t *testing.T, filename string, data string) {
+	t.Helper()
+	err := os.WriteFile(filename, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// continuationInstruction asks the model to continue a response cut off at the output token limit
+const continuationInstruction = "Your previous response was cut off at the output token limit. Continue exactly where you " +
+	"stopped, even in the middle of a word or line. Do not repeat any text and do not add an introduction or a summary. " +
+	"If you stopped inside a code block, continue the code without opening a new code block."
+
+// continuationInfo describes the auto-continuation of the current request (shown in response metadata)
+var continuationInfo string
+
+/*
+autoContinueActive reports whether responses cut off at the output token limit are continued automatically.
+*/
+func autoContinueActive() bool {
+	return progConfig.GeminiAutoContinue && progConfig.GeminiAutoContinueMaxSteps > 0
+}
+
+/*
+runAutoContinueLoop continues a response cut off at the output token limit (finish reason MAX_TOKENS): the partial
+answer is sent back as model turn together with the continuation instruction (sendContinuation), until the model
+finishes or the step limit (GeminiAutoContinueMaxSteps) is reached. The parts are stitched into one response, the
+token usage of all steps is combined.
+*/
+func runAutoContinueLoop(ctx context.Context, resp *genai.GenerateContentResponse,
+	sendContinuation func(partialContent *genai.Content) (*genai.GenerateContentResponse, error)) (*genai.GenerateContentResponse, error) {
+	if !responseCutOff(resp) {
+		return resp, nil
+	}
+	if len(resp.Candidates) > 1 {
+		fmt.Printf("Note: auto-continue not possible with %d candidates\n", len(resp.Candidates))
+		return resp, nil
+	}
+
+	first := resp.Candidates[0]
+	thoughts, answer := splitThoughtParts(first.Content.Parts)
+	usage := resp.UsageMetadata
+	steps := 0
+
+	for responseCutOff(resp) && ctx.Err() == nil {
+		if steps == progConfig.GeminiAutoContinueMaxSteps {
+			fmt.Printf("Note: auto-continue step limit (%d) reached, response still incomplete\n", progConfig.GeminiAutoContinueMaxSteps)
+			break
+		}
+		steps++
+
+		// partial answer of the last step (without thoughts) as model turn
+		_, partialParts := splitThoughtParts(resp.Candidates[0].Content.Parts)
+		partialContent := genai.NewContentFromParts(partialParts, genai.RoleModel)
+
+		now := time.Now()
+		fmt.Printf("%02d:%02d:%02d: Response cut off at output token limit, continuing (step %d) ...\n",
+			now.Hour(), now.Minute(), now.Second(), steps)
+		next, err := sendContinuation(partialContent)
+		if err != nil {
+			return next, err
+		}
+		resp = next
+		usage = addUsageMetadata(usage, resp.UsageMetadata)
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			break
+		}
+		nextThoughts, nextAnswer := splitThoughtParts(resp.Candidates[0].Content.Parts)
+		thoughts = append(thoughts, nextThoughts...)
+		answer = stitchContinuationParts(answer, nextAnswer)
+	}
+
+	if steps == 0 {
+		return resp, nil
+	}
+	continuationInfo = fmt.Sprintf("%d %s", steps, pluralize(steps, "continuation"))
+	if responseCutOff(resp) {
+		continuationInfo += ", response still incomplete"
+	}
+
+	// one candidate with the stitched answer, finish reason of the last step
+	candidate := *first
+	if len(resp.Candidates) > 0 {
+		candidate.FinishReason = resp.Candidates[0].FinishReason
+		candidate.FinishMessage = resp.Candidates[0].FinishMessage
+	}
+	candidate.Content = &genai.Content{Role: genai.RoleModel, Parts: append(thoughts, answer...)}
+	if candidate.GroundingMetadata != nil {
+		// sources remain valid, segment offsets of the inline citations do not
+		groundingMetadata := *candidate.GroundingMetadata
+		groundingMetadata.GroundingSupports = nil
+		candidate.GroundingMetadata = &groundingMetadata
+	}
+	candidate.CitationMetadata = nil
+
+	combined := *resp
+	combined.Candidates = []*genai.Candidate{&candidate}
+	combined.UsageMetadata = usage
+	return &combined, nil
+}
+
+/*
+responseCutOff reports whether the (first) candidate of a response has been cut off at the output token limit.
+*/
+func responseCutOff(resp *genai.GenerateContentResponse) bool {
+	return resp != nil && len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil &&
+		resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens
+}
+
+/*
+splitThoughtParts splits the parts of a response into thoughts and answer.
+*/
+func splitThoughtParts(parts []*genai.Part) ([]*genai.Part, []*genai.Part) {
+	thoughts := []*genai.Part{}
+	answer := []*genai.Part{}
+	for _, part := range parts {
+		if part.Thought {
+			thoughts = append(thoughts, part)
+		} else {
+			answer = append(answer, part)
+		}
+	}
+	return thoughts, answer
+}
+
+/*
+stitchContinuationParts appends the parts of a continuation to the answer. The first text of the continuation is
+joined to the last text of the answer (see stitchContinuationText), other parts are appended unchanged.
+*/
+func stitchContinuationParts(answer []*genai.Part, continuation []*genai.Part) []*genai.Part {
+	stitched := append([]*genai.Part{}, answer...)
+	for i, part := range continuation {
+		last := len(stitched) - 1
+		if i == 0 && last >= 0 && stitched[last].Text != "" && part.Text != "" {
+			joined := *stitched[last]
+			joined.Text = stitchContinuationText(stitched[last].Text, part.Text)
+			stitched[last] = &joined
+			continue
+		}
+		stitched = append(stitched, part)
+	}
+	return stitched
+}
+
+/*
+stitchContinuationText joins a partial text and its continuation. If the partial text ends inside a code block and
+the model opens the code block again at the beginning of the continuation, the repeated fence line is removed. A bare
+fence line is only removed if it does not close the open code block (even number of fence lines in the continuation).
+*/
+func stitchContinuationText(text string, continuation string) string {
+	if !insideCodeFence(text) {
+		return text + continuation
+	}
+
+	trimmed := strings.TrimLeft(continuation, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "```") {
+		return text + continuation
+	}
+	firstLine, rest, found := strings.Cut(trimmed, "\n")
+	if !found {
+		return text + continuation
+	}
+	language := strings.TrimSpace(strings.TrimPrefix(firstLine, "```"))
+	if language == "" && countCodeFences(trimmed)%2 != 0 {
+		// bare fence closes the code block of the partial text
+		return text + continuation
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text + rest
+}
+
+/*
+insideCodeFence reports whether the text ends inside a fenced code block (odd number of fence lines).
+*/
+func insideCodeFence(text string) bool {
+	return countCodeFences(text)%2 != 0
+}
+
+/*
+countCodeFences counts the fence lines (```) of a Markdown text.
+*/
+func countCodeFences(text string) int {
+	count := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			count++
+		}
+	}
+	return count
+}
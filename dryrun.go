@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+/*
+chatRequestContents returns the contents sent by a chat with the next message: the curated chat history
+followed by the new user content.
+*/
+func chatRequestContents(chat *genai.Chat, parts []genai.Part) []*genai.Content {
+	prompt := &genai.Content{Role: genai.RoleUser}
+	for i := range parts {
+		prompt.Parts = append(prompt.Parts, &parts[i])
+	}
+	return append(chat.History(true), prompt)
+}
+
+/*
+buildWirePayload builds the JSON request body of the Gemini REST API (generateContent) from contents and
+model configuration. Generation parameters are nested in 'generationConfig', as on the wire.
+*/
+func buildWirePayload(contents []*genai.Content, modelConfig *genai.GenerateContentConfig) (map[string]any, error) {
+	data, err := json.Marshal(modelConfig)
+	if err != nil {
+		return nil, err
+	}
+	generationConfig := map[string]any{}
+	err = json.Unmarshal(data, &generationConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// request level fields (not part of generationConfig)
+	payload := map[string]any{"contents": contents}
+	for _, key := range []string{"systemInstruction", "tools", "toolConfig", "safetySettings", "cachedContent", "labels"} {
+		if value, ok := generationConfig[key]; ok {
+			payload[key] = value
+			delete(generationConfig, key)
+		}
+	}
+	delete(generationConfig, "httpOptions")
+	if len(generationConfig) > 0 {
+		payload["generationConfig"] = generationConfig
+	}
+	return payload, nil
+}
+
+/*
+printDryRunRequest prints the assembled request (endpoint, files, contents, model configuration) without
+sending it to Gemini. As JSON (-dry-run-json) the wire payload is printed, otherwise a readable form with
+binary data summarized by MIME type and size.
+*/
+func printDryRunRequest(contents []*genai.Content, modelConfig *genai.GenerateContentConfig, files []FileToHandle, stream bool) {
+	method := "generateContent"
+	if stream {
+		method = "streamGenerateContent?alt=sse"
+	}
+	model := "models/" + strings.TrimPrefix(progConfig.GeminiAiModel, "models/")
+	endpoint := fmt.Sprintf("POST %s%s:%s", backendEndpointBase(), model, method)
+
+	if *dryRunJSON {
+		payload, err := buildWirePayload(contents, modelConfig)
+		if err != nil {
+			fmt.Printf("error [%v] building wire payload\n", err)
+			return
+		}
+		data, err := json.MarshalIndent(payload, "", "  ")
+		if err != nil {
+			fmt.Printf("error [%v] at json.MarshalIndent()\n", err)
+			return
+		}
+		fmt.Printf("%s\n%s\n", endpoint, data)
+		return
+	}
+
+	terminalWidth := progConfig.AnsiOutputLineLength
+	fmt.Printf("\nDry run (request not sent):\n")
+	fmt.Printf("  Endpoint : %s\n", endpoint)
+
+	if len(files) > 0 {
+		fmt.Printf("\nFiles:\n")
+		for _, fileToHandle := range files {
+			if fileToHandle.State == "error" {
+				fmt.Printf("  %-5s %s %s\n", fileToHandle.State, fileToHandle.Filepath, fileToHandle.ErrorMessage)
+				continue
+			}
+			// add replacement MIME type (e.g. 'text/x-perl -> text/plain')
+			mimeType := fileToHandle.MimeType
+			if replacement, ok := ReplacementMIMETypeMap[fileToHandle.MimeType]; ok {
+				mimeType += fmt.Sprintf(" -> %s", replacement)
+			}
+			fmt.Printf("  %-5s %s (%s, %s)\n", fileToHandle.State, fileToHandle.Filepath, fileToHandle.FileSize, mimeType)
+		}
+	}
+
+	fmt.Printf("\nContents:\n")
+	for i, content := range contents {
+		if content == nil {
+			continue
+		}
+		fmt.Printf("  #%d %s\n", i+1, content.Role)
+		for _, part := range content.Parts {
+			if part == nil {
+				continue
+			}
+			switch {
+			case part.InlineData != nil:
+				fmt.Printf("    InlineData : %s (%d bytes)\n", part.InlineData.MIMEType, len(part.InlineData.Data))
+			case part.FileData != nil:
+				fmt.Printf("    FileData   : %s (%s)\n", part.FileData.FileURI, part.FileData.MIMEType)
+			case part.Text != "":
+				fmt.Printf("    Text       : %s\n", wrapString(part.Text, terminalWidth, 17))
+			default:
+				fmt.Printf("    Part       : %T\n", part)
+			}
+		}
+	}
+
+	printGeminiModelConfig(modelConfig, terminalWidth)
+	fmt.Printf("\n")
+}
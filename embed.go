@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"io/fs"
 	"log"
@@ -15,33 +16,54 @@ var gemProYaml []byte
 var gemProImageYaml []byte
 
 /*
-writeConfig writes the embedded default configurations (gemProYaml, gemProImageYaml)
-to files named 'gem-pro.yaml' and 'gem-pro-image.yaml".
+writeConfig writes the embedded default configurations to files named 'config.yaml' (commented-out template,
+see configTemplate) and 'gem-pro-image.yaml' in the given directory.
 */
-func writeConfig() {
-	filename := "gem-pro.yaml"
-	err := os.WriteFile(filename, gemProYaml, 0600)
+func writeConfig(directory string) {
+	filename := filepath.Join(directory, "config.yaml")
+	err := os.WriteFile(filename, configTemplate(gemProYaml), 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
 	}
 
-	filename = "gem-pro-image.yaml"
+	filename = filepath.Join(directory, "gem-pro-image.yaml")
 	err = os.WriteFile(filename, gemProImageYaml, 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
 	}
 }
 
+/*
+configTemplate turns the default configuration into a template with all settings commented out. As global
+configuration it overrides nothing: the built-in defaults stay in effect (and their origin 'default'), changed
+defaults of later releases are not masked. Settings are activated by removing the leading '# '.
+*/
+func configTemplate(defaults []byte) []byte {
+	var template bytes.Buffer
+	template.WriteString("# Global configuration (layer between built-in defaults and project configuration).\n")
+	template.WriteString("# All settings are commented out: uncomment and change only the values you want to override.\n\n")
+	defaults = bytes.TrimPrefix(defaults, []byte("\xef\xbb\xbf"))
+	for _, line := range bytes.Split(defaults, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && trimmed[0] != '#' {
+			template.WriteString("# ")
+		}
+		template.Write(line)
+		template.WriteString("\n")
+	}
+	return template.Bytes()
+}
+
 //go:embed prompt-input.html
 var geminiPromptInputHTML []byte
 
 /*
 writePromptInput writes the embedded HTML content for prompt input (geminiPromptInputHTML) to a file. It
 embeds the HTML for prompt input from 'geminiPromptInputHTML' and writes it to 'prompt-input.html' in the
-current directory, providing a default HTML input page.
+given directory, providing a default HTML input page.
 */
-func writePromptInput() {
-	filename := "prompt-input.html"
+func writePromptInput(directory string) {
+	filename := filepath.Join(directory, "prompt-input.html")
 	err := os.WriteFile(filename, geminiPromptInputHTML, 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
@@ -52,10 +74,10 @@ func writePromptInput() {
 var readmeBytes []byte
 
 /*
-writeReadme writes the embedded README.md to the current directory.
+writeReadme writes the embedded README.md to the given directory.
 */
-func writeReadme() {
-	filename := "README.md"
+func writeReadme(directory string) {
+	filename := filepath.Join(directory, "README.md")
 	err := os.WriteFile(filename, readmeBytes, 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
@@ -66,10 +88,10 @@ func writeReadme() {
 var gemProPngBytes []byte
 
 /*
-writeGemProPng writes the embedded gem-pro.png to the current directory.
+writeGemProPng writes the embedded gem-pro.png to the given directory.
 */
-func writeGemProPng() {
-	filename := "gem-pro.png"
+func writeGemProPng(directory string) {
+	filename := filepath.Join(directory, "gem-pro.png")
 	err := os.WriteFile(filename, gemProPngBytes, 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
@@ -120,10 +142,10 @@ func writeAssets(basepath string) {
 var userSystemInstructionTxtBytes []byte
 
 /*
-writeUserSystemInstruction writes the embedded user-system-instruction.txt to the current directory.
+writeUserSystemInstruction writes the embedded user-system-instruction.txt to the given directory.
 */
-func writeUserSystemInstruction() {
-	filename := "user-system-instruction.txt"
+func writeUserSystemInstruction(directory string) {
+	filename := filepath.Join(directory, "user-system-instruction.txt")
 	err := os.WriteFile(filename, userSystemInstructionTxtBytes, 0600)
 	if err != nil {
 		log.Fatalf("embed: error [%v] at os.WriteFile(), file = [%s]", err, filename)
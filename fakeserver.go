@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeScriptEntry represents one scripted response of the fake server (compatible with trace records)
+type fakeScriptEntry struct {
+	Response map[string]any `json:"response"`
+	Error    *TraceError    `json:"error"`
+}
+
+// fakeUpload represents a running resumable upload (file or FileSearchStore document)
+type fakeUpload struct {
+	resource  map[string]any
+	storeName string // upload to FileSearchStore (empty = file)
+	size      int
+}
+
+// fakeGemini holds the state of the fake Gemini backend (all resources in memory)
+type fakeGemini struct {
+	sync.Mutex
+	baseURL   string
+	script    []fakeScriptEntry
+	next      int // next scripted response
+	responses int // number of generated responses
+	sequence  int // sequence for resource names
+	files     map[string]map[string]any
+	caches    map[string]map[string]any
+	stores    map[string]map[string]any
+	documents map[string]map[string]any
+	uploads   map[string]*fakeUpload
+}
+
+/*
+loadFakeScript loads scripted responses from a JSONL file. Each line holds a 'response' (Gemini response)
+and / or an 'error' (code, status, message), e.g. the records of a trace file from an earlier run.
+*/
+func loadFakeScript(filename string) ([]fakeScriptEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	script := []fakeScriptEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := fakeScriptEntry{}
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error [%w] unmarshalling script line", err)
+		}
+		if entry.Response != nil || entry.Error != nil {
+			script = append(script, entry)
+		}
+	}
+	return script, scanner.Err()
+}
+
+/*
+runFakeServer runs a local fake Gemini backend, which serves canned or scripted generateContent responses
+and keeps files, cachedContents and fileSearchStores in memory. Point GeminiBaseURL to the fake server to
+exercise the complete prompt, render and history pipeline offline.
+*/
+func runFakeServer() {
+	if progConfig.FakeServerAddress == "" {
+		fmt.Printf("error: empty FakeServerAddress not allowed\n")
+		os.Exit(1)
+	}
+	fake := &fakeGemini{
+		baseURL:   "http://" + progConfig.FakeServerAddress + "/",
+		files:     map[string]map[string]any{},
+		caches:    map[string]map[string]any{},
+		stores:    map[string]map[string]any{},
+		documents: map[string]map[string]any{},
+		uploads:   map[string]*fakeUpload{},
+	}
+
+	if progConfig.FakeServerScript != "" {
+		script, err := loadFakeScript(progConfig.FakeServerScript)
+		if err != nil {
+			fmt.Printf("error [%v] loading fake server script\n", err)
+			os.Exit(1)
+		}
+		fake.script = script
+		fmt.Printf("  Script   : %s (%d %s, canned responses afterwards)\n", progConfig.FakeServerScript, len(script),
+			pluralize(len(script), "response"))
+	}
+	fmt.Printf("  Address  : %s\n", fake.baseURL)
+	fmt.Printf("  Usage    : GEMPRO_GEMINI_BASE_URL=%s gem-pro ... (Ctrl-C to stop)\n\n", fake.baseURL)
+
+	server := &http.Server{
+		Addr:              progConfig.FakeServerAddress,
+		Handler:           fake,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	err := server.ListenAndServe()
+	if err != nil {
+		fmt.Printf("error [%v] running fake server\n", err)
+		os.Exit(1)
+	}
+}
+
+/*
+ServeHTTP dispatches the requests of the Gemini REST API (v1beta) to the fake implementations.
+*/
+func (fake *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.Lock()
+	defer fake.Unlock()
+
+	now := time.Now()
+	fmt.Printf("%02d:%02d:%02d: %s %s\n", now.Hour(), now.Minute(), now.Second(), r.Method, r.URL.Path)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	request := map[string]any{}
+	if len(body) > 0 && !strings.HasPrefix(r.URL.Path, "/fake-upload/") {
+		_ = json.Unmarshal(body, &request)
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case strings.HasPrefix(path, "fake-upload/"):
+		fake.handleUploadChunk(w, r, strings.TrimPrefix(path, "fake-upload/"), body)
+		return
+	case path == "upload/v1beta/files":
+		fileRequest, _ := request["file"].(map[string]any)
+		fake.startUpload(w, r, fileRequest, "")
+		return
+	case strings.HasPrefix(path, "upload/v1beta/") && strings.HasSuffix(path, ":uploadToFileSearchStore"):
+		storeName := strings.TrimSuffix(strings.TrimPrefix(path, "upload/v1beta/"), ":uploadToFileSearchStore")
+		if fake.stores[storeName] == nil {
+			writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "FileSearchStore not found: "+storeName)
+			return
+		}
+		fake.startUpload(w, r, request, storeName)
+		return
+	}
+
+	path = strings.TrimPrefix(path, "v1beta/")
+	resource, method, _ := strings.Cut(path, ":")
+	segments := strings.Split(resource, "/")
+
+	switch {
+	case segments[0] == "models":
+		fake.handleModels(w, r, segments, method, request, body)
+	case segments[0] == "files":
+		fake.handleCollection(w, r, fake.files, resource, "files", nil)
+	case segments[0] == "cachedContents":
+		fake.handleCollection(w, r, fake.caches, resource, "cachedContents", func() map[string]any { return fake.newCache(request, body) })
+	case segments[0] == "fileSearchStores" && len(segments) >= 4 && segments[2] == "operations":
+		writeFakeJSON(w, map[string]any{"name": resource, "done": true})
+	case segments[0] == "fileSearchStores" && len(segments) >= 3 && segments[2] == "documents":
+		fake.handleCollection(w, r, fake.documents, resource, "documents", nil)
+	case segments[0] == "fileSearchStores":
+		fake.handleCollection(w, r, fake.stores, resource, "fileSearchStores", func() map[string]any { return fake.newStore(request) })
+	default:
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "not supported by fake server: "+r.URL.Path)
+	}
+}
+
+/*
+handleModels serves model information, model list, token counting and content generation.
+*/
+func (fake *fakeGemini) handleModels(w http.ResponseWriter, r *http.Request, segments []string, method string, request map[string]any, body []byte) {
+	if len(segments) == 1 {
+		models := []any{}
+		for _, model := range []string{progConfig.GeminiLiteAiModel, progConfig.GeminiFlashAiModel, progConfig.GeminiProAiModel,
+			progConfig.GeminiFlashImageAiModel, progConfig.GeminiProImageAiModel} {
+			if model != "" {
+				models = append(models, fakeModelInfo(model))
+			}
+		}
+		writeFakeJSON(w, map[string]any{"models": models})
+		return
+	}
+
+	model := strings.Join(segments[:2], "/")
+	switch method {
+	case "":
+		writeFakeJSON(w, fakeModelInfo(model))
+	case "countTokens":
+		writeFakeJSON(w, map[string]any{"totalTokens": len(body) / 4})
+	case "generateContent", "streamGenerateContent":
+		response, code, status, message := fake.nextResponse(model, request, body)
+		if code != 0 {
+			writeFakeError(w, code, status, message)
+			return
+		}
+		if method == "generateContent" {
+			writeFakeJSON(w, response)
+			return
+		}
+		// server-sent events (complete response as single chunk)
+		data, _ := json.Marshal(response)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprintf(w, "data: %s\r\n\r\n", data)
+	default:
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "method not supported by fake server: "+method)
+	}
+}
+
+/*
+nextResponse returns the next scripted response (or error), or a canned response echoing the prompt.
+*/
+func (fake *fakeGemini) nextResponse(model string, request map[string]any, body []byte) (map[string]any, int, string, string) {
+	fake.responses++
+	if fake.next < len(fake.script) {
+		entry := fake.script[fake.next]
+		fake.next++
+		if entry.Error != nil {
+			code := entry.Error.Code
+			if code == 0 {
+				code = http.StatusInternalServerError
+			}
+			return nil, code, entry.Error.Status, entry.Error.Message
+		}
+		removeSummarizedData(entry.Response)
+		delete(entry.Response, "sdkHttpResponse")
+		return entry.Response, 0, "", ""
+	}
+
+	prompt := lastUserText(request)
+	text := fmt.Sprintf("Fake response #%d of model '%s' (gem-pro fake server).\n\nPrompt:\n\n```plaintext\n%s\n```\n\nMETADATA_SLUG: fake-response-%d",
+		fake.responses, strings.TrimPrefix(model, "models/"), prompt, fake.responses)
+	promptTokens := len(body) / 4
+	candidatesTokens := len(text) / 4
+	return map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": "STOP",
+			"index":        0,
+		}},
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     promptTokens,
+			"candidatesTokenCount": candidatesTokens,
+			"totalTokenCount":      promptTokens + candidatesTokens,
+		},
+		"modelVersion": strings.TrimPrefix(model, "models/"),
+		"responseId":   fmt.Sprintf("fake-%d", fake.responses),
+	}, 0, "", ""
+}
+
+/*
+handleCollection serves list, get, delete and create requests of a resource collection (files,
+cachedContents, fileSearchStores, documents).
+*/
+func (fake *fakeGemini) handleCollection(w http.ResponseWriter, r *http.Request, collection map[string]map[string]any, resource string,
+	listKey string, create func() map[string]any) {
+	isCollection := strings.HasSuffix(resource, listKey)
+
+	switch {
+	case isCollection && r.Method == http.MethodGet:
+		items := []any{}
+		for name, item := range collection {
+			if strings.HasPrefix(name, resource+"/") || listKey != "documents" {
+				items = append(items, item)
+			}
+		}
+		writeFakeJSON(w, map[string]any{listKey: items})
+	case isCollection && r.Method == http.MethodPost && create != nil:
+		item := create()
+		collection[item["name"].(string)] = item
+		writeFakeJSON(w, item)
+	case !isCollection && r.Method == http.MethodGet && collection[resource] != nil:
+		writeFakeJSON(w, collection[resource])
+	case !isCollection && r.Method == http.MethodDelete && collection[resource] != nil:
+		delete(collection, resource)
+		for name := range fake.documents {
+			if strings.HasPrefix(name, resource+"/") {
+				delete(fake.documents, name)
+			}
+		}
+		writeFakeJSON(w, map[string]any{})
+	case !isCollection && r.Method == http.MethodPatch && collection[resource] != nil:
+		writeFakeJSON(w, collection[resource])
+	default:
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "resource not found: "+resource)
+	}
+}
+
+/*
+newCache creates a cachedContents resource from a create request.
+*/
+func (fake *fakeGemini) newCache(request map[string]any, body []byte) map[string]any {
+	fake.sequence++
+	now := time.Now().UTC()
+	ttl, _ := request["ttl"].(string)
+	seconds, err := strconv.ParseFloat(strings.TrimSuffix(ttl, "s"), 64)
+	if err != nil || seconds <= 0 {
+		seconds = 3600
+	}
+	return map[string]any{
+		"name":          fmt.Sprintf("cachedContents/fake-cache-%d", fake.sequence),
+		"displayName":   request["displayName"],
+		"model":         request["model"],
+		"createTime":    now.Format(time.RFC3339),
+		"updateTime":    now.Format(time.RFC3339),
+		"expireTime":    now.Add(time.Duration(seconds * float64(time.Second))).Format(time.RFC3339),
+		"usageMetadata": map[string]any{"totalTokenCount": len(body) / 4},
+	}
+}
+
+/*
+newStore creates a fileSearchStores resource from a create request.
+*/
+func (fake *fakeGemini) newStore(request map[string]any) map[string]any {
+	fake.sequence++
+	now := time.Now().UTC().Format(time.RFC3339)
+	return map[string]any{
+		"name":        fmt.Sprintf("fileSearchStores/fake-store-%d", fake.sequence),
+		"displayName": request["displayName"],
+		"createTime":  now,
+		"updateTime":  now,
+	}
+}
+
+/*
+startUpload starts a resumable upload and returns the upload URL (header 'X-Goog-Upload-Url').
+*/
+func (fake *fakeGemini) startUpload(w http.ResponseWriter, r *http.Request, request map[string]any, storeName string) {
+	fake.sequence++
+	id := fmt.Sprintf("upload-%d", fake.sequence)
+	resource := map[string]any{}
+	for key, value := range request {
+		resource[key] = value
+	}
+	if mimeType := r.Header.Get("X-Goog-Upload-Header-Content-Type"); mimeType != "" && resource["mimeType"] == nil {
+		resource["mimeType"] = mimeType
+	}
+	fake.uploads[id] = &fakeUpload{resource: resource, storeName: storeName}
+
+	w.Header().Set("X-Goog-Upload-Url", fake.baseURL+"fake-upload/"+id)
+	w.Header().Set("X-Goog-Upload-Status", "active")
+	writeFakeJSON(w, map[string]any{})
+}
+
+/*
+handleUploadChunk receives a chunk of a resumable upload. With the finalize command the file resource
+(or the operation of the FileSearchStore upload) is returned.
+*/
+func (fake *fakeGemini) handleUploadChunk(w http.ResponseWriter, r *http.Request, id string, body []byte) {
+	upload := fake.uploads[id]
+	if upload == nil {
+		writeFakeError(w, http.StatusNotFound, "NOT_FOUND", "upload not found: "+id)
+		return
+	}
+	upload.size += len(body)
+
+	if !strings.Contains(r.Header.Get("X-Goog-Upload-Command"), "finalize") {
+		w.Header().Set("X-Goog-Upload-Status", "active")
+		writeFakeJSON(w, map[string]any{})
+		return
+	}
+	delete(fake.uploads, id)
+	w.Header().Set("X-Goog-Upload-Status", "final")
+
+	now := time.Now().UTC()
+	resource := upload.resource
+	resource["sizeBytes"] = strconv.Itoa(upload.size)
+	resource["createTime"] = now.Format(time.RFC3339)
+	resource["updateTime"] = now.Format(time.RFC3339)
+
+	if upload.storeName != "" {
+		name := fmt.Sprintf("%s/documents/fake-document-%d", upload.storeName, fake.sequence)
+		resource["name"] = name
+		resource["state"] = "STATE_ACTIVE"
+		fake.documents[name] = resource
+		store := fake.stores[upload.storeName]
+		if store != nil {
+			documents, _ := strconv.Atoi(fmt.Sprint(store["activeDocumentsCount"]))
+			size, _ := strconv.Atoi(fmt.Sprint(store["sizeBytes"]))
+			store["activeDocumentsCount"] = strconv.Itoa(documents + 1)
+			store["sizeBytes"] = strconv.Itoa(size + upload.size)
+		}
+		writeFakeJSON(w, map[string]any{
+			"name":     fmt.Sprintf("%s/operations/%s", upload.storeName, id),
+			"done":     true,
+			"response": map[string]any{"documentName": name},
+		})
+		return
+	}
+
+	name := fmt.Sprintf("files/fake-file-%d", fake.sequence)
+	resource["name"] = name
+	resource["uri"] = fake.baseURL + "v1beta/" + name
+	resource["state"] = "ACTIVE"
+	resource["expirationTime"] = now.Add(48 * time.Hour).Format(time.RFC3339)
+	fake.files[name] = resource
+	writeFakeJSON(w, map[string]any{"file": resource})
+}
+
+/*
+fakeModelInfo returns the model information of the fake server.
+*/
+func fakeModelInfo(model string) map[string]any {
+	return map[string]any{
+		"name":                       model,
+		"displayName":                strings.TrimPrefix(model, "models/") + " (fake)",
+		"description":                "Fake model of the gem-pro fake server.",
+		"version":                    "fake",
+		"inputTokenLimit":            1048576,
+		"outputTokenLimit":           65536,
+		"supportedGenerationMethods": []string{"generateContent", "countTokens", "createCachedContent"},
+		"thinking":                   true,
+	}
+}
+
+/*
+lastUserText returns the text of the last part of the last content in a generate request (the prompt).
+*/
+func lastUserText(request map[string]any) string {
+	contents, _ := request["contents"].([]any)
+	if len(contents) == 0 {
+		return ""
+	}
+	content, _ := contents[len(contents)-1].(map[string]any)
+	parts, _ := content["parts"].([]any)
+	for i := len(parts) - 1; i >= 0; i-- {
+		part, _ := parts[i].(map[string]any)
+		if text, ok := part["text"].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+/*
+removeSummarizedData removes inline data parts and thought signatures of a recorded response, whose binary
+data has been replaced by a summary in the trace (not decodable).
+*/
+func removeSummarizedData(response map[string]any) {
+	candidates, _ := response["candidates"].([]any)
+	for _, candidate := range candidates {
+		content, _ := candidate.(map[string]any)["content"].(map[string]any)
+		if content == nil {
+			continue
+		}
+		parts, _ := content["parts"].([]any)
+		keptParts := []any{}
+		for _, part := range parts {
+			partMap, _ := part.(map[string]any)
+			inlineData, _ := partMap["inlineData"].(map[string]any)
+			if data, ok := inlineData["data"].(string); ok && strings.HasPrefix(data, "[") {
+				continue
+			}
+			if signature, ok := partMap["thoughtSignature"].(string); ok && strings.HasPrefix(signature, "[") {
+				delete(partMap, "thoughtSignature")
+			}
+			keptParts = append(keptParts, part)
+		}
+		content["parts"] = keptParts
+	}
+}
+
+/*
+writeFakeJSON writes a JSON response of the fake server.
+*/
+func writeFakeJSON(w http.ResponseWriter, object any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(object)
+}
+
+/*
+writeFakeError writes an error response in the format of the Gemini API.
+*/
+func writeFakeError(w http.ResponseWriter, code int, status string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message, "status": status},
+	})
+}
+
+/*
+handleStandaloneFakeServerActions runs the fake Gemini backend.
+*/
+func handleStandaloneFakeServerActions() {
+	if *fakeServer {
+		fmt.Printf("\nRunning fake Gemini server:\n")
+		runFakeServer()
+		os.Exit(0)
+	}
+}
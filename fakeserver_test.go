@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMainEnv is the environment variable that makes the test binary run gem-pro itself (see TestMain)
+const testMainEnv = "GEMPRO_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+/*
+TestFakeServerPrompt sends a piped prompt through the complete pipeline (configuration, request, rendering,
+history) against the fake server and checks the terminal output and the Markdown and HTML history files.
+*/
+func TestFakeServerPrompt(t *testing.T) {
+	responseText := "## Ergebnis\n\nDie **Größe** ist `42`.\n\nMETADATA_SLUG: groesse-test"
+	fake := &fakeGemini{
+		files:     map[string]map[string]any{},
+		caches:    map[string]map[string]any{},
+		stores:    map[string]map[string]any{},
+		documents: map[string]map[string]any{},
+		uploads:   map[string]*fakeUpload{},
+		script: []fakeScriptEntry{{Response: map[string]any{
+			"candidates": []any{map[string]any{
+				"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": responseText}}},
+				"finishReason": "STOP",
+			}},
+			"usageMetadata": map[string]any{"promptTokenCount": 7, "candidatesTokenCount": 11, "totalTokenCount": 18},
+		}}},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.baseURL = server.URL + "/"
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		testMainEnv+"=1",
+		"HOME="+dir,
+		"XDG_CONFIG_HOME="+filepath.Join(dir, ".config"),
+		"GEMINI_API_KEY=test",
+		"GEMPRO_GEMINI_BASE_URL="+fake.baseURL,
+	)
+	cmd.Stdin = strings.NewReader("Wie groß ist es?")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("error [%v] running gem-pro, output:\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "Größe") {
+		t.Errorf("response missing in terminal output:\n%s", output)
+	}
+
+	fake.Lock()
+	responses := fake.responses
+	fake.Unlock()
+	if responses != 1 {
+		t.Errorf("got %d generated responses, want 1", responses)
+	}
+
+	markdownFiles, _ := filepath.Glob(filepath.Join(dir, "history-markdown", "*-groesse-test.md"))
+	if len(markdownFiles) != 1 {
+		t.Fatalf("got Markdown history files %v, want one '*-groesse-test.md'", markdownFiles)
+	}
+	markdown, err := os.ReadFile(markdownFiles[0])
+	if err != nil {
+		t.Fatalf("error [%v] reading Markdown history file", err)
+	}
+	for _, want := range []string{"Wie groß ist es?", "## Ergebnis", "Die **Größe** ist `42`."} {
+		if !strings.Contains(string(markdown), want) {
+			t.Errorf("Markdown history missing %q:\n%s", want, markdown)
+		}
+	}
+	if strings.Contains(string(markdown), "METADATA_SLUG") {
+		t.Errorf("Markdown history contains slug line:\n%s", markdown)
+	}
+
+	htmlFiles, _ := filepath.Glob(filepath.Join(dir, "history-html", "*-groesse-test.html"))
+	if len(htmlFiles) != 1 {
+		t.Fatalf("got HTML history files %v, want one '*-groesse-test.html'", htmlFiles)
+	}
+	htmlData, err := os.ReadFile(htmlFiles[0])
+	if err != nil {
+		t.Fatalf("error [%v] reading HTML history file", err)
+	}
+	for _, want := range []string{"Ergebnis</h2>", "<strong>Größe</strong>", "<code>42</code>"} {
+		if !strings.Contains(string(htmlData), want) {
+			t.Errorf("HTML history missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// fallbackInfo describes the use of fallback models for the current request (shown in response metadata)
+var fallbackInfo string
+
+// generationTarget holds model, configuration, stores and chat (chat mode only) of a prompt, follow-up requests of the
+// prompt (function calling, auto-continue) use the same target, also after a fallback model has answered
+type generationTarget struct {
+	model       string
+	modelConfig *genai.GenerateContentConfig
+	stores      []string // FileSearchStores of the request
+	chat        *genai.Chat
+}
+
+// generateFunc generates content with the given model, configuration and chat (chat mode only)
+type generateFunc func(model string, modelConfig *genai.GenerateContentConfig, chat *genai.Chat) (*genai.GenerateContentResponse, error)
+
+/*
+generateWithFallbackModels tries the configured fallback models (GeminiFallbackAiModels) in the given order,
+after the selected model failed with a quota or unavailability error. The model configuration is adjusted to
+each fallback model. In chat mode the chat is continued with the fallback model. It returns the target of the
+answering model (model, adjusted configuration, chat), which is used for all follow-up requests of the prompt;
+afterwards restoreSelectedModelChat recreates the session for the selected model. For the current request
+progConfig.GeminiAiModel is set to the answering model.
+*/
+func generateWithFallbackModels(ctx context.Context, state *sessionState, useChat bool, target generationTarget,
+	primaryErr error, generate generateFunc) (*genai.GenerateContentResponse, generationTarget, error) {
+	primaryModel := target.model
+	modelConfig := target.modelConfig
+	failures := []string{fmt.Sprintf("%s: %s", strings.TrimPrefix(primaryModel, "models/"), shortErrorText(primaryErr))}
+	lastErr := primaryErr
+	tried := map[string]bool{primaryModel: true}
+
+	for _, alias := range progConfig.GeminiFallbackAiModels {
+		model := resolveModelAlias(alias)
+		if tried[model] {
+			continue
+		}
+		tried[model] = true
+
+		fallbackConfig, adjustments := generateFallbackModelConfig(model, modelConfig, target.stores)
+
+		now := time.Now()
+		fmt.Printf("%02d:%02d:%02d: Falling back to model '%s' ...\n", now.Hour(), now.Minute(), now.Second(), strings.TrimPrefix(model, "models/"))
+		for _, adjustment := range adjustments {
+			fmt.Printf("  adjusted: %s\n", adjustment)
+		}
+
+		var fallbackChat *genai.Chat
+		if useChat {
+			var err error
+			fallbackChat, err = state.client.Chats.Create(ctx, model, fallbackConfig, state.chat.History(true))
+			if err != nil {
+				fmt.Printf("error [%v] creating Gemini chat mode session\n", err)
+				continue
+			}
+		}
+
+		resp, attempts, err := withRetry(ctx, "Request", func() (*genai.GenerateContentResponse, error) {
+			return generate(model, fallbackConfig, fallbackChat)
+		})
+		retryAttempts = append(retryAttempts, attempts...)
+		if err == nil {
+			progConfig.GeminiAiModel = model
+			fallbackInfo = fmt.Sprintf("answered by %s (failed: %s)", strings.TrimPrefix(model, "models/"), strings.Join(failures, ", "))
+			if len(adjustments) > 0 {
+				fallbackInfo += fmt.Sprintf(", adjusted: %s", strings.Join(adjustments, ", "))
+			}
+			return resp, generationTarget{model: model, modelConfig: fallbackConfig, stores: target.stores, chat: fallbackChat}, nil
+		}
+
+		lastErr = err
+		failures = append(failures, fmt.Sprintf("%s: %s", strings.TrimPrefix(model, "models/"), shortErrorText(err)))
+		if !isRetryableError(err) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	fallbackInfo = fmt.Sprintf("all models failed (%s)", strings.Join(failures, ", "))
+	return nil, target, lastErr
+}
+
+/*
+restoreSelectedModelChat recreates the chat session for the selected model after a fallback model has answered
+in chat mode, the history includes all exchanges with the fallback model. So the session stays with the selected
+model.
+*/
+func restoreSelectedModelChat(ctx context.Context, state *sessionState, selectedModel string, fallbackChat *genai.Chat) {
+	chat, err := state.client.Chats.Create(ctx, selectedModel, state.modelConfig, fallbackChat.History(true))
+	if err != nil {
+		fmt.Printf("error [%v] recreating Gemini chat mode session\n", err)
+		return
+	}
+	state.chat = chat
+}
+
+/*
+generateFallbackModelConfig generates the model configuration for a fallback model. Features the fallback
+model does not support are adjusted: thinking level (Gemini 3 only), image configuration and response
+modalities (image models only) and the cache (bound to the selected model). The system instruction of the
+original configuration and the FileSearchStores of the request are kept. It returns the configuration and a list of adjustments.
+*/
+func generateFallbackModelConfig(model string, modelConfig *genai.GenerateContentConfig, stores []string) (*genai.GenerateContentConfig, []string) {
+	adjustments := []string{}
+
+	savedConfig := progConfig
+	defer func() { progConfig = savedConfig }()
+
+	progConfig.GeminiAiModel = model
+	if progConfig.GeminiThinkingLevel != "" && !supportsThinkingLevel(model) {
+		adjustments = append(adjustments, fmt.Sprintf("thinking level '%s' removed", progConfig.GeminiThinkingLevel))
+		progConfig.GeminiThinkingLevel = ""
+	}
+	isImageRequest := strings.Contains(model, "image")
+	if modelConfig.ImageConfig != nil && !isImageRequest {
+		adjustments = append(adjustments, "image config removed")
+	}
+	if !isImageRequest {
+		// text models do not support image output
+		modalities := []string{}
+		for _, modality := range progConfig.GeminiResponseModalities {
+			if strings.EqualFold(modality, "IMAGE") {
+				adjustments = append(adjustments, "response modality IMAGE removed")
+				continue
+			}
+			modalities = append(modalities, modality)
+		}
+		progConfig.GeminiResponseModalities = modalities
+	}
+	if modelConfig.CachedContent != "" {
+		adjustments = append(adjustments, "cache removed")
+	}
+
+	fallbackConfig := generateGeminiModelConfig(isImageRequest, "", stores)
+	fallbackConfig.SystemInstruction = modelConfig.SystemInstruction
+
+	return fallbackConfig, adjustments
+}
+
+/*
+supportsThinkingLevel reports whether a model supports thinking levels (Gemini 3 and later), older models
+only support a thinking budget.
+*/
+func supportsThinkingLevel(model string) bool {
+	name := strings.TrimPrefix(model, "models/")
+	return !strings.HasPrefix(name, "gemini-1") && !strings.HasPrefix(name, "gemini-2")
+}
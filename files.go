@@ -17,10 +17,7 @@ An uploaded files is stored for a limited amount of time (e.g., 2 days).
 func uploadFilesToGemini(filesToUpload []FileToHandle) {
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -39,7 +36,9 @@ func uploadFilesToGemini(filesToUpload []FileToHandle) {
 
 		fmt.Printf("  %s\n", fileToUpload.Filepath)
 
-		_, err := client.Files.UploadFromPath(ctx, fileToUpload.Filepath, &uploadFileConfig)
+		_, _, err := withRetry(ctx, "Upload", func() (*genai.File, error) {
+			return client.Files.UploadFromPath(ctx, fileToUpload.Filepath, &uploadFileConfig)
+		})
 		if err != nil {
 			log.Fatalf("error [%v] uploading file to Google File Store", err)
 		}
@@ -52,10 +51,7 @@ deleteFilesFromGemini deletes all uploaded files from Google file store.
 func deleteFilesFromGemini() {
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -83,10 +79,7 @@ func listFilesUploadedToGemini(indent string) string {
 
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// FunctionDefinition describes a local function offered to the model (section 'Functions')
+type FunctionDefinition struct {
+	Name         string         `yaml:"Name"`
+	Description  string         `yaml:"Description"`
+	Parameters   map[string]any `yaml:"Parameters"`   // JSON schema of the arguments
+	Command      string         `yaml:"Command"`      // executed with the arguments as JSON on stdin
+	HTTPEndpoint string         `yaml:"HTTPEndpoint"` // called with POST and the arguments as JSON body
+}
+
+// functionResultMaxSize is the maximum size of a function result sent to the model (bytes)
+const functionResultMaxSize = 64 * 1024
+
+// functionNamePattern defines the function names accepted by Gemini
+var functionNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.:-]{0,63}$`)
+
+// functionCallParts holds the function calls and results of the current request (rendered in the transcript)
+var functionCallParts []*genai.Part
+
+// functionCallingInfo describes the function calling of the current request (shown in response metadata)
+var functionCallingInfo string
+
+// terminalReaderActive is set if prompts are read from the terminal (approval answers are taken from there)
+var terminalReaderActive atomic.Bool
+
+// approvalPending routes the next terminal line to the pending approval prompt (instead of the prompt channel)
+var approvalPending atomic.Bool
+
+// approvalAnswers receives the answers to approval prompts from the terminal reader (buffered: an answer arriving
+// after a cancelled approval must not block the reader)
+var approvalAnswers = make(chan string, 1)
+
+/*
+validateFunctionDefinitions validates the function definitions (section 'Functions'): valid and unique names,
+a description and exactly one of command or HTTP endpoint.
+*/
+func validateFunctionDefinitions() error {
+	if progConfig.FunctionCallingMaxSteps < 1 {
+		return fmt.Errorf("FunctionCallingMaxSteps must be at least 1")
+	}
+	if progConfig.FunctionCallingTimeout < 0 {
+		return fmt.Errorf("negative FunctionCallingTimeout not allowed")
+	}
+	names := map[string]bool{}
+	for _, function := range progConfig.Functions {
+		if !functionNamePattern.MatchString(function.Name) {
+			return fmt.Errorf("invalid function name [%s]", function.Name)
+		}
+		if names[function.Name] {
+			return fmt.Errorf("duplicate function name [%s]", function.Name)
+		}
+		if isWorkspaceToolName(function.Name) {
+			return fmt.Errorf("function name [%s] reserved for built-in workspace tool", function.Name)
+		}
+		names[function.Name] = true
+		if function.Description == "" {
+			return fmt.Errorf("empty description of function [%s] not allowed", function.Name)
+		}
+		if (function.Command == "") == (function.HTTPEndpoint == "") {
+			return fmt.Errorf("function [%s] requires either Command or HTTPEndpoint", function.Name)
+		}
+		if function.HTTPEndpoint != "" {
+			endpoint, err := url.Parse(function.HTTPEndpoint)
+			if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+				return fmt.Errorf("invalid HTTPEndpoint [%s] of function [%s]", function.HTTPEndpoint, function.Name)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+functionCallingActive reports whether functions are offered to the model (configured functions with
+FunctionCalling, built-in workspace tools with WorkspaceTools, tools of MCP servers with MCPTools).
+*/
+func functionCallingActive() bool {
+	return (progConfig.FunctionCalling && len(progConfig.Functions) > 0) || progConfig.WorkspaceTools ||
+		(progConfig.MCPTools && len(mcpFunctions) > 0)
+}
+
+/*
+functionDeclarations returns the declarations of all active functions (sent as tool to the model): the
+configured functions, the built-in workspace tools and the tools of the MCP servers.
+*/
+func functionDeclarations() []*genai.FunctionDeclaration {
+	declarations := []*genai.FunctionDeclaration{}
+	if progConfig.WorkspaceTools {
+		for _, tool := range workspaceTools {
+			declarations = append(declarations, tool.declaration)
+		}
+	}
+	if progConfig.MCPTools {
+		declarations = append(declarations, mcpFunctionDeclarations()...)
+	}
+	if !progConfig.FunctionCalling {
+		return declarations
+	}
+	for _, function := range progConfig.Functions {
+		declaration := &genai.FunctionDeclaration{
+			Name:        function.Name,
+			Description: function.Description,
+		}
+		if len(function.Parameters) > 0 {
+			declaration.ParametersJsonSchema = function.Parameters
+		}
+		declarations = append(declarations, declaration)
+	}
+	return declarations
+}
+
+/*
+functionNames returns the names of all configured functions.
+*/
+func functionNames() []string {
+	names := []string{}
+	for _, function := range progConfig.Functions {
+		names = append(names, function.Name)
+	}
+	return names
+}
+
+/*
+findFunctionDefinition returns the configured function with the given name (nil if unknown or not enabled).
+*/
+func findFunctionDefinition(name string) *FunctionDefinition {
+	if !progConfig.FunctionCalling {
+		return nil
+	}
+	for i := range progConfig.Functions {
+		if progConfig.Functions[i].Name == name {
+			return &progConfig.Functions[i]
+		}
+	}
+	return nil
+}
+
+/*
+runFunctionCallingLoop executes the function calls of a response and sends the results back to the model
+(sendResults), until the model answers without function calls or the step limit (FunctionCallingMaxSteps)
+is reached. Calls and results are recorded for the transcript, the token usage of all steps is combined.
+*/
+func runFunctionCallingLoop(ctx context.Context, resp *genai.GenerateContentResponse,
+	sendResults func(modelContent *genai.Content, results []*genai.Part) (*genai.GenerateContentResponse, error)) (*genai.GenerateContentResponse, error) {
+	usage := resp.UsageMetadata
+	calls := 0
+	steps := 0
+
+	for ctx.Err() == nil {
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			break
+		}
+		modelContent := resp.Candidates[0].Content
+		requested := []*genai.FunctionCall{}
+		for _, part := range modelContent.Parts {
+			if part.FunctionCall != nil {
+				requested = append(requested, part.FunctionCall)
+			}
+		}
+		if len(requested) == 0 {
+			break
+		}
+		if steps == progConfig.FunctionCallingMaxSteps {
+			fmt.Printf("Note: function calling step limit (%d) reached, open calls not executed\n", progConfig.FunctionCallingMaxSteps)
+			functionCallingInfo = fmt.Sprintf("step limit (%d) reached", progConfig.FunctionCallingMaxSteps)
+			break
+		}
+		steps++
+
+		// record intermediate model output (calls, text) for the transcript
+		for _, part := range modelContent.Parts {
+			if !part.Thought {
+				functionCallParts = append(functionCallParts, part)
+			}
+		}
+
+		results := []*genai.Part{}
+		for _, call := range requested {
+			result := genai.NewPartFromFunctionResponse(call.Name, executeFunctionCall(ctx, call))
+			result.FunctionResponse.ID = call.ID
+			results = append(results, result)
+			calls++
+		}
+		functionCallParts = append(functionCallParts, results...)
+
+		now := time.Now()
+		fmt.Printf("%02d:%02d:%02d: Sending %d function %s (step %d) ...\n", now.Hour(), now.Minute(), now.Second(),
+			len(results), pluralize(len(results), "result"), steps)
+		next, err := sendResults(modelContent, results)
+		if err != nil {
+			return next, err
+		}
+		resp = next
+		usage = addUsageMetadata(usage, resp.UsageMetadata)
+	}
+
+	if calls > 0 {
+		info := fmt.Sprintf("%d %s in %d %s", calls, pluralize(calls, "call"), steps, pluralize(steps, "step"))
+		if functionCallingInfo != "" {
+			info += ", " + functionCallingInfo
+		}
+		functionCallingInfo = info
+	}
+	resp.UsageMetadata = usage
+	return resp, nil
+}
+
+/*
+executeFunctionCall executes a function call requested by the model and returns the response for the
+model: 'output' with the result or 'error' with the error details. Configured functions and MCP tools
+require approval (if configured), built-in workspace tools are read-only and restricted to the sandbox root.
+*/
+func executeFunctionCall(ctx context.Context, call *genai.FunctionCall) map[string]any {
+	if tool := findWorkspaceTool(call.Name); tool != nil {
+		now := time.Now()
+		fmt.Printf("%02d:%02d:%02d: Workspace tool '%s' %s ...\n", now.Hour(), now.Minute(), now.Second(), call.Name,
+			stringArgument(call.Args, "path")+stringArgument(call.Args, "pattern"))
+		result, err := tool.run(call.Args)
+		if err != nil {
+			fmt.Printf("error [%v] at workspace tool [%s]\n", err, call.Name)
+			return map[string]any{"error": err.Error()}
+		}
+		return map[string]any{"output": result}
+	}
+
+	arguments, err := json.Marshal(call.Args)
+	if err != nil {
+		return map[string]any{"error": fmt.Sprintf("invalid arguments: %v", err)}
+	}
+	if call.Args == nil {
+		arguments = []byte("{}")
+	}
+
+	if mcpFunction := findMCPFunction(call.Name); mcpFunction != nil {
+		return executeMCPFunction(ctx, mcpFunction, call, arguments)
+	}
+
+	function := findFunctionDefinition(call.Name)
+	if function == nil {
+		fmt.Printf("error [unknown function] at function call [%s]\n", call.Name)
+		return map[string]any{"error": fmt.Sprintf("unknown function [%s]", call.Name)}
+	}
+
+	if progConfig.FunctionCallingApproval && !approveFunctionCall(ctx, call.Name, arguments) {
+		fmt.Printf("Function call [%s] denied.\n", call.Name)
+		return map[string]any{"error": "function call denied by user"}
+	}
+
+	now := time.Now()
+	fmt.Printf("%02d:%02d:%02d: Executing function '%s' ...\n", now.Hour(), now.Minute(), now.Second(), call.Name)
+
+	if progConfig.FunctionCallingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(progConfig.FunctionCallingTimeout)*time.Second)
+		defer cancel()
+	}
+
+	var output []byte
+	if function.Command != "" {
+		output, err = runFunctionCommand(ctx, function, arguments)
+	} else {
+		output, err = callFunctionEndpoint(ctx, function, arguments)
+	}
+	if err != nil {
+		fmt.Printf("error [%v] at function call [%s]\n", err, call.Name)
+		return map[string]any{"error": err.Error()}
+	}
+	return map[string]any{"output": functionOutputValue(output)}
+}
+
+/*
+executeMCPFunction proxies a function call to the tool of an MCP server (after approval, if configured).
+*/
+func executeMCPFunction(ctx context.Context, function *mcpFunction, call *genai.FunctionCall, arguments []byte) map[string]any {
+	if progConfig.FunctionCallingApproval && !approveFunctionCall(ctx, call.Name, arguments) {
+		fmt.Printf("Function call [%s] denied.\n", call.Name)
+		return map[string]any{"error": "function call denied by user"}
+	}
+
+	now := time.Now()
+	fmt.Printf("%02d:%02d:%02d: Calling MCP tool '%s' of server '%s' ...\n", now.Hour(), now.Minute(), now.Second(),
+		function.tool.Name, function.client.config.Name)
+
+	if progConfig.FunctionCallingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(progConfig.FunctionCallingTimeout)*time.Second)
+		defer cancel()
+	}
+
+	result, err := function.client.callTool(ctx, function.tool.Name, call.Args)
+	if err == nil {
+		var value any
+		value, err = mcpToolResultValue(result)
+		if err == nil {
+			return map[string]any{"output": value}
+		}
+	}
+	fmt.Printf("error [%v] at MCP tool [%s]\n", err, call.Name)
+	return map[string]any{"error": err.Error()}
+}
+
+/*
+runFunctionCommand runs the command of a function. The arguments are passed as JSON on stdin and in the
+environment variable GEMPRO_FUNCTION_ARGS, the output is read from stdout.
+*/
+func runFunctionCommand(ctx context.Context, function *FunctionDefinition, arguments []byte) ([]byte, error) {
+	parsedArgs := splitCommandLine(function.Command)
+	if len(parsedArgs) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	cmd := exec.CommandContext(ctx, parsedArgs[0], parsedArgs[1:]...)
+	cmd.Stdin = bytes.NewReader(arguments)
+	cmd.Env = append(os.Environ(), "GEMPRO_FUNCTION_NAME="+function.Name, "GEMPRO_FUNCTION_ARGS="+string(arguments))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = strings.TrimSpace(stdout.String())
+		}
+		if message != "" {
+			return nil, fmt.Errorf("%v: %s", err, truncateFunctionOutput([]byte(message)))
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+/*
+callFunctionEndpoint calls the HTTP endpoint of a function with POST and the arguments as JSON body. The request
+uses the shared transport (internet proxy, CA bundle), like the Gemini API requests.
+*/
+func callFunctionEndpoint(ctx context.Context, function *FunctionDefinition, arguments []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, function.HTTPEndpoint, bytes.NewReader(arguments))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gem-Pro-Function", function.Name)
+
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4*functionResultMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("HTTP status %s: %s", resp.Status, truncateFunctionOutput(bytes.TrimSpace(body)))
+	}
+	return body, nil
+}
+
+/*
+functionOutputValue converts the output of a function into the result value sent to the model: JSON output
+is passed as structured value, any other output as (size limited) text.
+*/
+func functionOutputValue(output []byte) any {
+	output = bytes.TrimSpace(output)
+	if len(output) <= functionResultMaxSize && json.Valid(output) {
+		var value any
+		if json.Unmarshal(output, &value) == nil {
+			return value
+		}
+	}
+	return truncateFunctionOutput(output)
+}
+
+/*
+truncateFunctionOutput limits a function output to functionResultMaxSize bytes.
+*/
+func truncateFunctionOutput(output []byte) string {
+	if len(output) <= functionResultMaxSize {
+		return string(output)
+	}
+	return string(output[:functionResultMaxSize]) + fmt.Sprintf("\n... (truncated, %d bytes total)", len(output))
+}
+
+/*
+approveFunctionCall asks the user to approve a function call (FunctionCallingApproval). The answer is read
+from the terminal: via the terminal prompt reader if active, otherwise directly from the console device.
+Without terminal or if the request is cancelled while waiting, the call is denied.
+*/
+func approveFunctionCall(ctx context.Context, name string, arguments []byte) bool {
+	fmt.Printf("\nFunction call requested by the model:\n")
+	fmt.Printf("  Function  : %s\n", name)
+	fmt.Printf("  Arguments : %s\n", wrapString(string(arguments), progConfig.AnsiOutputLineLength, 14))
+	fmt.Printf("Execute function? [y/N]: ")
+
+	var answer string
+	if terminalReaderActive.Load() {
+		// discard a stale answer of a cancelled approval
+		select {
+		case <-approvalAnswers:
+		default:
+		}
+		approvalPending.Store(true)
+		select {
+		case answer = <-approvalAnswers:
+		case <-ctx.Done():
+			fmt.Printf("\nNote: approval cancelled\n")
+		}
+		approvalPending.Store(false)
+	} else {
+		console := "/dev/tty"
+		if runtime.GOOS == "windows" {
+			console = "CONIN$"
+		}
+		terminal, err := os.Open(console)
+		if err != nil {
+			fmt.Printf("\nNote: no terminal available for approval\n")
+			return false
+		}
+		defer func() { _ = terminal.Close() }()
+		answers := make(chan string, 1)
+		go func() {
+			line, _ := bufio.NewReader(terminal).ReadString('\n')
+			answers <- line
+		}()
+		select {
+		case answer = <-answers:
+		case <-ctx.Done():
+			fmt.Printf("\nNote: approval cancelled\n")
+		}
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+/*
+renderFunctionPart renders a function call or function result as Markdown (empty for other parts).
+*/
+func renderFunctionPart(part *genai.Part) string {
+	var sb strings.Builder
+	switch {
+	case part.FunctionCall != nil:
+		sb.WriteString(fmt.Sprintf("\nFunction Call: %s\n", part.FunctionCall.Name))
+		sb.WriteString("\n```json\n")
+		sb.WriteString(formatFunctionJSON(part.FunctionCall.Args))
+		sb.WriteString("\n```\n")
+	case part.FunctionResponse != nil:
+		sb.WriteString(fmt.Sprintf("\nFunction Result: %s\n", part.FunctionResponse.Name))
+		sb.WriteString("\n```json\n")
+		sb.WriteString(formatFunctionJSON(part.FunctionResponse.Response))
+		sb.WriteString("\n```\n")
+	}
+	return sb.String()
+}
+
+/*
+renderFunctionCalls renders the function calls and results of the current request as Markdown.
+*/
+func renderFunctionCalls() string {
+	var sb strings.Builder
+	for _, part := range functionCallParts {
+		if part.FunctionCall != nil || part.FunctionResponse != nil {
+			sb.WriteString(renderFunctionPart(part))
+			continue
+		}
+		if part.Text != "" {
+			sb.WriteString("\n" + removeSpacesBetweenNewlineAndCodeblock(part.Text) + "\n")
+		}
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n***\n\n")
+	}
+	return sb.String()
+}
+
+/*
+formatFunctionJSON formats function arguments or results as indented JSON.
+*/
+func formatFunctionJSON(value map[string]any) string {
+	if value == nil {
+		return "{}"
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -3,9 +3,9 @@ module klaus/KI/gem-pro
 go 1.25.5
 
 require (
+	cloud.google.com/go/auth v0.18.1
 	github.com/aquilax/truncate v1.0.1
 	github.com/charmbracelet/glamour v0.10.0
-	github.com/davecgh/go-spew v1.1.1
 	github.com/gabriel-vasile/mimetype v1.4.13
 	github.com/gofrs/uuid v4.4.0+incompatible
 	github.com/gohugoio/hugo-goldmark-extensions/passthrough v0.3.1
@@ -18,7 +18,6 @@ require (
 
 require (
 	cloud.google.com/go v0.123.0 // indirect
-	cloud.google.com/go/auth v0.18.1 // indirect
 	cloud.google.com/go/compute/metadata v0.9.0 // indirect
 	github.com/alecthomas/chroma/v2 v2.23.1 // indirect
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
@@ -29,6 +29,9 @@ func renderMarkdown2HTML(md string) string {
 	htmlDataModified = strings.ReplaceAll(htmlDataModified, "<!-- AI_THOUGHT_CONTENT_START -->", "") // remove marker
 	htmlDataModified = strings.ReplaceAll(htmlDataModified, "<!-- AI_THOUGHT_CONTENT_END -->", "</details>")
 
+	// inline citations as clickable anchors to list of sources
+	htmlDataModified = replaceCitationMarkersForHTML(htmlDataModified)
+
 	// replace HTML elements
 	for _, item := range progConfig.HTMLReplaceElements {
 		for key, value := range item {
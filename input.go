@@ -14,10 +14,11 @@ import (
 readPromptFromKeyboard reads user prompts from standard input (keyboard/stdin). It continuously reads
 lines. If a line starts with "<<<" followed by a filename (whitespace trimmed), it reads the content
 of that file and sends it as the prompt to the promptChannel. Otherwise, the line itself (if not empty)
-is treated as the prompt and sent to the channel. Errors during file reading are printed to stderr
-and the loop continues.
+is treated as the prompt and sent to the channel. Known slash commands (e.g. "/model pro") are sent as
+command to be executed by the main loop. Errors during file reading are printed to stderr and the loop continues.
 */
-func readPromptFromKeyboard(promptChannel chan string) {
+func readPromptFromKeyboard(promptChannel chan PromptRequest) {
+	terminalReaderActive.Store(true)
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		promptData, err := reader.ReadString('\n')
@@ -25,6 +26,11 @@ func readPromptFromKeyboard(promptChannel chan string) {
 			fmt.Printf("error [%v] at reader.ReadString()", err)
 			return
 		}
+		if approvalPending.Load() {
+			// answer to approval prompt of a function call
+			approvalAnswers <- promptData
+			continue
+		}
 		if promptData == "\n" || promptData == "\r\n" {
 			continue
 		}
@@ -39,10 +45,12 @@ func readPromptFromKeyboard(promptChannel chan string) {
 				continue
 			}
 			if len(fileData) > 0 {
-				promptChannel <- string(fileData)
+				promptChannel <- PromptRequest{Prompt: string(fileData)}
 			}
+		} else if isSlashCommand(promptData) {
+			promptChannel <- PromptRequest{Command: strings.TrimSpace(promptData)}
 		} else {
-			promptChannel <- promptData
+			promptChannel <- PromptRequest{Prompt: promptData}
 		}
 	}
 }
@@ -52,7 +60,7 @@ readPromptFromFile monitors a specified file for changes and reads its content a
 given file for modifications in size or modification time, and upon change, reads the file content and
 sends it to the prompt channel.
 */
-func readPromptFromFile(filePath string, promptChannel chan string) {
+func readPromptFromFile(filePath string, promptChannel chan PromptRequest) {
 	currentStat, err := os.Stat(filePath)
 	if err != nil {
 		fmt.Printf("error [%v] at os.Stat()", err)
@@ -68,7 +76,7 @@ func readPromptFromFile(filePath string, promptChannel chan string) {
 				fmt.Printf("error [%v] at os.ReadFile()", err)
 			}
 			if len(promptData) > 0 {
-				promptChannel <- string(promptData)
+				promptChannel <- PromptRequest{Prompt: string(promptData)}
 			}
 			currentStat = stat
 		}
@@ -79,9 +87,9 @@ func readPromptFromFile(filePath string, promptChannel chan string) {
 /*
 readPromptFromLocalhost creates an HTTP handler function to receive prompts from localhost. It sets up an
 HTTP handler that listens for POST requests on localhost, reads the request body as a prompt, and sends it
-through the prompt channel.
+through the prompt channel. The answer is not returned, use the JSON API ('/v1/prompt') for this purpose.
 */
-func readPromptFromLocalhost(promptChannel chan string) http.HandlerFunc {
+func readPromptFromLocalhost(promptChannel chan PromptRequest) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -96,7 +104,7 @@ func readPromptFromLocalhost(promptChannel chan string) http.HandlerFunc {
 			http.Error(w, "prompt empty", http.StatusBadRequest)
 			return
 		}
-		promptChannel <- string(body)
+		promptChannel <- PromptRequest{Prompt: string(body)}
 		defer func() { _ = r.Body.Close() }()
 
 		_, _ = fmt.Fprintln(w, "prompt received")
@@ -107,7 +115,7 @@ func readPromptFromLocalhost(promptChannel chan string) http.HandlerFunc {
 readPromptFromPipe reads the complete content from standard input (pipe) until EOF.
 It sends the content as a single prompt to the promptChannel.
 */
-func readPromptFromPipe(promptChannel chan string) {
+func readPromptFromPipe(promptChannel chan PromptRequest) {
 	// Read everything from the pipe (until EOF)
 	data, err := io.ReadAll(os.Stdin)
 	if err != nil {
@@ -120,5 +128,5 @@ func readPromptFromPipe(promptChannel chan string) {
 		os.Exit(0)
 	}
 
-	promptChannel <- string(data)
+	promptChannel <- PromptRequest{Prompt: string(data)}
 }
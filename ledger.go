@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// LedgerEntry represents the token usage of one request (or one cache creation) in the usage ledger
+type LedgerEntry struct {
+	Timestamp           time.Time `json:"timestamp"`
+	Type                string    `json:"type"` // 'request' or 'cache'
+	Model               string    `json:"model"`
+	Slug                string    `json:"slug,omitempty"`
+	Batch               bool      `json:"batch,omitempty"` // batch requests are charged at 50%
+	PromptTokens        int32     `json:"promptTokens"`
+	CachedTokens        int32     `json:"cachedTokens"`
+	ToolUsePromptTokens int32     `json:"toolUsePromptTokens"`
+	CandidatesTokens    int32     `json:"candidatesTokens"`
+	ThoughtsTokens      int32     `json:"thoughtsTokens"`
+	TotalTokens         int32     `json:"totalTokens"`
+	CacheStorageHours   float64   `json:"cacheStorageHours,omitempty"` // cache: time to live (tokens are stored for this time)
+}
+
+// ModelPrice represents the prices of a model in currency units per 1 million tokens
+type ModelPrice struct {
+	Input        float64 `yaml:"Input"`        // uncached prompt and tool use prompt tokens
+	CachedInput  float64 `yaml:"CachedInput"`  // cached prompt tokens
+	Output       float64 `yaml:"Output"`       // candidates and thoughts tokens
+	CacheStorage float64 `yaml:"CacheStorage"` // cached tokens per hour
+}
+
+// usageTotals accumulates ledger entries for the usage report
+type usageTotals struct {
+	requests      int
+	input         int64
+	cached        int64
+	output        int64
+	total         int64
+	storageHours  float64 // token hours (cache storage)
+	cost          float64
+	missingPrices bool
+}
+
+/*
+appendUsageToLedger appends the token usage of a response to the usage ledger (JSONL file).
+*/
+func appendUsageToLedger(resp *genai.GenerateContentResponse, slug string) {
+	if !progConfig.UsageLedger || resp == nil || resp.UsageMetadata == nil {
+		return
+	}
+	u := resp.UsageMetadata
+	writeLedgerEntry(LedgerEntry{
+		Timestamp:           finishProcessing,
+		Type:                "request",
+		Model:               progConfig.GeminiAiModel,
+		Slug:                slug,
+		Batch:               batchJobName != "",
+		PromptTokens:        u.PromptTokenCount,
+		CachedTokens:        u.CachedContentTokenCount,
+		ToolUsePromptTokens: u.ToolUsePromptTokenCount,
+		CandidatesTokens:    u.CandidatesTokenCount,
+		ThoughtsTokens:      u.ThoughtsTokenCount,
+		TotalTokens:         u.TotalTokenCount,
+	})
+}
+
+/*
+addUsageMetadata combines the token usage of two responses (e.g. the steps of a function calling loop).
+Token details by modality are not combined.
+*/
+func addUsageMetadata(total *genai.GenerateContentResponseUsageMetadata, usage *genai.GenerateContentResponseUsageMetadata) *genai.GenerateContentResponseUsageMetadata {
+	if total == nil {
+		return usage
+	}
+	if usage == nil {
+		return total
+	}
+	combined := *usage
+	combined.PromptTokenCount += total.PromptTokenCount
+	combined.CachedContentTokenCount += total.CachedContentTokenCount
+	combined.ToolUsePromptTokenCount += total.ToolUsePromptTokenCount
+	combined.CandidatesTokenCount += total.CandidatesTokenCount
+	combined.ThoughtsTokenCount += total.ThoughtsTokenCount
+	combined.TotalTokenCount += total.TotalTokenCount
+	combined.PromptTokensDetails = nil
+	combined.CacheTokensDetails = nil
+	combined.CandidatesTokensDetails = nil
+	combined.ToolUsePromptTokensDetails = nil
+	return &combined
+}
+
+/*
+appendCacheToLedger appends the creation of an AI model specific cache to the usage ledger. The cached
+tokens are charged once as input and additionally as storage for the time to live of the cache.
+*/
+func appendCacheToLedger(cachedContent *genai.CachedContent) {
+	if !progConfig.UsageLedger || cachedContent == nil || cachedContent.UsageMetadata == nil {
+		return
+	}
+	hours := float64(progConfig.GeminiCacheTimeToLive)
+	if !cachedContent.ExpireTime.IsZero() && !cachedContent.CreateTime.IsZero() {
+		hours = cachedContent.ExpireTime.Sub(cachedContent.CreateTime).Hours()
+	}
+	writeLedgerEntry(LedgerEntry{
+		Timestamp:         time.Now(),
+		Type:              "cache",
+		Model:             progConfig.GeminiAiModel,
+		Slug:              progConfig.GeminiCacheName,
+		PromptTokens:      cachedContent.UsageMetadata.TotalTokenCount,
+		TotalTokens:       cachedContent.UsageMetadata.TotalTokenCount,
+		CacheStorageHours: hours,
+	})
+}
+
+/*
+writeLedgerEntry appends one entry (one line of JSON) to the usage ledger file.
+*/
+func writeLedgerEntry(entry LedgerEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("error [%v] at json.Marshal()\n", err)
+		return
+	}
+
+	file, err := os.OpenFile(progConfig.UsageLedgerFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("error [%v] at os.OpenFile()\n", err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		fmt.Printf("error [%v] at file.Write()\n", err)
+	}
+}
+
+/*
+readLedgerEntries reads all entries of the usage ledger file. Damaged lines are reported and skipped.
+*/
+func readLedgerEntries() ([]LedgerEntry, error) {
+	file, err := os.Open(progConfig.UsageLedgerFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	entries := []LedgerEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := LedgerEntry{}
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			fmt.Printf("  error [%v] in line %d of usage ledger, line skipped\n", err, lineNumber)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+/*
+modelPrice returns the price table of a model (model names with or without 'models/' prefix).
+*/
+func modelPrice(model string) (ModelPrice, bool) {
+	price, ok := progConfig.UsagePrices[model]
+	if !ok {
+		price, ok = progConfig.UsagePrices[strings.TrimPrefix(model, "models/")]
+	}
+	if !ok {
+		price, ok = progConfig.UsagePrices["models/"+strings.TrimPrefix(model, "models/")]
+	}
+	return price, ok
+}
+
+/*
+estimateLedgerEntryCost estimates the cost of a ledger entry based on the price table of the model.
+It returns false, if no prices are configured for the model.
+*/
+func estimateLedgerEntryCost(entry LedgerEntry) (float64, bool) {
+	price, ok := modelPrice(entry.Model)
+	if !ok {
+		return 0, false
+	}
+
+	uncachedInput := max(entry.PromptTokens-entry.CachedTokens, 0) + entry.ToolUsePromptTokens
+	output := entry.CandidatesTokens + entry.ThoughtsTokens
+
+	cost := float64(uncachedInput)*price.Input +
+		float64(entry.CachedTokens)*price.CachedInput +
+		float64(output)*price.Output +
+		float64(entry.PromptTokens)*entry.CacheStorageHours*price.CacheStorage
+	cost /= 1000000.0
+
+	if entry.Batch {
+		cost *= 0.5
+	}
+	return cost, true
+}
+
+/*
+printUsageReport prints the totals and estimated cost of the usage ledger, grouped by day, month or model.
+*/
+func printUsageReport(grouping string) {
+	var keyOf func(entry LedgerEntry) string
+	switch strings.ToLower(grouping) {
+	case "day":
+		keyOf = func(entry LedgerEntry) string { return entry.Timestamp.Local().Format("2006-01-02") }
+	case "month":
+		keyOf = func(entry LedgerEntry) string { return entry.Timestamp.Local().Format("2006-01") }
+	case "model":
+		keyOf = func(entry LedgerEntry) string { return strings.TrimPrefix(entry.Model, "models/") }
+	default:
+		fmt.Printf("error: unsupported usage report [%s] (day, month, model)\n", grouping)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nUsage report per %s ('%s'):\n", strings.ToLower(grouping), progConfig.UsageLedgerFile)
+
+	entries, err := readLedgerEntries()
+	if err != nil {
+		fmt.Printf("  error [%v] reading usage ledger\n", err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Printf("  none\n")
+		return
+	}
+
+	groups := map[string]*usageTotals{}
+	overall := &usageTotals{}
+	for _, entry := range entries {
+		key := keyOf(entry)
+		if groups[key] == nil {
+			groups[key] = &usageTotals{}
+		}
+		for _, totals := range []*usageTotals{groups[key], overall} {
+			if entry.Type == "request" {
+				totals.requests++
+			}
+			totals.input += int64(entry.PromptTokens) + int64(entry.ToolUsePromptTokens)
+			totals.cached += int64(entry.CachedTokens)
+			totals.output += int64(entry.CandidatesTokens) + int64(entry.ThoughtsTokens)
+			totals.total += int64(entry.TotalTokens)
+			totals.storageHours += float64(entry.PromptTokens) * entry.CacheStorageHours
+			cost, ok := estimateLedgerEntryCost(entry)
+			totals.cost += cost
+			if !ok {
+				totals.missingPrices = true
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("  %-28s %8s %12s %12s %12s %12s %14s %12s\n", "", "Requests", "Input", "Cached", "Output", "Total", "Cache (tok*h)", "Cost "+progConfig.UsageCurrency)
+	for _, key := range keys {
+		printUsageTotals(key, groups[key])
+	}
+	printUsageTotals("Total", overall)
+
+	if overall.missingPrices {
+		fmt.Printf("\n  * no prices configured for some models (UsagePrices), cost incomplete\n")
+	}
+
+	if budgetConfigured() {
+		fmt.Printf("\nBudget:\n")
+		showBudget("  ")
+	}
+}
+
+/*
+printUsageTotals prints one line of the usage report.
+*/
+func printUsageTotals(name string, totals *usageTotals) {
+	marker := ""
+	if totals.missingPrices {
+		marker = "*"
+	}
+	fmt.Printf("  %-28s %8d %12d %12d %12d %12d %14.0f %11.4f%s\n", name, totals.requests, totals.input, totals.cached,
+		totals.output, totals.total, totals.storageHours, totals.cost, marker)
+}
+
+/*
+handleStandaloneUsageActions handles the usage report of the usage ledger.
+*/
+func handleStandaloneUsageActions() {
+	if *usageReport != "" {
+		printUsageReport(*usageReport)
+		fmt.Printf("\n")
+		os.Exit(0)
+	}
+}
@@ -53,8 +53,7 @@ Remarks:
 - none
 
 ToDos:
-- Support grounding references in response (e.g., "... lorem ipsum.[7][8]" and later "7. Webpage XY").
-- Support batch mode.
+- none
 
 Links:
 - https://pkg.go.dev/google.golang.org/genai
@@ -66,6 +65,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -75,6 +75,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -165,35 +166,70 @@ var (
 	proImageModel   = flag.Bool("pro-image", false, "Specifies the Gemini AI pro image generation model (Nano Banana Pro).")
 	defaultModel    = flag.Bool("default", false, "Specifies the Gemini AI default model to use.")
 	candidates      = flag.Int("candidates", 0, "Specifies the number of candidate responses the AI should generate.")
-	config          = flag.String("config", progName+".yaml", "Specifies the name of the YAML configuration file.")
+	config          = flag.String("config", progName+".yaml", "Specifies the name of the project YAML configuration file (layered on the global configuration).")
 	// special handling for option 'filelist'
-	listModels       = flag.Bool("list-models", false, "Lists all available Gemini AI models and exits.")
-	chatmode         = flag.Bool("chatmode", false, "Enables chat mode, where the AI remembers conversation history within a session.")
-	uploadFiles      = flag.Bool("upload-files", false, "Uploads given files to Google File Store and exits.")
-	deleteFiles      = flag.Bool("delete-files", false, "Deletes given files from Google File Store and exits.")
-	listFiles        = flag.Bool("list-files", false, "Lists given files in Google File Store and exits.")
-	includeFiles     = flag.Bool("include-files", false, "Includes all previously uploaded files from Google File Store in prompt to Gemini AI.")
-	createCache      = flag.Bool("create-cache", false, "Creates a new AI model specific cache from given files and exits.")
-	deleteCache      = flag.Bool("delete-cache", false, "Deletes AI model specific cache and exits.")
-	listCache        = flag.Bool("list-cache", false, "Lists AI model specific cache and exits.")
-	includeCache     = flag.Bool("include-cache", false, "Includes AI model specific cache in prompt to Gemini AI.")
-	codeExecution    = flag.Bool("code-execution", false, "Lets Gemini use code to solve complex tasks.")
-	googleSearch     = flag.Bool("google-search", false, "Grounding with Google Search.")
-	urlContext       = flag.Bool("url-context", false, "Grounding with URL Context (read content from URLs in prompt).")
-	googleMaps       = flag.Bool("google-maps", false, "Grounding with Google Maps.")
-	createStore      = flag.String("create-store", "", "Creates a new FileSearchStore with the given name and displays its ID.")
-	deleteStore      = flag.String("delete-store", "", "Deletes the FileSearchStore with the given name or ID.")
-	listStores       = flag.Bool("list-stores", false, "Lists all FileSearchStores.")
-	addToStore       = flag.String("add-to-store", "", "Adds the given files (via args or -filelist) to the specified FileSearchStore (Name/ID).")
-	deleteFromStore  = flag.String("delete-from-store", "", "Deletes the specified FileSearchStore document (full Name/ID).")
-	listStoreContent = flag.String("list-store-content", "", "Lists all documents within the specified FileSearchStore (Name/ID).")
-	outputBase       = flag.String("out", "", "Specifies the base filename for the output files.\n E.g. 'response-1' -> 'response-1.md', 'response-1.html', 'response-1.ansi'.")
-	pureResponse     = flag.Bool("pure-response", false, "Pure response without any boilerplate.")
-	verbose          = flag.Bool("verbose", false, "Detailed output of configuration and model information.")
+	listModels          = flag.Bool("list-models", false, "Lists all available Gemini AI models and exits.")
+	chatmode            = flag.Bool("chatmode", false, "Enables chat mode, where the AI remembers conversation history within a session.")
+	uploadFiles         = flag.Bool("upload-files", false, "Uploads given files to Google File Store and exits.")
+	deleteFiles         = flag.Bool("delete-files", false, "Deletes given files from Google File Store and exits.")
+	listFiles           = flag.Bool("list-files", false, "Lists given files in Google File Store and exits.")
+	includeFiles        = flag.Bool("include-files", false, "Includes all previously uploaded files from Google File Store in prompt to Gemini AI.")
+	createCache         = flag.Bool("create-cache", false, "Creates a new AI model specific cache from given files and exits.")
+	deleteCache         = flag.Bool("delete-cache", false, "Deletes AI model specific cache and exits.")
+	listCache           = flag.Bool("list-cache", false, "Lists AI model specific cache and exits.")
+	includeCache        = flag.Bool("include-cache", false, "Includes AI model specific cache in prompt to Gemini AI.")
+	codeExecution       = flag.Bool("code-execution", false, "Lets Gemini use code to solve complex tasks.")
+	googleSearch        = flag.Bool("google-search", false, "Grounding with Google Search.")
+	urlContext          = flag.Bool("url-context", false, "Grounding with URL Context (read content from URLs in prompt).")
+	googleMaps          = flag.Bool("google-maps", false, "Grounding with Google Maps.")
+	functionCalling     = flag.Bool("function-calling", false, "Lets Gemini call the local functions defined in the configuration (section 'Functions').")
+	workspaceToolsFlag  = flag.Bool("workspace-tools", false, "Lets Gemini read files of the workspace (read_file, list_directory, glob, grep, file_stat).")
+	mcpTools            = flag.Bool("mcp-tools", false, "Lets Gemini use the tools of the MCP servers defined in the configuration (section 'MCPServers').")
+	createStore         = flag.String("create-store", "", "Creates a new FileSearchStore with the given name and displays its ID.")
+	deleteStore         = flag.String("delete-store", "", "Deletes the FileSearchStore with the given name or ID.")
+	listStores          = flag.Bool("list-stores", false, "Lists all FileSearchStores.")
+	addToStore          = flag.String("add-to-store", "", "Adds the given files (via args or -filelist) to the specified FileSearchStore (Name/ID).")
+	deleteFromStore     = flag.String("delete-from-store", "", "Deletes the specified FileSearchStore document (full Name/ID).")
+	listStoreContent    = flag.String("list-store-content", "", "Lists all documents within the specified FileSearchStore (Name/ID).")
+	outputBase          = flag.String("out", "", "Specifies the base filename for the output files.\n E.g. 'response-1' -> 'response-1.md', 'response-1.html', 'response-1.ansi'.")
+	pureResponse        = flag.Bool("pure-response", false, "Pure response without any boilerplate.")
+	streamMode          = flag.Bool("stream", false, "Streams the response progressively to the terminal and the Markdown file.")
+	autoContinue        = flag.Bool("auto-continue", false, "Continues responses cut off at the output token limit (MAX_TOKENS) and stitches them together.")
+	schemaFile          = flag.String("schema", "", "Structured output: responses as JSON validated against the given JSON Schema file (re-asked once if invalid).")
+	batchSubmit         = flag.String("batch-submit", "", "Submits all prompts from the given JSONL file as batch job (50% price) and exits.")
+	batchStatus         = flag.String("batch-status", "", "Shows the status of the specified batch job (Name/ID) and exits.")
+	batchList           = flag.Bool("batch-list", false, "Lists all batch jobs and exits.")
+	batchCancel         = flag.String("batch-cancel", "", "Cancels the specified batch job (Name/ID) and exits.")
+	batchDelete         = flag.String("batch-delete", "", "Deletes the specified batch job (Name/ID) and exits.")
+	batchFetch          = flag.String("batch-fetch", "", "Fetches the results of the specified batch job (Name/ID) into the history and exits.")
+	listSessions        = flag.Bool("list-sessions", false, "Lists all saved chat sessions and exits.")
+	resumeSession       = flag.String("resume", "", "Resumes the specified chat session (ID) in chat mode.")
+	ignoreBudget        = flag.Bool("ignore-budget", false, "Sends requests even if a spending budget (daily/monthly) is exhausted.")
+	countTokens         = flag.Bool("count-tokens", false, "Counts the tokens of given files (via args or -filelist) per file and exits.")
+	dryRun              = flag.Bool("dry-run", false, "Assembles the first request (contents and model configuration), prints it and exits without sending it.")
+	dryRunJSON          = flag.Bool("dry-run-json", false, "Prints the dry-run request as JSON wire payload (use with -dry-run).")
+	showEffectiveConfig = flag.Bool("show-effective-config", false, "Prints each configuration value with its origin (default, global, project, profile, env, flag) and exits.")
+	profile             = flag.String("profile", "", "Selects the named profile of the configuration (overrides the base configuration).")
+	fakeServer          = flag.Bool("fake-server", false, "Runs a local fake Gemini server (canned or scripted responses) for offline tests.")
+	mcpServerMode       = flag.Bool("mcp-server", false, "Runs as MCP server on stdio (tools: ask_gemini, query_store, list_file_search_stores, search_history, list_models).")
+	usageReport         = flag.String("usage-report", "", "Prints token usage and estimated cost from the usage ledger (day, month, model) and exits.")
+	verbose             = flag.Bool("verbose", false, "Detailed output of configuration and model information.")
 )
 var fileLists stringArray
 var includeStores stringArray
 
+// inFlightRequest holds the cancel function of the current Gemini request (nil if idle)
+var inFlightRequest struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+// outputMutex ensures that the program does not exit while output files are being written
+var outputMutex sync.Mutex
+
+// cancelExitWindow is the period in which a second Ctrl-C exits the program (after cancelling a request)
+const cancelExitWindow = 2 * time.Second
+
 /*
 main starts this program. It is the entry point of the application, responsible for parsing command-line
 arguments, loading configuration, initializing resources, and running the main prompt processing loop.
@@ -209,6 +245,11 @@ func main() {
 	flag.Usage = printUsage
 	flag.Parse()
 
+	// MCP server mode: standard output is reserved for protocol messages
+	if *mcpServerMode {
+		redirectOutputForMCPServer()
+	}
+
 	// track which flags were actually set by the user
 	setFlags := make(map[string]bool)
 	flag.Visit(func(f *flag.Flag) {
@@ -223,22 +264,18 @@ func main() {
 		fmt.Printf("  Info    : %s\n", progInfo)
 	}
 
-	if !fileExists(*config) {
-		writeConfig()
-	}
-	if !fileExists("README.md") {
-		writeReadme()
-	}
-	if !fileExists("gem-pro.png") {
-		writeGemProPng()
-	}
-	if !fileExists("user-system-instruction.txt") {
-		writeUserSystemInstruction()
+	// global configuration directory (default configuration, README, templates), created once
+	createGlobalConfigDir()
+
+	err = loadConfiguration(*config, setFlags["config"], *profile)
+	if err != nil {
+		fmt.Printf("error [%v] loading configuration\n", err)
+		os.Exit(1)
 	}
 
 	// 'assets' in current directory (to render current HTML file in current directory)
 	directory := "./assets"
-	if !dirExists(directory) {
+	if progConfig.HTMLRendering && !dirExists(directory) {
 		err = os.Mkdir(directory, 0750)
 		if err != nil && !os.IsExist(err) {
 			fmt.Printf("error [%v] at os.Mkdir()\n", err)
@@ -247,16 +284,6 @@ func main() {
 		writeAssets(".")
 	}
 
-	if !fileExists("./prompt-input.html") {
-		writePromptInput()
-	}
-
-	err = loadConfiguration(*config)
-	if err != nil {
-		fmt.Printf("error [%v] loading configuration\n", err)
-		os.Exit(1)
-	}
-
 	// handle custom output base filename
 	if *outputBase != "" {
 		progConfig.MarkdownPromptResponseFile = *outputBase + ".md"
@@ -293,6 +320,26 @@ func main() {
 		os.Exit(1)
 	}
 
+	// resume chat session (implies chat mode, model, stores and cache of session)
+	var chatSession *ChatSession
+	if *resumeSession != "" {
+		chatSession, err = loadChatSession(*resumeSession)
+		if err != nil {
+			fmt.Printf("error [%v] loading chat session\n", err)
+			os.Exit(1)
+		}
+		*chatmode = true
+		progConfig.GeminiAiModel = backendModelName(chatSession.Model)
+		if len(includeStores) == 0 {
+			includeStores = append(includeStores, chatSession.Stores...)
+		}
+	}
+
+	// FileSearchStores (RAG) are a Gemini API feature, requests with Vertex AI are sent without them
+	if len(includeStores) > 0 && !backendFeatureAvailable("FileSearchStores", "requests sent without RAG stores") {
+		includeStores = nil
+	}
+
 	// detect image model
 	if strings.Contains(progConfig.GeminiAiModel, "image") {
 		isImageRequest = true
@@ -328,16 +375,29 @@ func main() {
 	}
 
 	// handle standalone actions
+	handleStandaloneConfigActions(setFlags)
+	handleStandaloneFakeServerActions()
 	handleStandaloneFileActions()
 	handleStandaloneCacheActions()
 	handleStandaloneStoreActions()
+	handleStandaloneSessionActions()
+	handleStandaloneUsageActions()
+	handleStandaloneTokenActions()
 
 	if *listModels {
 		showAvailableGeminiModels(progConfig.AnsiOutputLineLength)
 		os.Exit(0)
 	}
 
-	if *includeFiles && *verbose {
+	if *includeFiles && *verbose && isVertexBackend() {
+		fmt.Printf("\nInclude files given via Cloud Storage (VertexFileURIs):\n")
+		if len(progConfig.VertexFileURIs) == 0 {
+			fmt.Printf("  none\n")
+		}
+		for _, uri := range progConfig.VertexFileURIs {
+			fmt.Printf("  %s (%s)\n", uri, gcsFileMimeType(uri))
+		}
+	} else if *includeFiles && *verbose && !*dryRun {
 		filelist := listFilesUploadedToGemini("  ")
 		fmt.Printf("\nInclude files given via Google file store:\n")
 		if len(filelist) == 0 {
@@ -349,7 +409,11 @@ func main() {
 
 	cacheName := ""
 	cacheDetails := ""
-	if *includeCache {
+	if *includeCache && *dryRun {
+		// dry-run: cache is not looked up, its configured name stands for it
+		cacheName = progConfig.GeminiCacheName
+		fmt.Printf("Note: dry-run, AI model specific cache '%s' not looked up\n", cacheName)
+	} else if *includeCache {
 		cacheName, cacheDetails = listAIModelSpecificCache("  ")
 		if len(cacheName) == 0 {
 			fmt.Printf("  error: no AI model specific cache found\n\n")
@@ -366,6 +430,19 @@ func main() {
 
 	// overwrite YAML config values with cli parameters
 	overwriteConfigValues(setFlags)
+	err = validateEffectiveConfiguration()
+	if err != nil {
+		fmt.Printf("error [%v] in configuration\n", err)
+		os.Exit(1)
+	}
+	err = loadResponseSchema()
+	if err != nil {
+		fmt.Printf("error [%v] loading response schema\n", err)
+		os.Exit(1)
+	}
+	if *mcpServerMode {
+		configureMCPServerMode()
+	}
 
 	// configure Markdown Passthrough Extension
 	passthroughExt := passthrough.New(passthrough.Config{
@@ -385,47 +462,40 @@ func main() {
 		goldmark.WithRendererOptions(html.WithUnsafe()),
 	)
 
+	// handle standalone batch actions (fetching results requires initialized renderers)
+	handleStandaloneBatchActions()
+
 	// create AI client
 	ctx := context.Background()
-	var client *genai.Client
-	if progConfig.GeneralInternetProxy != "" {
-		// create a ProxyRoundTripper with API key and proxy URL from configuration
-		proxyRoundTripper := &ProxyRoundTripper{
-			APIKey:   progConfig.GeminiAPIKey,
-			ProxyURL: progConfig.GeneralInternetProxy,
-		}
-
-		// create a custom HTTP client using the ProxyRoundTripper
-		httpClient := &http.Client{
-			Transport: proxyRoundTripper,
-		}
-
-		// APIKey shouldn't be necessary because the key is set in ProxyRoundTripper but without
-		// the option, NewClient() attempts to authenticate via Google Cloud SDK (ADC)
-
-		// indirect internet connection: client -> proxy -> internet
-		client, err = genai.NewClient(ctx, &genai.ClientConfig{
-			APIKey:     progConfig.GeminiAPIKey,
-			HTTPClient: httpClient,
-			Backend:    genai.BackendGeminiAPI,
-		})
-	} else {
-		// direct internet connection: client -> internet
-		client, err = genai.NewClient(ctx, &genai.ClientConfig{
-			APIKey:  progConfig.GeminiAPIKey,
-			Backend: genai.BackendGeminiAPI,
-		})
-	}
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		fmt.Printf("error [%v] creating AI client\n", err)
 		os.Exit(1)
 	}
 
-	// get Gemini AI model information
-	geminiModelInfo, err := client.Models.Get(ctx, progConfig.GeminiAiModel, nil)
-	if err != nil {
-		fmt.Printf("error [%v] getting AI model information\n", err)
-		return
+	// get Gemini AI model information (dry-run: no API call)
+	geminiModelInfo := &genai.Model{Name: progConfig.GeminiAiModel, DisplayName: "not retrieved (dry-run)"}
+	if !*dryRun {
+		geminiModelInfo, err = client.Models.Get(ctx, progConfig.GeminiAiModel, nil)
+		if err != nil {
+			fmt.Printf("error [%v] getting AI model information\n", err)
+			return
+		}
+	}
+
+	// start MCP servers (their tools are offered as functions), not in dry-run mode
+	if progConfig.MCPTools && *dryRun {
+		fmt.Printf("Note: dry-run, MCP servers not started (their tools are not part of the request)\n")
+	} else if progConfig.MCPTools {
+		startMCPServers(ctx)
+	}
+
+	// resumed chat session: cache of session (unless given via -include-cache)
+	if chatSession != nil {
+		if cacheName == "" {
+			cacheName = restoreChatSessionCache(ctx, client, chatSession)
+		}
+		chatSession.Cache = cacheName
 	}
 
 	// generate Gemini model configuration (adds cache if defined)
@@ -441,7 +511,7 @@ func main() {
 	}
 
 	// define prompt channel
-	promptChannel := make(chan string)
+	promptChannel := make(chan PromptRequest)
 
 	// set up signal handling for shutdown (e.g. Ctrl-C)
 	shutdownTrigger := make(chan os.Signal, 1)
@@ -458,9 +528,16 @@ func main() {
 		if progConfig.GeminiPureResponse {
 			fmt.Printf("  Running in pure-response mode.\n")
 		}
+		if progConfig.GeminiStreamResponse {
+			fmt.Printf("  Running in streaming mode.\n")
+		}
+		if autoContinueActive() {
+			fmt.Printf("  Running in auto-continue mode (max. %d %s).\n", progConfig.GeminiAutoContinueMaxSteps,
+				pluralize(progConfig.GeminiAutoContinueMaxSteps, "continuation"))
+		}
 
 		fmt.Printf("\nProgram termination:\n")
-		fmt.Printf("  Press CTRL-C to terminate this program.\n\n")
+		fmt.Printf("  Press CTRL-C to cancel the current request, press CTRL-C twice to terminate this program.\n\n")
 	}
 
 	// start graceful shutdown handler
@@ -470,7 +547,12 @@ func main() {
 	isPiped := isInputPiped()
 	inputPossibilities := []string{}
 
-	if isPiped {
+	if *mcpServerMode {
+		// MCP server mode: prompts are tool calls of the MCP client (stdin / stdout)
+		isPiped = false
+		go serveMCP(ctx, client, promptChannel)
+		inputPossibilities = append(inputPossibilities, "MCP client (stdio)")
+	} else if isPiped {
 		// pipe mode: read strictly from Stdin until EOF
 		go readPromptFromPipe(promptChannel)
 		inputPossibilities = append(inputPossibilities, "Pipe")
@@ -483,13 +565,37 @@ func main() {
 	chat := &genai.Chat{}
 	chatNumber := 1
 	if *chatmode {
-		chat, err = client.Chats.Create(ctx, progConfig.GeminiAiModel, geminiModelConfig, nil)
+		var history []*genai.Content
+		switch {
+		case chatSession != nil:
+			// resumed session: history of session, numbering continues
+			history = chatSession.History
+			chatNumber = chatSession.ChatNumber + 1
+			fmt.Printf("Resuming chat session '%s' (%d %s, %s) ...\n", chatSession.ID, chatSession.ChatNumber,
+				pluralize(chatSession.ChatNumber, "prompt"), strings.TrimPrefix(chatSession.Model, "models/"))
+		case progConfig.ChatSessionHistory:
+			chatSession = newChatSession(cacheName)
+		}
+		chat, err = client.Chats.Create(ctx, progConfig.GeminiAiModel, geminiModelConfig, history)
 		if err != nil {
 			fmt.Printf("error [%v] creating Gemini chat mode session\n", err)
 			os.Exit(1)
 		}
 	}
 
+	// state of running session (changeable by slash commands)
+	state := &sessionState{
+		client:         client,
+		modelInfo:      geminiModelInfo,
+		modelConfig:    geminiModelConfig,
+		isImageRequest: isImageRequest,
+		cacheName:      cacheName,
+		stores:         includeStores,
+		chat:           chat,
+		chatNumber:     chatNumber,
+		chatSession:    chatSession,
+	}
+
 	// start main loop: Prompt Gemini AI
 	// ---------------------------------
 	var resp *genai.GenerateContentResponse
@@ -502,8 +608,76 @@ func main() {
 		}
 
 		// read prompt from channel
-		prompt := <-promptChannel
-		prompt = strings.TrimSpace(prompt)
+		request := <-promptChannel
+		if request.Context != nil && request.Context.Err() != nil {
+			// caller has given up before processing (e.g. cancelled MCP tool call)
+			continue
+		}
+		prompt := strings.TrimSpace(request.Prompt)
+
+		// execute slash command (e.g. '/model pro') on running session
+		if request.Command != "" {
+			err = executeSlashCommand(ctx, state, request.Command)
+			if err != nil {
+				fmt.Printf("error [%v] executing command '%s'\n", err, request.Command)
+			}
+			continue
+		}
+
+		// conversation managed by caller (OpenAI-compatible API) is processed without chat session
+		useChat := *chatmode && request.Contents == nil
+
+		// apply per-request overrides (JSON API), restored after the response has been handled
+		savedConfig := progConfig
+		savedFilesToHandle := filesToHandle
+		modelConfig := state.modelConfig
+		requestStores := state.stores
+		if request.hasOverrides() {
+			err = applyPromptRequestOverrides(request, useChat)
+			if err != nil {
+				fmt.Printf("error [%v] applying prompt request overrides\n", err)
+				progConfig = savedConfig
+				filesToHandle = savedFilesToHandle
+				if request.Reply != nil {
+					request.Reply <- PromptResult{Error: err.Error(), statusCode: http.StatusBadRequest}
+				}
+				continue
+			}
+			// cache is model specific, therefore not usable with another model
+			requestCacheName := state.cacheName
+			if progConfig.GeminiAiModel != savedConfig.GeminiAiModel {
+				requestCacheName = ""
+			}
+			requestIsImage := state.isImageRequest || strings.Contains(progConfig.GeminiAiModel, "image")
+			if request.Stores != nil {
+				requestStores = request.Stores
+			}
+			modelConfig = generateGeminiModelConfig(requestIsImage, requestCacheName, requestStores)
+		}
+		if request.SystemInstruction != "" {
+			// system messages of caller extend the configured system instruction
+			requestModelConfig := *modelConfig
+			requestModelConfig.SystemInstruction = genai.NewContentFromText(finalSystemInstruction+"\n\nClient Instruction:\n"+request.SystemInstruction, "user")
+			modelConfig = &requestModelConfig
+		}
+
+		// refuse to send if spending budget is exhausted (nothing is sent in dry-run mode)
+		var budgetErr error
+		if !*dryRun {
+			budgetErr = checkBudget()
+		}
+		if budgetErr != nil {
+			fmt.Printf("error [%v] checking spending budget\n", budgetErr)
+			progConfig = savedConfig
+			filesToHandle = savedFilesToHandle
+			if request.Reply != nil {
+				request.Reply <- PromptResult{Error: budgetErr.Error(), statusCode: http.StatusPaymentRequired}
+			}
+			if isPiped {
+				os.Exit(1)
+			}
+			continue
+		}
 
 		now := time.Now()
 		if progConfig.NotifyPrompt {
@@ -516,8 +690,15 @@ func main() {
 		contents := []*genai.Content{} // prompt in non-chat mode
 		parts := []genai.Part{}        // prompt in chat mode
 
+		// files sent with this prompt (for pre-flight token count)
+		sentFiles := filesToHandle
+		if useChat && state.chatNumber > 1 {
+			sentFiles = state.pendingFiles
+		}
+		sentUploadedFiles := *includeFiles && (!useChat || state.chatNumber == 1)
+
 		// build prompt parts (filedata, text prompt) of type '[]*genai.Content' for non-chat mode
-		if !*chatmode {
+		if !useChat {
 			// handle files from commandline
 			for _, fileToHandle := range filesToHandle {
 				if fileToHandle.State == "error" {
@@ -531,23 +712,30 @@ func main() {
 				}
 				contents = append(contents, content)
 			}
-			if *includeFiles {
-				// handle uploaded files from Google file store
-				for file, err := range client.Files.All(ctx) {
-					if err != nil {
-						log.Fatalf("error [%v] iterating over uploaded files", err)
-					}
-					contents = append(contents, genai.NewContentFromURI(file.URI, file.MIMEType, "user"))
+			if *includeFiles && *dryRun {
+				fmt.Printf("Note: dry-run, uploaded files (-include-files) not retrieved\n")
+			} else if *includeFiles {
+				// handle uploaded files from Google file store (Cloud Storage files with Vertex AI)
+				remoteParts, err := includedRemoteFiles(ctx, client)
+				if err != nil {
+					log.Fatalf("error [%v] iterating over uploaded files", err)
+				}
+				for _, part := range remoteParts {
+					contents = append(contents, genai.NewContentFromParts([]*genai.Part{part}, "user"))
 				}
 			}
-			// add text prompt
-			contents = append(contents, genai.NewContentFromText(prompt, "user"))
+			// add text prompt (or conversation given by caller)
+			if request.Contents != nil {
+				contents = append(contents, request.Contents...)
+			} else {
+				contents = append(contents, genai.NewContentFromText(prompt, "user"))
+			}
 		}
 
 		// build prompt parts (filedata, uploaded files, text prompt) of type '[]genai.Part' for chat mode
-		if *chatmode {
+		if useChat {
 			// in chat mode we only add filedata to initial chat prompt
-			if chatNumber == 1 {
+			if state.chatNumber == 1 {
 				// handle files from commandline
 				for _, fileToHandle := range filesToHandle {
 					if fileToHandle.State == "error" {
@@ -561,46 +749,199 @@ func main() {
 					}
 					parts = append(parts, *content.Parts[0])
 				}
-				if *includeFiles {
-					// handle uploaded files from Google file store
-					for file, err := range client.Files.All(ctx) {
-						if err != nil {
-							log.Fatalf("error [%v] iterating over uploaded files", err)
-						}
-						parts = append(parts, *genai.NewPartFromFile(*file))
+				if *includeFiles && *dryRun {
+					fmt.Printf("Note: dry-run, uploaded files (-include-files) not retrieved\n")
+				} else if *includeFiles {
+					// handle uploaded files from Google file store (Cloud Storage files with Vertex AI)
+					remoteParts, err := includedRemoteFiles(ctx, client)
+					if err != nil {
+						log.Fatalf("error [%v] iterating over uploaded files", err)
+					}
+					for _, part := range remoteParts {
+						parts = append(parts, *part)
+					}
+				}
+			} else {
+				// files added during running chat (via slash command)
+				for _, fileToHandle := range state.pendingFiles {
+					if fileToHandle.State == "error" {
+						continue
 					}
+					content, err := convertFileToContent(fileToHandle.Filepath)
+					if err != nil {
+						fmt.Printf("error [%v] converting file to content\n", err)
+						continue
+					}
+					parts = append(parts, *content.Parts[0])
 				}
+				state.pendingFiles = nil
 			}
 			parts = append(parts, *genai.NewPartFromText(prompt))
 		}
 
-		if *chatmode {
+		// dry-run: show assembled request and exit without sending it
+		if *dryRun {
+			dryRunContents := contents
+			if useChat {
+				dryRunContents = chatRequestContents(state.chat, parts)
+			}
+			printDryRunRequest(dryRunContents, modelConfig, sentFiles, progConfig.GeminiStreamResponse || request.StreamText != nil)
+			if request.Reply != nil {
+				request.Reply <- PromptResult{Error: "dry run, request not sent", statusCode: http.StatusServiceUnavailable}
+			}
+			os.Exit(0)
+		}
+
+		// pre-flight token count, refuse to send if input token limit is exceeded (PreflightAbortOnLimit)
+		if progConfig.PreflightTokenCount {
+			err = preflightTokenCheck(ctx, state, useChat, contents, parts, sentFiles, sentUploadedFiles)
+			if err != nil {
+				fmt.Printf("error [%v] at pre-flight token count\n", err)
+				if useChat && state.chatNumber > 1 {
+					state.pendingFiles = sentFiles
+				}
+				progConfig = savedConfig
+				filesToHandle = savedFilesToHandle
+				if request.Reply != nil {
+					request.Reply <- PromptResult{Error: err.Error(), statusCode: http.StatusRequestEntityTooLarge}
+				}
+				if isPiped {
+					os.Exit(1)
+				}
+				continue
+			}
+		}
+
+		if useChat {
 			fmt.Printf("%02d:%02d:%02d: Processing prompt in chat mode ...\n", now.Hour(), now.Minute(), now.Second())
 		} else {
 			fmt.Printf("%02d:%02d:%02d: Processing prompt in non-chat mode ...\n", now.Hour(), now.Minute(), now.Second())
 		}
 
-		processPrompt(prompt, *chatmode, chatNumber)
+		outputMutex.Lock()
+		processPrompt(prompt, useChat, state.chatNumber)
+		outputMutex.Unlock()
 
-		dumpDataToFile(os.O_TRUNC|os.O_WRONLY, "gemini model config", geminiModelConfig)
-		dumpDataToFile(os.O_APPEND|os.O_CREATE|os.O_WRONLY, "gemini prompt contents", contents)
-
-		// generate content
+		// trace request (contents sent by chat include the chat history)
+		traceMode := "non-chat"
+		traceContents := contents
+		if useChat {
+			traceMode = "chat"
+			traceContents = chatRequestContents(state.chat, parts)
+		}
+		if progConfig.GeminiStreamResponse || request.StreamText != nil {
+			traceMode += ", stream"
+		}
+		trace := newTraceRecord(traceContents, modelConfig, traceMode)
+
+		// generate content (cancellable with Ctrl-C, retried on quota and transient errors)
+		requestCtx, cancelRequest := context.WithCancel(ctx)
+		setInFlightRequest(cancelRequest)
+		stopCallerCancel := func() bool { return false }
+		if request.Context != nil {
+			stopCallerCancel = context.AfterFunc(request.Context, cancelRequest)
+		}
 		startProcessing = time.Now()
-		if *chatmode {
-			// chat mode
-			resp, respErr = chat.SendMessage(ctx, parts...)
-		} else {
-			// non-chat mode: text AND image generation for Gemini 3 models
-			if isImageRequest {
-				fmt.Printf("%02d:%02d:%02d: Generating content (image/text) ...\n", now.Hour(), now.Minute(), now.Second())
+		fallbackInfo = ""
+		functionCallParts = nil
+		functionCallingInfo = ""
+		continuationInfo = ""
+		structuredOutputInfo = ""
+		structuredResult = nil
+		generate := func(model string, modelConfig *genai.GenerateContentConfig, chat *genai.Chat) (*genai.GenerateContentResponse, error) {
+			switch {
+			case progConfig.GeminiStreamResponse && useChat:
+				// chat mode (streaming)
+				return streamResponse(chat.SendMessageStream(requestCtx, parts...), nil)
+			case progConfig.GeminiStreamResponse || request.StreamText != nil:
+				// non-chat mode (streaming)
+				return streamResponse(client.Models.GenerateContentStream(requestCtx, model, contents, modelConfig), request.StreamText)
+			case useChat:
+				// chat mode
+				return chat.SendMessage(requestCtx, parts...)
+			default:
+				// non-chat mode: text AND image generation for Gemini 3 models
+				if strings.Contains(model, "image") {
+					fmt.Printf("%02d:%02d:%02d: Generating content (image/text) ...\n", now.Hour(), now.Minute(), now.Second())
+				}
+				return client.Models.GenerateContent(requestCtx, model, contents, modelConfig)
 			}
-			resp, respErr = client.Models.GenerateContent(ctx, progConfig.GeminiAiModel, contents, geminiModelConfig)
+		}
+		selected := generationTarget{model: progConfig.GeminiAiModel, modelConfig: modelConfig, stores: requestStores, chat: state.chat}
+		target := selected
+		resp, retryAttempts, respErr = withRetry(requestCtx, "Request", func() (*genai.GenerateContentResponse, error) {
+			return generate(target.model, target.modelConfig, target.chat)
+		})
+		if respErr != nil && isRetryableError(respErr) && len(progConfig.GeminiFallbackAiModels) > 0 && requestCtx.Err() == nil {
+			// selected model exhausted or unavailable: try fallback models (also used for the follow-up requests)
+			resp, target, respErr = generateWithFallbackModels(requestCtx, state, useChat, selected, respErr, generate)
+		}
+		if respErr == nil && functionCallingActive() {
+			// function calling: execute the local functions requested by the model and send the results back
+			resp, respErr = runFunctionCallingLoop(requestCtx, resp, func(modelContent *genai.Content, results []*genai.Part) (*genai.GenerateContentResponse, error) {
+				if useChat {
+					parts = []genai.Part{}
+					for _, result := range results {
+						parts = append(parts, *result)
+					}
+				} else {
+					contents = append(contents, modelContent, genai.NewContentFromParts(results, genai.RoleUser))
+				}
+				stepResp, attempts, err := withRetry(requestCtx, "Request", func() (*genai.GenerateContentResponse, error) {
+					return generate(target.model, target.modelConfig, target.chat)
+				})
+				retryAttempts = append(retryAttempts, attempts...)
+				return stepResp, err
+			})
+		}
+		if respErr == nil && autoContinueActive() {
+			// auto-continue: send the partial answer back and let the model continue where it stopped
+			resp, respErr = runAutoContinueLoop(requestCtx, resp, func(partialContent *genai.Content) (*genai.GenerateContentResponse, error) {
+				if useChat {
+					// partial answer is part of the chat history
+					parts = []genai.Part{*genai.NewPartFromText(continuationInstruction)}
+				} else {
+					contents = append(contents, partialContent, genai.NewContentFromText(continuationInstruction, genai.RoleUser))
+				}
+				stepResp, attempts, err := withRetry(requestCtx, "Request", func() (*genai.GenerateContentResponse, error) {
+					return generate(target.model, target.modelConfig, target.chat)
+				})
+				retryAttempts = append(retryAttempts, attempts...)
+				return stepResp, err
+			})
+		}
+		if respErr == nil && structuredOutputActive() && !isImageRequest {
+			// structured output: validate the JSON response, re-ask once with the validation errors
+			resp, respErr = runStructuredOutputCheck(requestCtx, resp, func(invalidContent *genai.Content, feedback string) (*genai.GenerateContentResponse, error) {
+				if useChat {
+					// invalid answer is part of the chat history
+					parts = []genai.Part{*genai.NewPartFromText(feedback)}
+				} else {
+					contents = append(contents, invalidContent, genai.NewContentFromText(feedback, genai.RoleUser))
+				}
+				stepResp, attempts, err := withRetry(requestCtx, "Request", func() (*genai.GenerateContentResponse, error) {
+					return generate(target.model, target.modelConfig, target.chat)
+				})
+				retryAttempts = append(retryAttempts, attempts...)
+				return stepResp, err
+			})
+		}
+		if useChat && target.chat != selected.chat {
+			// fallback model answered: session continues with the selected model
+			restoreSelectedModelChat(ctx, state, selected.model, target.chat)
 		}
 		finishProcessing = time.Now()
+		if errors.Is(requestCtx.Err(), context.Canceled) {
+			respErr = fmt.Errorf("request cancelled by user (Ctrl-C)")
+			if request.Context != nil && request.Context.Err() != nil {
+				respErr = fmt.Errorf("request cancelled by client")
+			}
+		}
+		setInFlightRequest(nil)
+		stopCallerCancel()
+		cancelRequest()
 
-		dumpDataToFile(os.O_APPEND|os.O_CREATE|os.O_WRONLY, "gemini response", resp)
-		dumpDataToFile(os.O_APPEND|os.O_CREATE|os.O_WRONLY, "gemini error", err)
+		finishTraceRecord(trace, resp, respErr)
 
 		// trigger response notification
 		if progConfig.NotifyResponse {
@@ -611,7 +952,21 @@ func main() {
 		}
 
 		// handle response
-		handleResponse(resp, respErr, prompt)
+		outputMutex.Lock()
+		slug, historyFiles := handleResponse(resp, respErr, prompt)
+
+		// return result to caller (JSON API)
+		if request.Reply != nil {
+			request.Reply <- buildPromptResult(resp, respErr, slug, historyFiles)
+		}
+		progConfig = savedConfig
+		filesToHandle = savedFilesToHandle
+
+		// persist chat session (history + metadata)
+		if useChat && respErr == nil && state.chatSession != nil && progConfig.ChatSessionHistory {
+			saveChatSession(state.chatSession, state.chat, state.chatNumber)
+		}
+		outputMutex.Unlock()
 
 		// If input was piped, we are in "One-Shot" mod: process one prompt, get one response, and exit.
 		if isPiped {
@@ -619,8 +974,8 @@ func main() {
 		}
 
 		// increase chat number
-		if *chatmode {
-			chatNumber++
+		if useChat {
+			state.chatNumber++
 		}
 	}
 	// end main loop: Prompt Gemini AI
@@ -649,17 +1004,36 @@ func overwriteConfigValues(setFlags map[string]bool) {
 	if setFlags["google-maps"] {
 		progConfig.GeminiGroundigWithGoogleMaps = *googleMaps
 	}
+	if setFlags["function-calling"] {
+		progConfig.FunctionCalling = *functionCalling
+	}
+	if setFlags["workspace-tools"] {
+		progConfig.WorkspaceTools = *workspaceToolsFlag
+	}
+	if setFlags["mcp-tools"] {
+		progConfig.MCPTools = *mcpTools
+	}
 	if setFlags["pure-response"] {
 		progConfig.GeminiPureResponse = *pureResponse
 	}
+	if setFlags["stream"] {
+		progConfig.GeminiStreamResponse = *streamMode
+	}
+	if setFlags["auto-continue"] {
+		progConfig.GeminiAutoContinue = *autoContinue
+	}
+	if setFlags["schema"] {
+		progConfig.GeminiResponseSchemaFile = *schemaFile
+	}
+	recordFlagOrigins(setFlags)
 }
 
 /*
 handleResponse processes the response received from the Gemini AI model. It manages the AI response, including
 error handling, output formatting, saving history, and triggering output applications for different formats
-like Markdown and HTML.
+like Markdown and HTML. It returns the slug and the paths of the history files written.
 */
-func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt string) {
+func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt string) (string, HistoryFiles) {
 	now := finishProcessing
 	fmt.Printf("%02d:%02d:%02d: Processing response ...\n", now.Hour(), now.Minute(), now.Second())
 	switch {
@@ -686,9 +1060,16 @@ func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt s
 			fullText = getCandidateText(resp.Candidates[0], true)
 		}
 		_, slug = extractAndCleanSlug(fullText)
+		if slug == "" && structuredOutputActive() {
+			slug = structuredSlug()
+		}
 	}
 
+	// record token usage in usage ledger
+	appendUsageToLedger(resp, slug)
+
 	// print prompt and response to terminal
+	historyFiles := HistoryFiles{}
 	if progConfig.AnsiOutput {
 		printPromptResponseToTerminal()
 	}
@@ -698,6 +1079,7 @@ func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt s
 		ansiDestinationFile := buildDestinationFilename(now, slug, "ansi")
 		ansiDestinationPathFile := filepath.Join(progConfig.AnsiHistoryDirectory, ansiDestinationFile)
 		copyFile(progConfig.AnsiPromptResponseFile, ansiDestinationPathFile)
+		historyFiles.Ansi = ansiDestinationPathFile
 	}
 
 	// markdown prompt and response file: nothing to do
@@ -708,9 +1090,21 @@ func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt s
 		markdownDestinationFile := buildDestinationFilename(now, slug, "md")
 		markdownDestinationPathFile := filepath.Join(progConfig.MarkdownHistoryDirectory, markdownDestinationFile)
 		copyFile(progConfig.MarkdownPromptResponseFile, markdownDestinationPathFile)
+		historyFiles.Markdown = markdownDestinationPathFile
 		commandLine = fmt.Sprintf(progConfig.MarkdownOutputApplication, "\""+markdownDestinationPathFile+"\"")
 	}
 
+	// structured output: JSON of the response as history artifact (alongside the Markdown history)
+	if structuredResult != nil && respErr == nil && progConfig.MarkdownHistory {
+		jsonDestinationPathFile := filepath.Join(progConfig.MarkdownHistoryDirectory, buildDestinationFilename(now, slug, "json"))
+		err := os.WriteFile(jsonDestinationPathFile, append(structuredResult, '\n'), 0600)
+		if err != nil {
+			fmt.Printf("error [%v] writing JSON history file\n", err)
+		} else {
+			historyFiles.JSON = jsonDestinationPathFile
+		}
+	}
+
 	// open markdown document in application
 	if progConfig.MarkdownOutput {
 		err := runCommand(commandLine)
@@ -728,6 +1122,7 @@ func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt s
 		htmlDestinationFile := buildDestinationFilename(now, slug, "html")
 		htmlDestinationPathFile := filepath.Join(progConfig.HTMLHistoryDirectory, htmlDestinationFile)
 		copyFile(progConfig.HTMLPromptResponseFile, htmlDestinationPathFile)
+		historyFiles.HTML = htmlDestinationPathFile
 		commandLine = fmt.Sprintf(progConfig.HTMLOutputApplication, "\""+htmlDestinationPathFile+"\"")
 	}
 
@@ -738,6 +1133,8 @@ func handleResponse(resp *genai.GenerateContentResponse, respErr error, prompt s
 			fmt.Printf("error [%v] at runCommand()\n", err)
 		}
 	}
+
+	return slug, historyFiles
 }
 
 /*
@@ -770,21 +1167,47 @@ func printGeminiModelInfo(geminiModelInfo *genai.Model, terminalWidth int) {
 }
 
 /*
-handleShutdown handles program termination signals (SIGINT and SIGTERM). It listens for shutdown signals
-and performs a graceful program exit when a signal is received, ensuring resources are properly released.
+handleShutdown handles program termination signals (SIGINT and SIGTERM). The first SIGINT (Ctrl-C) during a
+running request cancels only this request. A second SIGINT within a short window, a SIGINT while idle, or
+SIGTERM performs a graceful program exit after output files have been completely written.
 */
 func handleShutdown(shutdownTrigger chan os.Signal) {
-	<-shutdownTrigger
+	var lastInterrupt time.Time
+	for signalReceived := range shutdownTrigger {
+		inFlightRequest.Lock()
+		cancel := inFlightRequest.cancel
+		inFlightRequest.Unlock()
+
+		if signalReceived == syscall.SIGINT && cancel != nil && time.Since(lastInterrupt) > cancelExitWindow {
+			lastInterrupt = time.Now()
+			cancel()
+			fmt.Printf("\nCancelling current request (press CTRL-C again within %v to exit) ...\n", cancelExitWindow)
+			continue
+		}
+		break
+	}
+
 	fmt.Printf("\nShutdown signal received. Exiting gracefully ...\n")
+	outputMutex.Lock() // wait for output files to be written completely
+	stopMCPServers()
 	os.Exit(0)
 }
 
+/*
+setInFlightRequest registers the cancel function of the current Gemini request (nil if no request is running).
+*/
+func setInFlightRequest(cancel context.CancelFunc) {
+	inFlightRequest.Lock()
+	inFlightRequest.cancel = cancel
+	inFlightRequest.Unlock()
+}
+
 /*
 startInputReaders initializes and starts input reader goroutines based on the program configuration. It sets
 up and starts goroutines for reading prompts from different input sources like terminal, file, or localhost,
 based on the configuration.
 */
-func startInputReaders(promptChannel chan string, config ProgConfig) []string {
+func startInputReaders(promptChannel chan PromptRequest, config ProgConfig) []string {
 	inputPossibilities := []string{}
 
 	// input from keyboard
@@ -811,6 +1234,11 @@ func startInputReaders(promptChannel chan string, config ProgConfig) []string {
 	if config.InputFromLocalhost {
 		addr := fmt.Sprintf("localhost:%d", config.InputLocalhostPort)
 		go func() {
+			http.HandleFunc("/v1/prompt", secureLocalAPI(config, writeJSONError, handlePromptAPI(config, promptChannel)))
+			if config.InputLocalhostOpenAI {
+				http.HandleFunc("/v1/chat/completions", secureLocalAPI(config, writeOpenAIError, handleOpenAIChatCompletions(promptChannel)))
+				http.HandleFunc("/v1/models", secureLocalAPI(config, writeOpenAIError, handleOpenAIModels(config)))
+			}
 			http.HandleFunc("/", readPromptFromLocalhost(promptChannel))
 			err := http.ListenAndServe(addr, nil)
 			if err != nil {
@@ -830,6 +1258,7 @@ command-line arguments and a file list, checks their state, and prepares a list
 for further processing.
 */
 func buildGivenFiles(args []string, filelists []string) []FileToHandle {
+	var givenFiles []FileToHandle
 	var filesFromList []string
 
 	// iterate over all specified list files
@@ -871,16 +1300,21 @@ func buildGivenFiles(args []string, filelists []string) []FileToHandle {
 				fileToHandle.ErrorMessage = fmt.Sprintf("error [%v] at getFileMimeType()", err)
 			}
 		}
-		filesToHandle = append(filesToHandle, fileToHandle)
+		givenFiles = append(givenFiles, fileToHandle)
 	}
 
-	return filesToHandle
+	return givenFiles
 }
 
 /*
 handleStandaloneFileActions handles file upload, delete and list.
 */
 func handleStandaloneFileActions() {
+	if (*uploadFiles || *deleteFiles || *listFiles) &&
+		!backendFeatureAvailable("Google file store (Files API)", "use Cloud Storage files via VertexFileURIs and -include-files") {
+		os.Exit(1)
+	}
+
 	switch {
 	case *uploadFiles:
 		fmt.Printf("\nUploading files to Google file store:\n")
@@ -951,6 +1385,10 @@ func handleStandaloneCacheActions() {
 handleStandaloneStoreActions processes the new store commands.
 */
 func handleStandaloneStoreActions() {
+	if (*listStores || *createStore != "" || *deleteStore != "" || *addToStore != "" || *deleteFromStore != "" || *listStoreContent != "") &&
+		!backendFeatureAvailable("FileSearchStores", "") {
+		os.Exit(1)
+	}
 	if *listStores {
 		fmt.Printf("\nListing FileSearchStores:\n")
 		listGeminiFileSearchStores("  ")
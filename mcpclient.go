@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// mcpProtocolVersion is the Model Context Protocol version requested by the client
+const mcpProtocolVersion = "2025-06-18"
+
+// mcpMessageMaxSize is the maximum size of a JSON-RPC message read from an MCP server (bytes)
+const mcpMessageMaxSize = 16 * 1024 * 1024
+
+// MCPServerConfig describes an MCP server started by gem-pro (section 'MCPServers')
+type MCPServerConfig struct {
+	Name         string            `yaml:"Name"`
+	Command      string            `yaml:"Command"`      // command line starting the server (stdio transport)
+	Env          map[string]string `yaml:"Env"`          // additional environment variables
+	AllowedTools []string          `yaml:"AllowedTools"` // empty = all tools of the server
+}
+
+// jsonRPCMessage is a JSON-RPC 2.0 message (request, notification or response) of the Model Context Protocol
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+// jsonRPCError is the error object of a JSON-RPC 2.0 response
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool is a tool offered by an MCP server (result of 'tools/list')
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpContent is a content item of an MCP tool result
+type mcpContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Resource *struct {
+		URI  string `json:"uri"`
+		Text string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+// mcpToolResult is the result of an MCP tool call ('tools/call')
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+// mcpClient is the connection to a running MCP server (JSON-RPC over stdin / stdout of the server process)
+type mcpClient struct {
+	config     MCPServerConfig
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	nextID     int64
+	pending    map[int64]chan *jsonRPCMessage
+	done       chan struct{} // closed when the server output ends
+	serverInfo string
+	tools      []mcpTool
+}
+
+// mcpFunction maps a function offered to Gemini to the tool of an MCP server
+type mcpFunction struct {
+	name   string
+	client *mcpClient
+	tool   mcpTool
+}
+
+// mcpClients holds the started MCP servers, mcpFunctions the functions offered to Gemini (in order)
+var (
+	mcpClients   = map[string]*mcpClient{}
+	mcpFunctions []mcpFunction
+)
+
+// mcpNamePattern defines valid MCP server names (part of the function names)
+var mcpNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+/*
+validateMCPConfiguration validates the MCP server configuration (section 'MCPServers'): valid and unique names
+and a command.
+*/
+func validateMCPConfiguration() error {
+	names := map[string]bool{}
+	for _, server := range progConfig.MCPServers {
+		if !mcpNamePattern.MatchString(server.Name) {
+			return fmt.Errorf("invalid MCP server name [%s]", server.Name)
+		}
+		if names[server.Name] {
+			return fmt.Errorf("duplicate MCP server name [%s]", server.Name)
+		}
+		names[server.Name] = true
+		if strings.TrimSpace(server.Command) == "" {
+			return fmt.Errorf("empty command of MCP server [%s] not allowed", server.Name)
+		}
+	}
+	return nil
+}
+
+/*
+startMCPServers starts the configured MCP servers not yet running, performs the handshake (initialize,
+tools/list) and registers the allowed tools as functions. Servers failing to start are reported and skipped.
+*/
+func startMCPServers(ctx context.Context) {
+	for _, server := range progConfig.MCPServers {
+		if _, ok := mcpClients[server.Name]; ok {
+			continue
+		}
+		client, err := startMCPServer(ctx, server)
+		if err != nil {
+			fmt.Printf("error [%v] starting MCP server [%s]\n", err, server.Name)
+			continue
+		}
+		mcpClients[server.Name] = client
+
+		offered := 0
+		for _, tool := range client.tools {
+			name := mcpFunctionName(server.Name, tool.Name)
+			if slices.ContainsFunc(mcpFunctions, func(f mcpFunction) bool { return f.name == name }) ||
+				slices.Contains(functionNames(), name) || isWorkspaceToolName(name) {
+				fmt.Printf("Note: MCP tool [%s] of server [%s] skipped (duplicate function name)\n", tool.Name, server.Name)
+				continue
+			}
+			mcpFunctions = append(mcpFunctions, mcpFunction{name: name, client: client, tool: tool})
+			offered++
+		}
+		fmt.Printf("MCP server '%s' started (%s, %d %s)\n", server.Name, client.serverInfo,
+			offered, pluralize(offered, "tool"))
+	}
+}
+
+/*
+startMCPServer starts an MCP server process, performs the initialize handshake and lists its tools (filtered
+by the allowed tools).
+*/
+func startMCPServer(ctx context.Context, server MCPServerConfig) (*mcpClient, error) {
+	parsedArgs := splitCommandLine(server.Command)
+	cmd := exec.Command(parsedArgs[0], parsedArgs[1:]...)
+	cmd.Env = os.Environ()
+	for key, value := range server.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	if *verbose {
+		cmd.Stderr = os.Stderr // server logs
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &mcpClient{
+		config:  server,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: map[int64]chan *jsonRPCMessage{},
+		done:    make(chan struct{}),
+	}
+	go client.readMessages(stdout)
+
+	if progConfig.FunctionCallingTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(progConfig.FunctionCallingTimeout)*time.Second)
+		defer cancel()
+	}
+
+	err = client.initialize(ctx)
+	if err == nil {
+		err = client.listTools(ctx)
+	}
+	if err != nil {
+		client.stop()
+		return nil, err
+	}
+	return client, nil
+}
+
+/*
+initialize performs the MCP handshake: 'initialize' request followed by the 'notifications/initialized' notification.
+*/
+func (c *mcpClient) initialize(ctx context.Context) error {
+	params := map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": progName, "version": progVersion},
+	}
+	result, err := c.request(ctx, "initialize", params)
+	if err != nil {
+		return fmt.Errorf("error [%w] at initialize", err)
+	}
+	var initializeResult struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	err = json.Unmarshal(result, &initializeResult)
+	if err != nil {
+		return fmt.Errorf("error [%w] decoding initialize result", err)
+	}
+	c.serverInfo = strings.TrimSpace(initializeResult.ServerInfo.Name + " " + initializeResult.ServerInfo.Version)
+	if c.serverInfo == "" {
+		c.serverInfo = "unknown server"
+	}
+	return c.send(jsonRPCMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+/*
+listTools lists the tools of the server (all pages) and keeps the allowed tools.
+*/
+func (c *mcpClient) listTools(ctx context.Context) error {
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		result, err := c.request(ctx, "tools/list", params)
+		if err != nil {
+			return fmt.Errorf("error [%w] at tools/list", err)
+		}
+		var listResult struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		err = json.Unmarshal(result, &listResult)
+		if err != nil {
+			return fmt.Errorf("error [%w] decoding tools/list result", err)
+		}
+		for _, tool := range listResult.Tools {
+			if len(c.config.AllowedTools) == 0 || slices.Contains(c.config.AllowedTools, tool.Name) {
+				c.tools = append(c.tools, tool)
+			}
+		}
+		if listResult.NextCursor == "" {
+			return nil
+		}
+		cursor = listResult.NextCursor
+	}
+}
+
+/*
+callTool calls a tool of the server and returns its result.
+*/
+func (c *mcpClient) callTool(ctx context.Context, name string, arguments map[string]any) (*mcpToolResult, error) {
+	if arguments == nil {
+		arguments = map[string]any{}
+	}
+	result, err := c.request(ctx, "tools/call", map[string]any{"name": name, "arguments": arguments})
+	if err != nil {
+		return nil, err
+	}
+	toolResult := &mcpToolResult{}
+	err = json.Unmarshal(result, toolResult)
+	if err != nil {
+		return nil, fmt.Errorf("error [%w] decoding tools/call result", err)
+	}
+	return toolResult, nil
+}
+
+/*
+request sends a JSON-RPC request to the server and waits for the response (or cancellation, or the end of
+the server).
+*/
+func (c *mcpClient) request(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	paramsData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.nextID++
+	id := c.nextID
+	responseChannel := make(chan *jsonRPCMessage, 1)
+	c.pending[id] = responseChannel
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+	}()
+
+	err = c.send(jsonRPCMessage{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprintf("%d", id)), Method: method, Params: paramsData})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChannel:
+		if response.Error != nil {
+			return nil, fmt.Errorf("MCP error %d: %s", response.Error.Code, response.Error.Message)
+		}
+		return response.Result, nil
+	case <-c.done:
+		return nil, fmt.Errorf("MCP server [%s] terminated", c.config.Name)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/*
+send writes a JSON-RPC message (one line) to the server.
+*/
+func (c *mcpClient) send(message jsonRPCMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+/*
+readMessages reads the JSON-RPC messages of the server: responses are passed to the waiting requests, the
+requests 'ping' is answered, other requests are rejected and notifications are ignored.
+*/
+func (c *mcpClient) readMessages(stdout io.Reader) {
+	defer close(c.done)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), mcpMessageMaxSize)
+	for scanner.Scan() {
+		var message jsonRPCMessage
+		if json.Unmarshal(scanner.Bytes(), &message) != nil {
+			continue // not a JSON-RPC message (e.g. log output)
+		}
+		switch {
+		case message.Method != "" && message.ID != nil:
+			response := jsonRPCMessage{JSONRPC: "2.0", ID: message.ID}
+			if message.Method == "ping" {
+				response.Result = json.RawMessage("{}")
+			} else {
+				response.Error = &jsonRPCError{Code: -32601, Message: "method not found"}
+			}
+			_ = c.send(response)
+		case message.Method != "":
+			// notification (e.g. logging, progress)
+		default:
+			var id int64
+			if json.Unmarshal(message.ID, &id) != nil {
+				continue
+			}
+			c.mutex.Lock()
+			responseChannel, ok := c.pending[id]
+			c.mutex.Unlock()
+			if ok {
+				responseChannel <- &message
+			}
+		}
+	}
+}
+
+/*
+stop terminates the server (stdin closed, process killed if still running).
+*/
+func (c *mcpClient) stop() {
+	_ = c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+	}
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	_ = c.cmd.Wait()
+}
+
+/*
+stopMCPServers terminates all started MCP servers.
+*/
+func stopMCPServers() {
+	for _, client := range mcpClients {
+		client.stop()
+	}
+}
+
+/*
+mcpFunctionName returns the function name of an MCP tool offered to Gemini ('server.tool', invalid characters
+replaced, max. 64 characters).
+*/
+func mcpFunctionName(server string, tool string) string {
+	name := []rune(server + "." + tool)
+	for i, r := range name {
+		if !(r == '_' || r == '.' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name[:min(len(name), 64)])
+}
+
+/*
+mcpFunctionDeclarations returns the declarations of the MCP tools (input schema passed as JSON schema).
+*/
+func mcpFunctionDeclarations() []*genai.FunctionDeclaration {
+	declarations := []*genai.FunctionDeclaration{}
+	for _, function := range mcpFunctions {
+		declaration := &genai.FunctionDeclaration{
+			Name:        function.name,
+			Description: function.tool.Description,
+		}
+		if len(function.tool.InputSchema) > 0 {
+			schema := map[string]any{}
+			for key, value := range function.tool.InputSchema {
+				if key != "$schema" {
+					schema[key] = value
+				}
+			}
+			declaration.ParametersJsonSchema = schema
+		}
+		declarations = append(declarations, declaration)
+	}
+	return declarations
+}
+
+/*
+findMCPFunction returns the MCP tool offered as function with the given name (nil if unknown or not enabled).
+*/
+func findMCPFunction(name string) *mcpFunction {
+	if !progConfig.MCPTools {
+		return nil
+	}
+	for i := range mcpFunctions {
+		if mcpFunctions[i].name == name {
+			return &mcpFunctions[i]
+		}
+	}
+	return nil
+}
+
+/*
+mcpServerSummaries returns a summary per started MCP server with the tools offered to Gemini, e.g. 'jira (search,
+get_issue)'. Tools skipped at start (not allowed, duplicate function name) are not listed.
+*/
+func mcpServerSummaries() []string {
+	summaries := []string{}
+	for _, server := range progConfig.MCPServers {
+		if _, ok := mcpClients[server.Name]; !ok {
+			continue
+		}
+		names := []string{}
+		for _, function := range mcpFunctions {
+			if function.client.config.Name == server.Name {
+				names = append(names, function.tool.Name)
+			}
+		}
+		summaries = append(summaries, fmt.Sprintf("%s (%s)", server.Name, strings.Join(names, ", ")))
+	}
+	return summaries
+}
+
+/*
+mcpToolResultValue converts an MCP tool result into the result value sent to the model: structured content
+if available, otherwise the text of all content items (other content types are described).
+*/
+func mcpToolResultValue(result *mcpToolResult) (any, error) {
+	var texts []string
+	for _, content := range result.Content {
+		switch {
+		case content.Type == "text":
+			texts = append(texts, content.Text)
+		case content.Type == "resource" && content.Resource != nil && content.Resource.Text != "":
+			texts = append(texts, content.Resource.Text)
+		case content.Type == "resource" && content.Resource != nil:
+			texts = append(texts, fmt.Sprintf("[resource: %s]", content.Resource.URI))
+		default:
+			texts = append(texts, fmt.Sprintf("[%s content: %s]", content.Type, content.MIMEType))
+		}
+	}
+	text := truncateFunctionOutput([]byte(strings.Join(texts, "\n")))
+	if result.IsError {
+		return nil, fmt.Errorf("%s", text)
+	}
+	if result.StructuredContent != nil {
+		return result.StructuredContent, nil
+	}
+	return text, nil
+}
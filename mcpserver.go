@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+// mcpServerSearchResultsMax is the maximum number of history entries returned by 'search_history'
+const mcpServerSearchResultsMax = 50
+
+// mcpServerSnippetLength is the length of the text snippet of a history entry found by 'search_history' (runes)
+const mcpServerSnippetLength = 240
+
+// mcpProtocolOutput is the original standard output, reserved for JSON-RPC messages in MCP server mode
+// (all other output of the program is redirected to standard error)
+var mcpProtocolOutput *os.File
+
+// mcpServer is the state of gem-pro running as MCP server (prompts are processed by the main loop)
+type mcpServer struct {
+	client        *genai.Client
+	promptChannel chan PromptRequest
+	writeMutex    sync.Mutex
+
+	// running tool calls (by request ID), cancelled by 'notifications/cancelled'
+	callsMutex   sync.Mutex
+	runningCalls map[string]context.CancelFunc
+	callsGroup   sync.WaitGroup
+}
+
+// mcpServerTool is a tool offered by gem-pro in MCP server mode
+type mcpServerTool struct {
+	tool mcpTool
+	call func(server *mcpServer, ctx context.Context, args map[string]any) (*mcpToolResult, error)
+}
+
+// mcpServerTools are the tools offered by gem-pro in MCP server mode (in order of 'tools/list')
+var mcpServerTools = []mcpServerTool{
+	{
+		tool: mcpTool{
+			Name: "ask_gemini",
+			Description: "Sends a prompt to Gemini and returns the response (Markdown). Optionally with local files, " +
+				"another model (alias or name) and grounding tools.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt": map[string]any{"type": "string", "description": "Prompt sent to Gemini."},
+					"model":  map[string]any{"type": "string", "description": "Model alias (lite, flash, pro, default) or model name."},
+					"tools": map[string]any{"type": "array", "items": map[string]any{"type": "string"},
+						"description": "Grounding tools replacing the configured tools (google-search, url-context, code-execution, google-maps)."},
+					"files": map[string]any{"type": "array", "items": map[string]any{"type": "string"},
+						"description": "Paths of local files sent with the prompt."},
+				},
+				"required": []string{"prompt"},
+			},
+		},
+		call: (*mcpServer).askGemini,
+	},
+	{
+		tool: mcpTool{
+			Name:        "list_file_search_stores",
+			Description: "Lists all FileSearchStores (name, display name, documents, size).",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+		call: (*mcpServer).listFileSearchStores,
+	},
+	{
+		tool: mcpTool{
+			Name:        "query_store",
+			Description: "Sends a prompt to Gemini grounded with the documents of the given FileSearchStores.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt": map[string]any{"type": "string", "description": "Question about the documents of the stores."},
+					"stores": map[string]any{"type": "array", "items": map[string]any{"type": "string"},
+						"description": "FileSearchStores (Name/ID), see 'list_file_search_stores'."},
+					"model": map[string]any{"type": "string", "description": "Model alias (lite, flash, pro, default) or model name."},
+				},
+				"required": []string{"prompt", "stores"},
+			},
+		},
+		call: (*mcpServer).queryStore,
+	},
+	{
+		tool: mcpTool{
+			Name:        "search_history",
+			Description: "Searches the Markdown history of previous prompts and responses (all terms, case-insensitive, newest first).",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":       map[string]any{"type": "string", "description": "Search terms separated by spaces."},
+					"max_results": map[string]any{"type": "integer", "description": fmt.Sprintf("Maximum number of results (default 10, max %d).", mcpServerSearchResultsMax)},
+				},
+				"required": []string{"query"},
+			},
+		},
+		call: (*mcpServer).searchHistory,
+	},
+	{
+		tool: mcpTool{
+			Name:        "list_models",
+			Description: "Lists the available Gemini models (name, display name, token limits, thinking).",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+		call: (*mcpServer).listModels,
+	},
+}
+
+/*
+redirectOutputForMCPServer reserves the standard output for JSON-RPC messages in MCP server mode. All other
+output (messages, terminal rendering) is written to standard error.
+*/
+func redirectOutputForMCPServer() {
+	mcpProtocolOutput = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+/*
+configureMCPServerMode adapts the configuration to MCP server mode: no chat mode (every tool call is an independent
+prompt) and no applications opened for Markdown or HTML output.
+*/
+func configureMCPServerMode() {
+	*chatmode = false
+	progConfig.MarkdownOutput = false
+	progConfig.HTMLOutput = false
+}
+
+/*
+serveMCP speaks the Model Context Protocol (JSON-RPC, one message per line) on standard input / output. Tool calls
+run in the background (prompts are passed to the main loop), so that 'ping' and cancellations are answered while a
+call is running. The program terminates at the end of the input (running tool calls are cancelled).
+*/
+func serveMCP(ctx context.Context, client *genai.Client, promptChannel chan PromptRequest) {
+	server := &mcpServer{client: client, promptChannel: promptChannel, runningCalls: map[string]context.CancelFunc{}}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), mcpMessageMaxSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		message := jsonRPCMessage{}
+		err := json.Unmarshal([]byte(line), &message)
+		if err != nil {
+			server.send(jsonRPCMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonRPCError{Code: -32700, Message: "parse error"}})
+			continue
+		}
+		if len(message.ID) == 0 {
+			// notifications need no answer (e.g. 'notifications/initialized')
+			if message.Method == "notifications/cancelled" {
+				server.cancelCall(message.Params)
+			}
+			continue
+		}
+		if message.Method == "tools/call" {
+			server.startCall(ctx, message)
+			continue
+		}
+		server.send(server.handleRequest(ctx, message))
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("error [%v] reading MCP messages\n", err)
+	}
+
+	server.callsMutex.Lock()
+	for _, cancel := range server.runningCalls {
+		cancel()
+	}
+	server.callsMutex.Unlock()
+	server.callsGroup.Wait()
+
+	stopMCPServers()
+	os.Exit(0)
+}
+
+/*
+startCall runs a tool call in the background. The call is registered by its request ID, so that the client can
+cancel it; a cancelled call is not answered (as required by the protocol).
+*/
+func (server *mcpServer) startCall(ctx context.Context, message jsonRPCMessage) {
+	callCtx, cancel := context.WithCancel(ctx)
+	id := mcpRequestKey(message.ID)
+	server.callsMutex.Lock()
+	server.runningCalls[id] = cancel
+	server.callsMutex.Unlock()
+
+	server.callsGroup.Add(1)
+	go func() {
+		defer server.callsGroup.Done()
+		response := server.handleRequest(callCtx, message)
+		server.callsMutex.Lock()
+		delete(server.runningCalls, id)
+		server.callsMutex.Unlock()
+		if callCtx.Err() != nil {
+			fmt.Printf("MCP tool call %s cancelled\n", id)
+			return
+		}
+		cancel()
+		server.send(response)
+	}()
+}
+
+/*
+cancelCall cancels a running tool call ('notifications/cancelled'). Unknown or finished requests are ignored.
+*/
+func (server *mcpServer) cancelCall(params json.RawMessage) {
+	cancellation := struct {
+		RequestID json.RawMessage `json:"requestId"`
+		Reason    string          `json:"reason"`
+	}{}
+	if json.Unmarshal(params, &cancellation) != nil || len(cancellation.RequestID) == 0 {
+		return
+	}
+	server.callsMutex.Lock()
+	cancel, found := server.runningCalls[mcpRequestKey(cancellation.RequestID)]
+	server.callsMutex.Unlock()
+	if found {
+		fmt.Printf("MCP client cancels request %s (%s)\n", mcpRequestKey(cancellation.RequestID), cancellation.Reason)
+		cancel()
+	}
+}
+
+/*
+mcpRequestKey returns the compact JSON form of a request ID (number or string) as key of the running calls.
+*/
+func mcpRequestKey(id json.RawMessage) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, id) != nil {
+		return string(id)
+	}
+	return compact.String()
+}
+
+/*
+handleRequest processes a JSON-RPC request of the MCP client and returns the response.
+*/
+func (server *mcpServer) handleRequest(ctx context.Context, message jsonRPCMessage) jsonRPCMessage {
+	var result any
+	var rpcErr *jsonRPCError
+
+	switch message.Method {
+	case "initialize":
+		result = map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": progName, "version": progVersion},
+			"instructions":    "Tools of " + progName + ": " + progPurpose + ".",
+		}
+	case "ping":
+		result = map[string]any{}
+	case "tools/list":
+		tools := []mcpTool{}
+		for _, serverTool := range mcpServerTools {
+			tools = append(tools, serverTool.tool)
+		}
+		result = map[string]any{"tools": tools}
+	case "tools/call":
+		params := struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}{}
+		err := json.Unmarshal(message.Params, &params)
+		if err != nil {
+			rpcErr = &jsonRPCError{Code: -32602, Message: fmt.Sprintf("invalid params: %v", err)}
+			break
+		}
+		index := slices.IndexFunc(mcpServerTools, func(serverTool mcpServerTool) bool { return serverTool.tool.Name == params.Name })
+		if index < 0 {
+			rpcErr = &jsonRPCError{Code: -32602, Message: fmt.Sprintf("unknown tool [%s]", params.Name)}
+			break
+		}
+		fmt.Printf("MCP client calls tool '%s' ...\n", params.Name)
+		toolResult, err := mcpServerTools[index].call(server, ctx, params.Arguments)
+		if err != nil {
+			// tool errors are reported to the model as result (not as protocol error)
+			toolResult = &mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+		}
+		result = toolResult
+	default:
+		rpcErr = &jsonRPCError{Code: -32601, Message: fmt.Sprintf("method not found [%s]", message.Method)}
+	}
+
+	response := jsonRPCMessage{JSONRPC: "2.0", ID: message.ID, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			response.Error = &jsonRPCError{Code: -32603, Message: fmt.Sprintf("internal error: %v", err)}
+		} else {
+			response.Result = data
+		}
+	}
+	return response
+}
+
+/*
+send writes a JSON-RPC message (one line) to the MCP client.
+*/
+func (server *mcpServer) send(message jsonRPCMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		fmt.Printf("error [%v] at json.Marshal()\n", err)
+		return
+	}
+	server.writeMutex.Lock()
+	defer server.writeMutex.Unlock()
+	_, err = mcpProtocolOutput.Write(append(data, '\n'))
+	if err != nil {
+		fmt.Printf("error [%v] writing MCP message\n", err)
+	}
+}
+
+/*
+askGemini implements the tool 'ask_gemini': the prompt (with model, tools and files) is processed by the main loop.
+*/
+func (server *mcpServer) askGemini(ctx context.Context, args map[string]any) (*mcpToolResult, error) {
+	request := PromptRequest{
+		Prompt: stringArgument(args, "prompt"),
+		Model:  stringArgument(args, "model"),
+		Tools:  stringListArgument(args, "tools"),
+		Files:  stringListArgument(args, "files"),
+	}
+	return server.prompt(ctx, request)
+}
+
+/*
+queryStore implements the tool 'query_store': the prompt is grounded with the given FileSearchStores (replacing
+the stores included via command line).
+*/
+func (server *mcpServer) queryStore(ctx context.Context, args map[string]any) (*mcpToolResult, error) {
+	request := PromptRequest{
+		Prompt: stringArgument(args, "prompt"),
+		Model:  stringArgument(args, "model"),
+		Stores: stringListArgument(args, "stores"),
+	}
+	if len(request.Stores) == 0 {
+		return nil, errors.New("no FileSearchStores given")
+	}
+	return server.prompt(ctx, request)
+}
+
+/*
+prompt passes a prompt request to the main loop and returns the response text (without slug) as tool result.
+Model, slug, token usage and history files are returned as structured content. Cancelling the tool call
+cancels the request.
+*/
+func (server *mcpServer) prompt(ctx context.Context, request PromptRequest) (*mcpToolResult, error) {
+	if strings.TrimSpace(request.Prompt) == "" {
+		return nil, errors.New("empty prompt")
+	}
+	request.Context = ctx
+	request.Reply = make(chan PromptResult, 1)
+	select {
+	case server.promptChannel <- request:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var result PromptResult
+	select {
+	case result = <-request.Reply:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+
+	text := ""
+	if result.response != nil && len(result.response.Candidates) > 0 {
+		text, _ = extractAndCleanSlug(getCandidateText(result.response.Candidates[0], false))
+	}
+	return &mcpToolResult{
+		Content: []mcpContent{{Type: "text", Text: strings.TrimSpace(text)}},
+		StructuredContent: map[string]any{
+			"model":   strings.TrimPrefix(result.Model, "models/"),
+			"slug":    result.Slug,
+			"usage":   result.Usage,
+			"history": result.History,
+		},
+	}, nil
+}
+
+/*
+listFileSearchStores implements the tool 'list_file_search_stores'.
+*/
+func (server *mcpServer) listFileSearchStores(ctx context.Context, args map[string]any) (*mcpToolResult, error) {
+	if isVertexBackend() {
+		return nil, errors.New("FileSearchStores not available with Vertex AI backend")
+	}
+	stores, err := fileSearchStores(ctx, server.client)
+	if err != nil {
+		return nil, fmt.Errorf("error [%w] retrieving FileSearchStores", err)
+	}
+	return mcpJSONToolResult(map[string]any{"stores": stores})
+}
+
+/*
+listModels implements the tool 'list_models'.
+*/
+func (server *mcpServer) listModels(ctx context.Context, args map[string]any) (*mcpToolResult, error) {
+	models := []map[string]any{}
+	for model, err := range server.client.Models.All(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("error [%w] listing models", err)
+		}
+		models = append(models, map[string]any{
+			"name":             model.Name,
+			"displayName":      model.DisplayName,
+			"inputTokenLimit":  model.InputTokenLimit,
+			"outputTokenLimit": model.OutputTokenLimit,
+			"thinking":         model.Thinking,
+		})
+	}
+	return mcpJSONToolResult(map[string]any{"models": models})
+}
+
+/*
+searchHistory implements the tool 'search_history': the Markdown history files are searched newest first, a file
+matches if it contains all search terms (case-insensitive).
+*/
+func (server *mcpServer) searchHistory(ctx context.Context, args map[string]any) (*mcpToolResult, error) {
+	terms := strings.Fields(strings.ToLower(stringArgument(args, "query")))
+	if len(terms) == 0 {
+		return nil, errors.New("empty query")
+	}
+	maxResults := intArgument(args, "max_results")
+	if maxResults <= 0 {
+		maxResults = 10
+	}
+	maxResults = min(maxResults, mcpServerSearchResultsMax)
+
+	entries, err := os.ReadDir(progConfig.MarkdownHistoryDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("error [%w] reading Markdown history directory", err)
+	}
+	filenames := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".md") {
+			filenames = append(filenames, entry.Name())
+		}
+	}
+	// filenames start with a timestamp (yyyymmdd-hhmmss)
+	slices.Sort(filenames)
+	slices.Reverse(filenames)
+
+	results := []map[string]any{}
+	for _, filename := range filenames {
+		if len(results) >= maxResults || ctx.Err() != nil {
+			break
+		}
+		path := filepath.Join(progConfig.MarkdownHistoryDirectory, filename)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("error [%v] at os.ReadFile()\n", err)
+			continue
+		}
+		content := string(data)
+		lowerContent := strings.ToLower(content)
+		if !containsAllTerms(lowerContent, terms) {
+			continue
+		}
+
+		result := map[string]any{
+			"file":    path,
+			"slug":    strings.TrimSuffix(filename, ".md"),
+			"snippet": historySnippet(content, lowerContent, terms[0]),
+		}
+		if len(filename) > 16 {
+			timestamp, err := time.ParseInLocation("20060102-150405", filename[:15], time.Local)
+			if err == nil {
+				result["date"] = timestamp.Format(time.RFC3339)
+				result["slug"] = strings.TrimSuffix(filename[16:], ".md")
+			}
+		}
+		results = append(results, result)
+	}
+	return mcpJSONToolResult(map[string]any{"results": results})
+}
+
+/*
+containsAllTerms reports whether the (lowercase) text contains all (lowercase) search terms.
+*/
+func containsAllTerms(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+historySnippet returns the text around the first occurrence of the search term (whitespace collapsed).
+*/
+func historySnippet(content string, lowerContent string, term string) string {
+	start := 0
+	if index := strings.Index(lowerContent, term); index >= 0 && len(lowerContent) == len(content) {
+		start = max(index-mcpServerSnippetLength/3, 0)
+		for start > 0 && !utf8.RuneStart(content[start]) {
+			start--
+		}
+	}
+	runes := []rune(strings.Join(strings.Fields(content[start:]), " "))
+	if len(runes) > mcpServerSnippetLength {
+		return string(runes[:mcpServerSnippetLength]) + " ..."
+	}
+	return string(runes)
+}
+
+/*
+mcpJSONToolResult returns the value as tool result: JSON text content and structured content.
+*/
+func mcpJSONToolResult(value map[string]any) (*mcpToolResult, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(data)}}, StructuredContent: value}, nil
+}
+
+/*
+stringListArgument returns a string array argument of a tool call (nil if missing).
+*/
+func stringListArgument(args map[string]any, name string) []string {
+	values, ok := args[name].([]any)
+	if !ok {
+		return nil
+	}
+	list := []string{}
+	for _, value := range values {
+		if text, ok := value.(string); ok && strings.TrimSpace(text) != "" {
+			list = append(list, text)
+		}
+	}
+	return list
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// metadataSlugPrefix is the start of the metadata line, which is not passed to OpenAI clients
+const metadataSlugPrefix = "METADATA_SLUG:"
+
+// openAIChatRequest represents the (supported subset of an) OpenAI chat completion request
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+// openAIMessage represents an OpenAI chat message, content is either a string or a list of parts
+type openAIMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// openAIContentPart represents a part of a multi-part OpenAI message content
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// openAIChatResponse represents an OpenAI chat completion (or chat completion chunk)
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// openAIChoice represents a choice of a completion (message) or a chunk (delta)
+type openAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *openAIResponseMessage `json:"message,omitempty"`
+	Delta        *openAIResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+// openAIResponseMessage represents the assistant message of a choice
+type openAIResponseMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// openAIUsage represents the token usage in OpenAI notation
+type openAIUsage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CompletionTokens int32 `json:"completion_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+// slugStreamFilter removes the metadata slug line from streamed text
+type slugStreamFilter struct {
+	pending string
+	midLine bool
+}
+
+/*
+handleOpenAIChatCompletions creates the HTTP handler of the OpenAI-compatible endpoint ('POST /v1/chat/completions').
+The messages are mapped to Gemini contents and processed statelessly (the caller manages the conversation) with the
+configured model, tools and system instruction. History files are written as for all other prompts. Streaming
+('stream: true') is delivered as server-sent events.
+*/
+func handleOpenAIChatCompletions(promptChannel chan PromptRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+			return
+		}
+		defer func() { _ = r.Body.Close() }()
+
+		var chatRequest openAIChatRequest
+		err := json.NewDecoder(r.Body).Decode(&chatRequest)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON request: %v", err))
+			return
+		}
+
+		contents, systemInstruction, prompt, err := convertOpenAIMessages(chatRequest.Messages)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		request := PromptRequest{
+			Prompt:            prompt,
+			Model:             openAIModelOverride(chatRequest.Model),
+			Contents:          contents,
+			SystemInstruction: systemInstruction,
+			Reply:             make(chan PromptResult, 1), // buffered, the main loop must never block on a vanished caller
+		}
+
+		id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+		created := time.Now().Unix()
+
+		// streaming: text deltas are passed from the main loop to this handler
+		var deltas chan string
+		var flusher http.Flusher
+		if chatRequest.Stream {
+			var ok bool
+			flusher, ok = w.(http.Flusher)
+			if !ok {
+				writeOpenAIError(w, http.StatusInternalServerError, "streaming not supported")
+				return
+			}
+			deltas = make(chan string)
+			ctx := r.Context()
+			request.StreamText = func(text string) {
+				select {
+				case deltas <- text:
+				case <-ctx.Done():
+				}
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+			flusher.Flush()
+		}
+
+		select {
+		case promptChannel <- request:
+		case <-r.Context().Done():
+			return
+		}
+
+		var result PromptResult
+		filter := &slugStreamFilter{}
+		role := "assistant"
+	waitLoop:
+		for {
+			select {
+			case delta := <-deltas:
+				text := filter.write(delta)
+				if text == "" {
+					continue
+				}
+				writeOpenAIChunk(w, flusher, openAIChatResponse{
+					ID: id, Object: "chat.completion.chunk", Created: created, Model: strings.TrimPrefix(chatRequest.Model, "models/"),
+					Choices: []openAIChoice{{Delta: &openAIResponseMessage{Role: role, Content: text}}},
+				})
+				role = ""
+			case result = <-request.Reply:
+				break waitLoop
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		model := strings.TrimPrefix(result.Model, "models/")
+		if !chatRequest.Stream {
+			if result.Error != "" {
+				statusCode := result.statusCode
+				if statusCode == 0 {
+					statusCode = http.StatusBadGateway
+				}
+				writeOpenAIError(w, statusCode, result.Error)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_ = json.NewEncoder(w).Encode(buildOpenAIChatResponse(result.response, id, created, model))
+			return
+		}
+
+		// streaming: remaining text, final chunk (finish reason, usage) and end marker
+		if result.Error != "" {
+			writeOpenAIChunk(w, flusher, map[string]any{"error": map[string]string{"message": result.Error, "type": "api_error"}})
+		} else {
+			final := buildOpenAIChatResponse(result.response, id, created, model)
+			final.Object = "chat.completion.chunk"
+			finishReason := "stop"
+			if len(final.Choices) > 0 && final.Choices[0].FinishReason != nil {
+				finishReason = *final.Choices[0].FinishReason
+			}
+			final.Choices = []openAIChoice{{Delta: &openAIResponseMessage{Role: role, Content: filter.flush()}, FinishReason: &finishReason}}
+			writeOpenAIChunk(w, flusher, final)
+		}
+		_, _ = fmt.Fprintf(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}
+}
+
+/*
+handleOpenAIModels creates the HTTP handler of the OpenAI-compatible model list ('GET /v1/models').
+It lists the configured models, which can be used as 'model' in chat completion requests.
+*/
+func handleOpenAIModels(config ProgConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+			return
+		}
+
+		type openAIModel struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			OwnedBy string `json:"owned_by"`
+		}
+		models := []openAIModel{}
+		seen := map[string]bool{}
+		for _, name := range []string{config.GeminiAiModel, config.GeminiLiteAiModel, config.GeminiFlashAiModel,
+			config.GeminiProAiModel, config.GeminiFlashImageAiModel, config.GeminiProImageAiModel} {
+			id := strings.TrimPrefix(name, "models/")
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			models = append(models, openAIModel{ID: id, Object: "model", OwnedBy: "google"})
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": models})
+	}
+}
+
+/*
+convertOpenAIMessages maps OpenAI chat messages to Gemini contents. System (and developer) messages are
+collected as additional system instruction. The last message must be a user message, its text is returned
+as prompt (for the prompt/response files).
+*/
+func convertOpenAIMessages(messages []openAIMessage) ([]*genai.Content, string, string, error) {
+	contents := []*genai.Content{}
+	systemInstructions := []string{}
+	prompt := ""
+
+	for i, message := range messages {
+		parts, text, err := convertOpenAIContent(message.Content)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("message #%d: %w", i+1, err)
+		}
+
+		switch message.Role {
+		case "system", "developer":
+			systemInstructions = append(systemInstructions, text)
+			continue
+		case "user":
+			contents = append(contents, genai.NewContentFromParts(parts, genai.RoleUser))
+			prompt = text
+		case "assistant":
+			contents = append(contents, genai.NewContentFromParts(parts, genai.RoleModel))
+		default:
+			return nil, "", "", fmt.Errorf("message #%d: unsupported role [%s]", i+1, message.Role)
+		}
+	}
+
+	if len(contents) == 0 || contents[len(contents)-1].Role != genai.RoleUser {
+		return nil, "", "", errors.New("last message must be a user message")
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return nil, "", "", errors.New("prompt empty")
+	}
+
+	return contents, strings.Join(systemInstructions, "\n\n"), strings.TrimSpace(prompt), nil
+}
+
+/*
+convertOpenAIContent converts the content of an OpenAI message (string or list of parts) into Gemini parts.
+Images are supported as data URLs (base64). The text of all text parts is returned additionally.
+*/
+func convertOpenAIContent(raw json.RawMessage) ([]*genai.Part, string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []*genai.Part{genai.NewPartFromText(text)}, text, nil
+	}
+
+	var contentParts []openAIContentPart
+	if err := json.Unmarshal(raw, &contentParts); err != nil {
+		return nil, "", fmt.Errorf("unsupported message content: %w", err)
+	}
+
+	parts := []*genai.Part{}
+	texts := []string{}
+	for _, contentPart := range contentParts {
+		switch contentPart.Type {
+		case "text":
+			parts = append(parts, genai.NewPartFromText(contentPart.Text))
+			texts = append(texts, contentPart.Text)
+		case "image_url":
+			if contentPart.ImageURL == nil {
+				return nil, "", errors.New("image_url without url")
+			}
+			mimeType, data, err := decodeDataURL(contentPart.ImageURL.URL)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, genai.NewPartFromBytes(data, mimeType))
+		default:
+			return nil, "", fmt.Errorf("unsupported content part type [%s]", contentPart.Type)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, "", errors.New("message content empty")
+	}
+
+	return parts, strings.Join(texts, "\n"), nil
+}
+
+/*
+decodeDataURL decodes a base64 data URL (e.g. 'data:image/png;base64,...') into MIME type and data.
+*/
+func decodeDataURL(dataURL string) (string, []byte, error) {
+	header, encoded, found := strings.Cut(dataURL, ",")
+	if !found || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return "", nil, errors.New("only base64 data URLs are supported for images")
+	}
+	mimeType := strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("error [%w] decoding data URL", err)
+	}
+	return mimeType, data, nil
+}
+
+/*
+openAIModelOverride returns the requested model if it is a Gemini model or a model alias (e.g. 'flash').
+Other model names (e.g. defaults of OpenAI clients) are ignored, the configured model is used instead.
+*/
+func openAIModelOverride(model string) string {
+	switch strings.ToLower(model) {
+	case "lite", "flash", "pro", "flash-image", "pro-image", "default":
+		return model
+	}
+	if strings.HasPrefix(model, "gemini") || strings.HasPrefix(model, "models/") {
+		return model
+	}
+	return ""
+}
+
+/*
+buildOpenAIChatResponse builds an OpenAI chat completion from a Gemini response. Each candidate becomes
+a choice, thoughts and the metadata slug line are not part of the message content.
+*/
+func buildOpenAIChatResponse(resp *genai.GenerateContentResponse, id string, created int64, model string) openAIChatResponse {
+	chatResponse := openAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []openAIChoice{},
+	}
+	if resp == nil {
+		return chatResponse
+	}
+
+	for i, candidate := range resp.Candidates {
+		var text strings.Builder
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if !part.Thought && part.Text != "" {
+					text.WriteString(part.Text)
+				}
+			}
+		}
+		content, _ := extractAndCleanSlug(text.String())
+
+		finishReason := "stop"
+		switch candidate.FinishReason {
+		case genai.FinishReasonMaxTokens:
+			finishReason = "length"
+		case genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonBlocklist,
+			genai.FinishReasonProhibitedContent, genai.FinishReasonSPII:
+			finishReason = "content_filter"
+		}
+
+		chatResponse.Choices = append(chatResponse.Choices, openAIChoice{
+			Index:        i,
+			Message:      &openAIResponseMessage{Role: "assistant", Content: strings.TrimSpace(content)},
+			FinishReason: &finishReason,
+		})
+	}
+
+	if resp.UsageMetadata != nil {
+		completionTokens := resp.UsageMetadata.CandidatesTokenCount + resp.UsageMetadata.ThoughtsTokenCount
+		chatResponse.Usage = &openAIUsage{
+			PromptTokens:     resp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: completionTokens,
+			TotalTokens:      resp.UsageMetadata.TotalTokenCount,
+		}
+	}
+
+	return chatResponse
+}
+
+/*
+writeOpenAIChunk writes an object as server-sent event and flushes it to the client.
+*/
+func writeOpenAIChunk(w http.ResponseWriter, flusher http.Flusher, object any) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		fmt.Printf("error [%v] at json.Marshal()\n", err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+	flusher.Flush()
+}
+
+/*
+writeOpenAIError writes an error message in OpenAI notation with the given HTTP status code.
+*/
+func writeOpenAIError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message, "type": "invalid_request_error"},
+	})
+}
+
+/*
+write passes streamed text through and holds back a trailing incomplete line as long as it may
+become the metadata slug line. Complete slug lines are removed.
+*/
+func (filter *slugStreamFilter) write(delta string) string {
+	filter.pending += delta
+	var out strings.Builder
+	for filter.pending != "" {
+		lineEnd := strings.IndexByte(filter.pending, '\n')
+		if filter.midLine {
+			// rest of a line, whose start has already been passed through
+			if lineEnd < 0 {
+				out.WriteString(filter.pending)
+				filter.pending = ""
+				break
+			}
+			out.WriteString(filter.pending[:lineEnd+1])
+			filter.pending = filter.pending[lineEnd+1:]
+			filter.midLine = false
+			continue
+		}
+		if lineEnd >= 0 {
+			line := filter.pending[:lineEnd+1]
+			filter.pending = filter.pending[lineEnd+1:]
+			if !metadataSlugRegex.MatchString(line) {
+				out.WriteString(line)
+			}
+			continue
+		}
+		if strings.HasPrefix(filter.pending, metadataSlugPrefix) || strings.HasPrefix(metadataSlugPrefix, filter.pending) {
+			break
+		}
+		out.WriteString(filter.pending)
+		filter.pending = ""
+		filter.midLine = true
+	}
+	return out.String()
+}
+
+/*
+flush returns the held back text at the end of the stream (unless it is the metadata slug line).
+*/
+func (filter *slugStreamFilter) flush() string {
+	rest := filter.pending
+	filter.pending = ""
+	if metadataSlugRegex.MatchString(rest) {
+		return ""
+	}
+	return rest
+}
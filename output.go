@@ -197,11 +197,8 @@ func getCandidateText(candidate *genai.Candidate, includeThoughts bool) string {
 		if part.FileData != nil {
 			regularContent.WriteString(fmt.Sprintf("File Data: URI=%s, MIME=%s\n", part.FileData.FileURI, part.FileData.MIMEType))
 		}
-		if part.FunctionCall != nil {
-			regularContent.WriteString("A predicted [FunctionCall] returned from the model.\n")
-		}
-		if part.FunctionResponse != nil {
-			regularContent.WriteString("The result output of a [FunctionCall].\n")
+		if part.FunctionCall != nil || part.FunctionResponse != nil {
+			regularContent.WriteString(renderFunctionPart(part))
 		}
 		if part.InlineData != nil {
 			regularContent.WriteString(fmt.Sprintf("Inline data (%.1f KiB, %s) : ", float64(len(part.InlineData.Data))/1024.0, part.InlineData.MIMEType))
@@ -217,7 +214,11 @@ func getCandidateText(candidate *genai.Candidate, includeThoughts bool) string {
 				regularContent.WriteString(fmt.Sprintf("\n![%s](%s)\n\n", filename, encodedURL))
 			}
 		}
-		if part.Text != "" { // ensure that part.Text is not from Thought
+		if part.Text != "" && structuredOutputActive() {
+			// structured output: JSON as pretty-printed code block
+			regularContent.WriteString(renderStructuredText(part.Text))
+			regularContent.WriteString("\n")
+		} else if part.Text != "" { // ensure that part.Text is not from Thought
 			regularContent.WriteString(removeSpacesBetweenNewlineAndCodeblock(part.Text))
 			regularContent.WriteString("\n")
 		}
@@ -277,9 +278,20 @@ func processResponse(resp *genai.GenerateContentResponse) {
 			responseString.WriteString("**Response from Gemini:**\n\n")
 		}
 
+		// function calls and results of the request (executed before the final response)
+		if i == 0 {
+			responseString.WriteString(renderFunctionCalls())
+		}
+
 		// Get text content, including thoughts based on config (Thoughts are part of the 'text' logic in getCandidateText)
 		// Note: progConfig.GeminiIncludeThoughts ensures we receive them from API, passing 'true' here formats them.
-		responseString.WriteString(getCandidateText(candidate, true))
+		// Grounding supports are resolved into inline citation markers (e.g. '[7][8]').
+		// Structured output (JSON) gets no citation markers.
+		if structuredOutputActive() {
+			responseString.WriteString(getCandidateText(candidate, true))
+		} else {
+			responseString.WriteString(getCandidateText(insertCitationMarkers(candidate, i+1), true))
+		}
 
 		// build list of text citation source URIs
 		citationURIs := []string{}
@@ -332,14 +344,15 @@ func processResponse(resp *genai.GenerateContentResponse) {
 				responseString.WriteString("\n***\n")
 				responseString.WriteString("**Online Search Sources Used:**\n\n")
 				// numbered list because response can contain references (e.g. [2] or [1,3,15])
+				// source marker (anchor target of inline citations) must not be at the beginning of the line
 				for k, groundingChunk := range candidate.GroundingMetadata.GroundingChunks {
 					switch {
 					case groundingChunk.Web != nil:
-						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)\n", k+1, groundingChunk.Web.Title, groundingChunk.Web.URI))
+						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)<!-- AI_SOURCE_%d_%d -->\n", k+1, groundingChunk.Web.Title, groundingChunk.Web.URI, i+1, k+1))
 					case groundingChunk.Maps != nil:
-						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)\n", k+1, groundingChunk.Maps.Title, groundingChunk.Maps.URI))
+						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)<!-- AI_SOURCE_%d_%d -->\n", k+1, groundingChunk.Maps.Title, groundingChunk.Maps.URI, i+1, k+1))
 					case groundingChunk.RetrievedContext != nil:
-						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)\n", k+1, groundingChunk.RetrievedContext.Title, groundingChunk.RetrievedContext.URI))
+						responseString.WriteString(fmt.Sprintf("%d. [%s](%s)<!-- AI_SOURCE_%d_%d -->\n", k+1, groundingChunk.RetrievedContext.Title, groundingChunk.RetrievedContext.URI, i+1, k+1))
 					}
 				}
 			}
@@ -384,6 +397,15 @@ func processResponse(resp *genai.GenerateContentResponse) {
 	if len(includeStores) > 0 {
 		activeTools = append(activeTools, "FileSearchStores")
 	}
+	if progConfig.FunctionCalling && len(progConfig.Functions) > 0 {
+		activeTools = append(activeTools, "Functions")
+	}
+	if progConfig.WorkspaceTools {
+		activeTools = append(activeTools, "Workspace")
+	}
+	if progConfig.MCPTools && len(mcpFunctions) > 0 {
+		activeTools = append(activeTools, "MCP")
+	}
 
 	if len(activeTools) > 0 {
 		responseString.WriteString(fmt.Sprintf("Tools      : %s\n", strings.Join(activeTools, ", ")))
@@ -395,6 +417,26 @@ func processResponse(resp *genai.GenerateContentResponse) {
 	responseString.WriteString(fmt.Sprintf("Processing : %.1f secs for %d %s\n", duration.Seconds(),
 		len(resp.Candidates), pluralize(len(resp.Candidates), "candidate")))
 
+	if len(retryAttempts) > 0 {
+		responseString.WriteString(fmt.Sprintf("Attempts   : %s\n", retryAttemptsSummary(true)))
+	}
+	if fallbackInfo != "" {
+		responseString.WriteString(fmt.Sprintf("Fallback   : %s\n", fallbackInfo))
+	}
+	if functionCallingInfo != "" {
+		responseString.WriteString(fmt.Sprintf("Functions  : %s\n", functionCallingInfo))
+	}
+	if continuationInfo != "" {
+		responseString.WriteString(fmt.Sprintf("Continued  : %s\n", continuationInfo))
+	}
+	if structuredOutputInfo != "" {
+		responseString.WriteString(fmt.Sprintf("Structured : %s\n", structuredOutputInfo))
+	}
+
+	if batchJobName != "" {
+		responseString.WriteString(fmt.Sprintf("Batch job  : %s (50%% batch price)\n", batchJobName))
+	}
+
 	/*
 	   Cost Calculation Logic (Corrected for SDK v0.8.0+ / Gemini 1.5+ / Gemini 3):
 	   Total Tokens = Prompt + Tools + Candidates + Thoughts
@@ -484,6 +526,12 @@ func processError(err error) {
 
 	duration := finishProcessing.Sub(startProcessing)
 	responseString.WriteString(fmt.Sprintf("Processing : %.1f secs resulting in error\n", duration.Seconds()))
+	if len(retryAttempts) > 0 {
+		responseString.WriteString(fmt.Sprintf("Attempts   : %s\n", retryAttemptsSummary(false)))
+	}
+	if fallbackInfo != "" {
+		responseString.WriteString(fmt.Sprintf("Fallback   : %s\n", fallbackInfo))
+	}
 
 	responseString.WriteString("```\n")
 	responseString.WriteString("\n***\n")
@@ -512,6 +560,7 @@ func appendResponseString(responseString strings.Builder) {
 	markdownForFileAndAnsi = strings.ReplaceAll(markdownForFileAndAnsi, "<!-- AI_THOUGHT_SUMMARY_END -->", "")
 	markdownForFileAndAnsi = strings.ReplaceAll(markdownForFileAndAnsi, "<!-- AI_THOUGHT_CONTENT_START -->", "")
 	markdownForFileAndAnsi = strings.ReplaceAll(markdownForFileAndAnsi, "<!-- AI_THOUGHT_CONTENT_END -->", "")
+	markdownForFileAndAnsi = replaceCitationMarkersForMarkdown(markdownForFileAndAnsi)
 
 	// append response string to current markdown request/response file
 	currentFileMarkdown, err := os.OpenFile(progConfig.MarkdownPromptResponseFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// tokenCountCache holds the token counts of already counted files (key = model, path, last update, size)
+var tokenCountCache = map[string]int32{}
+
+// fileTokenCount represents the token count of a file for the per-file breakdown
+type fileTokenCount struct {
+	name   string
+	tokens int32
+	err    error
+}
+
+/*
+countFileTokens counts the tokens of a local file with the given model. Counts are cached, unchanged files
+are therefore only counted once per session.
+*/
+func countFileTokens(ctx context.Context, client *genai.Client, model string, fileToHandle FileToHandle) (int32, error) {
+	key := strings.Join([]string{model, fileToHandle.Filepath, fileToHandle.LastUpdate, fileToHandle.FileSize}, "|")
+	if tokens, ok := tokenCountCache[key]; ok {
+		return tokens, nil
+	}
+
+	content, err := convertFileToContent(fileToHandle.Filepath)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Models.CountTokens(ctx, model, []*genai.Content{content}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	tokenCountCache[key] = resp.TotalTokens
+	return resp.TotalTokens, nil
+}
+
+/*
+countFileTokensBreakdown counts the tokens of each local file and (optionally) of each file uploaded to the
+Google file store.
+*/
+func countFileTokensBreakdown(ctx context.Context, client *genai.Client, model string, files []FileToHandle, uploaded bool) []fileTokenCount {
+	counts := []fileTokenCount{}
+	for _, fileToHandle := range files {
+		if fileToHandle.State == "error" {
+			continue
+		}
+		tokens, err := countFileTokens(ctx, client, model, fileToHandle)
+		counts = append(counts, fileTokenCount{name: fileToHandle.Filepath, tokens: tokens, err: err})
+	}
+
+	if uploaded && isVertexBackend() {
+		for _, uri := range progConfig.VertexFileURIs {
+			content := genai.NewContentFromURI(uri, gcsFileMimeType(uri), "user")
+			resp, err := client.Models.CountTokens(ctx, model, []*genai.Content{content}, nil)
+			count := fileTokenCount{name: uri, err: err}
+			if err == nil {
+				count.tokens = resp.TotalTokens
+			}
+			counts = append(counts, count)
+		}
+	} else if uploaded {
+		for file, err := range client.Files.All(ctx) {
+			if err != nil {
+				counts = append(counts, fileTokenCount{name: "Google file store", err: err})
+				break
+			}
+			content := genai.NewContentFromURI(file.URI, file.MIMEType, "user")
+			resp, err := client.Models.CountTokens(ctx, model, []*genai.Content{content}, nil)
+			count := fileTokenCount{name: file.DisplayName + " (" + file.Name + ")", err: err}
+			if err == nil {
+				count.tokens = resp.TotalTokens
+			}
+			counts = append(counts, count)
+		}
+	}
+	return counts
+}
+
+/*
+inputTokenLimit returns the input token limit of a model. The already fetched model information is used,
+if it belongs to the model.
+*/
+func inputTokenLimit(ctx context.Context, client *genai.Client, modelInfo *genai.Model, model string) (int32, error) {
+	if modelInfo != nil && modelInfo.Name == model {
+		return modelInfo.InputTokenLimit, nil
+	}
+	info, err := client.Models.Get(ctx, model, nil)
+	if err != nil {
+		return 0, err
+	}
+	return info.InputTokenLimit, nil
+}
+
+/*
+printTokenBreakdown prints the per-file token breakdown, the remaining tokens (prompt, history, cache)
+and the total compared with the input token limit.
+*/
+func printTokenBreakdown(counts []fileTokenCount, total int32, other string, limit int32) {
+	var filesTotal int32
+	for _, count := range counts {
+		if count.err != nil {
+			fmt.Printf("  %-50s : error [%v]\n", count.name, count.err)
+			continue
+		}
+		fmt.Printf("  %-50s : %9d\n", count.name, count.tokens)
+		filesTotal += count.tokens
+	}
+	if other != "" {
+		fmt.Printf("  %-50s : %9d\n", other, max(total-filesTotal, 0))
+	}
+	if limit > 0 {
+		fmt.Printf("  %-50s : %9d of %d input tokens (%.1f%%)\n", "Total", total, limit, float64(total)*100.0/float64(limit))
+	} else {
+		fmt.Printf("  %-50s : %9d\n", "Total", total)
+	}
+}
+
+/*
+preflightTokenCheck counts the tokens of the assembled request (chat history, files, prompt and cache)
+with Models.CountTokens before it is sent, shows a per-file breakdown and compares the total with the
+input token limit of the model. If the limit would be exceeded, a warning is printed, or an error is
+returned (PreflightAbortOnLimit). The system instruction is not counted (not supported by the Gemini API).
+*/
+func preflightTokenCheck(ctx context.Context, state *sessionState, useChat bool, contents []*genai.Content, parts []genai.Part,
+	files []FileToHandle, uploaded bool) error {
+	client := state.client
+	model := progConfig.GeminiAiModel
+
+	countContents := contents
+	if useChat {
+		countContents = chatRequestContents(state.chat, parts)
+	}
+
+	resp, err := client.Models.CountTokens(ctx, model, countContents, nil)
+	if err != nil {
+		// pre-flight is best effort, the request itself reports real problems
+		fmt.Printf("error [%v] counting tokens (pre-flight)\n", err)
+		return nil
+	}
+	total := resp.TotalTokens + cachedContentTokens(ctx, state)
+
+	limit, err := inputTokenLimit(ctx, client, state.modelInfo, model)
+	if err != nil {
+		fmt.Printf("error [%v] getting AI model information (pre-flight)\n", err)
+	}
+
+	exceeded := limit > 0 && total > limit
+	if len(files) > 0 || uploaded || exceeded || *verbose {
+		fmt.Printf("\nPre-flight token count (%s):\n", strings.TrimPrefix(model, "models/"))
+		counts := countFileTokensBreakdown(ctx, client, model, files, uploaded)
+		printTokenBreakdown(counts, total, "Prompt, chat history, cache", limit)
+		fmt.Printf("\n")
+	} else if limit > 0 {
+		fmt.Printf("Pre-flight: %d of %d input tokens (%.1f%%)\n", total, limit, float64(total)*100.0/float64(limit))
+	}
+
+	if !exceeded {
+		return nil
+	}
+	if progConfig.PreflightAbortOnLimit {
+		return fmt.Errorf("request with %d tokens exceeds input token limit (%d) of model '%s', request not sent", total, limit, model)
+	}
+	fmt.Printf("Warning: request with %d tokens exceeds input token limit (%d) of model '%s'\n", total, limit, model)
+	return nil
+}
+
+/*
+cachedContentTokens returns the number of tokens of the cache used by the session (state.cacheName). The count is
+retrieved once per cache (Caches.Get) and kept in the session state.
+*/
+func cachedContentTokens(ctx context.Context, state *sessionState) int32 {
+	if state.cacheName == "" {
+		return 0
+	}
+	if state.cacheTokensOf != state.cacheName {
+		cachedContent, err := state.client.Caches.Get(ctx, state.cacheName, nil)
+		if err != nil {
+			fmt.Printf("error [%v] getting cache details (pre-flight)\n", err)
+			return 0
+		}
+		state.cacheTokens = 0
+		if cachedContent.UsageMetadata != nil {
+			state.cacheTokens = cachedContent.UsageMetadata.TotalTokenCount
+		}
+		state.cacheTokensOf = state.cacheName
+	}
+	return state.cacheTokens
+}
+
+/*
+countTokensOfGivenFiles counts the tokens of the files given via command line or -filelist without
+generating anything, and compares the total with the input token limit of the model.
+*/
+func countTokensOfGivenFiles(model string) {
+	if len(filesToHandle) == 0 {
+		fmt.Printf("  nothing to do, no files given\n")
+		return
+	}
+
+	// create AI client
+	ctx := context.Background()
+	client, err := newGeminiClient(ctx)
+	if err != nil {
+		log.Fatalf("error [%v] creating Gemini AI client", err)
+	}
+
+	limit, err := inputTokenLimit(ctx, client, nil, model)
+	if err != nil {
+		log.Fatalf("error [%v] getting AI model information", err)
+	}
+
+	counts := countFileTokensBreakdown(ctx, client, model, filesToHandle, false)
+	var total int32
+	for _, count := range counts {
+		total += count.tokens
+	}
+	printTokenBreakdown(counts, total, "", limit)
+
+	for _, fileToHandle := range filesToHandle {
+		if fileToHandle.State == "error" {
+			fmt.Printf("  %-50s : %s\n", fileToHandle.Filepath, fileToHandle.ErrorMessage)
+		}
+	}
+	if limit > 0 && total > limit {
+		fmt.Printf("\n  Warning: files exceed input token limit of model '%s'\n", model)
+	}
+}
+
+/*
+handleStandaloneTokenActions handles token counting of given files.
+*/
+func handleStandaloneTokenActions() {
+	if *countTokens {
+		fmt.Printf("\nCounting tokens of given files (%s):\n", strings.TrimPrefix(progConfig.GeminiAiModel, "models/"))
+		countTokensOfGivenFiles(progConfig.GeminiAiModel)
+		fmt.Printf("\n")
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+applyProfile applies a named profile of the configuration (section 'Profiles') on top of the base configuration.
+A profile may override any configuration field (e.g. model, tools, temperature, thinking level, system
+instruction file, output targets), all other fields are inherited from the base configuration (all lower
+configuration layers). Unknown fields are rejected to catch typos.
+*/
+func applyProfile(name string) error {
+	profile, ok := progConfig.Profiles[name]
+	if !ok {
+		available := "none"
+		if len(progConfig.Profiles) > 0 {
+			available = strings.Join(profileNames(), ", ")
+		}
+		return fmt.Errorf("unknown profile [%s] (available: %s)", name, available)
+	}
+
+	err := applyConfigNode(&profile, "profile "+name)
+	if err != nil {
+		return fmt.Errorf("error [%w] decoding profile [%s]", err, name)
+	}
+
+	progConfig.ActiveProfile = name
+	return nil
+}
+
+/*
+profileNames returns the sorted names of all profiles in the configuration.
+*/
+func profileNames() []string {
+	names := []string{}
+	for name := range progConfig.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -1,47 +1,178 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
 )
 
-// ProxyRoundTripper is an implementation of http.RoundTripper that supports
-// setting a proxy server URL for genai clients. This type should be used with
-// a custom http.Client that's passed to WithHTTPClient. For such clients,
-// WithAPIKey doesn't apply so the key has to be explicitly set here.
-type ProxyRoundTripper struct {
-	APIKey   string
-	ProxyURL string
+// sharedTransport is the single HTTP transport used by all Gemini clients (connections are reused)
+var sharedTransport struct {
+	once      sync.Once
+	transport *http.Transport
+	err       error
 }
 
 /*
-RoundTrip implements the http.RoundTripper interface with proxy support for Gemini API requests. It modifies
-outgoing HTTP requests to include the API key and route them through a configured proxy URL if provided,
-handling internet proxy connections for Gemini API calls.
+newGeminiClient creates a Gemini AI client for all program parts (prompts and admin commands). All clients
+share one HTTP transport, which honours the configured internet proxy (GeneralInternetProxy) or the
+environment variables HTTPS_PROXY and NO_PROXY, a custom CA bundle and the configured timeouts. The
+backend is the Gemini Developer API (API key) or Vertex AI (GeminiBackend).
 */
-func (t *ProxyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
+func newGeminiClient(ctx context.Context) (*genai.Client, error) {
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
 
-	if t.ProxyURL != "" {
-		proxyURL, err := url.Parse(t.ProxyURL)
+	clientConfig := &genai.ClientConfig{
+		APIKey:     progConfig.GeminiAPIKey,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: httpClient,
+	}
+	if progConfig.GeneralRequestTimeout > 0 {
+		timeout := time.Duration(progConfig.GeneralRequestTimeout) * time.Second
+		clientConfig.HTTPOptions.Timeout = &timeout
+	}
+	if progConfig.GeminiBaseURL != "" {
+		clientConfig.HTTPOptions.BaseURL = progConfig.GeminiBaseURL
+	}
+	if isVertexBackend() {
+		err = configureVertexClient(ctx, clientConfig)
 		if err != nil {
-			fmt.Printf("error [%v] parsing internet proxy url\n", err)
 			return nil, err
 		}
-		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	newReq := req.Clone(req.Context())
-	query := newReq.URL.Query()
-	query.Add("key", t.APIKey)
-	newReq.URL.RawQuery = query.Encode()
+	return genai.NewClient(ctx, clientConfig)
+}
 
-	resp, err := transport.RoundTrip(newReq)
+/*
+newHTTPClient creates an HTTP client on the shared transport (proxy, CA bundle, connect timeout). It is used
+for all outgoing requests: Gemini API and HTTP endpoints of functions.
+*/
+func newHTTPClient() (*http.Client, error) {
+	transport, err := geminiTransport()
 	if err != nil {
-		fmt.Printf("error [%v] setting up 'transport round trip' for internet proxy\n", err)
 		return nil, err
 	}
+	return &http.Client{Transport: transport}, nil
+}
 
-	return resp, nil
+/*
+geminiTransport returns the shared HTTP transport, created on first use.
+*/
+func geminiTransport() (*http.Transport, error) {
+	sharedTransport.once.Do(func() {
+		sharedTransport.transport, sharedTransport.err = newGeminiTransport()
+	})
+	return sharedTransport.transport, sharedTransport.err
+}
+
+/*
+newGeminiTransport creates the HTTP transport: proxy (configuration or environment), CA bundle (added to
+the system certificate pool) and connect timeout.
+*/
+func newGeminiTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// proxy: configuration takes precedence over environment (HTTPS_PROXY, NO_PROXY)
+	transport.Proxy = http.ProxyFromEnvironment
+	if progConfig.GeneralInternetProxy != "" {
+		proxyURL, err := url.Parse(progConfig.GeneralInternetProxy)
+		if err != nil {
+			return nil, fmt.Errorf("error [%w] parsing internet proxy url", err)
+		}
+		noProxy := os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			// like ProxyFromEnvironment: local hosts (e.g. function endpoints) are never proxied
+			if isLoopbackHost(req.URL.Hostname()) || matchesNoProxy(req.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	// custom CA bundle (e.g. corporate TLS inspection)
+	if progConfig.GeneralCABundle != "" {
+		pem, err := os.ReadFile(progConfig.GeneralCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error [%w] reading CA bundle", err)
+		}
+		certPool, err := x509.SystemCertPool()
+		if err != nil || certPool == nil {
+			certPool = x509.NewCertPool()
+		}
+		if !certPool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle [%s]", progConfig.GeneralCABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}
+	}
+
+	// connect timeout
+	if progConfig.GeneralConnectTimeout > 0 {
+		timeout := time.Duration(progConfig.GeneralConnectTimeout) * time.Second
+		dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = timeout
+	}
+
+	return transport, nil
+}
+
+/*
+isLoopbackHost reports whether a host is 'localhost' or a loopback IP address.
+*/
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+/*
+matchesNoProxy reports whether a host is excluded from proxying by a NO_PROXY list (e.g. 'localhost,
+.mycorp.com, 10.1.2.3, 192.168.0.0/16'). Entries match the host itself and its subdomains (a port is
+ignored), CIDR entries match the IP addresses of the network, '*' matches all hosts.
+*/
+func matchesNoProxy(host string, noProxy string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			ip := net.ParseIP(host)
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMatchesNoProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		noProxy string
+		want    bool
+	}{
+		{name: "empty list", host: "example.com", noProxy: "", want: false},
+		{name: "exact host", host: "example.com", noProxy: "example.com", want: true},
+		{name: "case insensitive", host: "API.Example.com", noProxy: "example.COM", want: true},
+		{name: "subdomain", host: "api.example.com", noProxy: "example.com", want: true},
+		{name: "leading dot", host: "api.mycorp.com", noProxy: "localhost, .mycorp.com", want: true},
+		{name: "leading dot matches domain", host: "mycorp.com", noProxy: ".mycorp.com", want: true},
+		{name: "suffix without dot", host: "notexample.com", noProxy: "example.com", want: false},
+		{name: "other host", host: "example.org", noProxy: "example.com, 10.1.2.3", want: false},
+		{name: "entry with port", host: "example.com", noProxy: "example.com:8080", want: true},
+		{name: "IP address", host: "10.1.2.3", noProxy: "10.1.2.3", want: true},
+		{name: "IP address with port", host: "10.1.2.3", noProxy: "10.1.2.3:443", want: true},
+		{name: "CIDR match", host: "192.168.17.4", noProxy: "192.168.0.0/16", want: true},
+		{name: "CIDR no match", host: "192.169.0.1", noProxy: "192.168.0.0/16", want: false},
+		{name: "CIDR IPv6", host: "fd00::1", noProxy: "fd00::/8", want: true},
+		{name: "CIDR and host name", host: "example.com", noProxy: "10.0.0.0/8", want: false},
+		{name: "wildcard", host: "anything.example", noProxy: "example.com, *", want: true},
+		{name: "empty entries", host: "example.com", noProxy: " , ,example.com", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := matchesNoProxy(test.host, test.noProxy)
+			if got != test.want {
+				t.Errorf("matchesNoProxy(%q, %q) = %v, want %v", test.host, test.noProxy, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// slashCommands lists the commands available in the terminal (first word of input line)
+var slashCommands = map[string]string{
+	"/model":      "/model <lite|flash|pro|flash-image|pro-image|default|name>  switch model",
+	"/tools":      "/tools +google-search -url-context ...  enable (+) or disable (-) tools",
+	"/files":      "/files [add <file> ...|clear]  list, add or remove local files",
+	"/thinking":   "/thinking <minimal|low|medium|high>  set thinking level",
+	"/candidates": "/candidates <n>  set number of candidate responses",
+	"/reset":      "/reset  start a new chat (chat mode)",
+	"/save":       "/save  save the chat session now (chat mode)",
+	"/config":     "/config  show the current configuration",
+	"/help":       "/help  show this list of commands",
+}
+
+// sessionState holds the state of the running session, which can be changed by slash commands
+type sessionState struct {
+	client         *genai.Client
+	modelInfo      *genai.Model
+	modelConfig    *genai.GenerateContentConfig
+	isImageRequest bool
+	cacheName      string
+	stores         []string // FileSearchStores included in the prompt
+	cacheTokens    int32    // token count of the cache (pre-flight), retrieved once per cache
+	cacheTokensOf  string   // cache name the token count belongs to
+	chat           *genai.Chat
+	chatNumber     int
+	chatSession    *ChatSession
+	pendingFiles   []FileToHandle // files added during a running chat (sent with next prompt)
+}
+
+/*
+isSlashCommand reports whether an input line is a known slash command (e.g. '/model pro').
+Other lines starting with a slash (e.g. a path) are treated as prompt.
+*/
+func isSlashCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	_, ok := slashCommands[strings.ToLower(fields[0])]
+	return ok
+}
+
+/*
+executeSlashCommand executes a slash command on the running session. Commands changing the model or the
+tools rebuild the model configuration via generateGeminiModelConfig; in chat mode the chat is recreated
+with the current history, so that the conversation continues with the new configuration.
+*/
+func executeSlashCommand(ctx context.Context, state *sessionState, line string) error {
+	fields := strings.Fields(line)
+	command := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	switch command {
+	case "/help":
+		fmt.Printf("\nCommands:\n")
+		for _, name := range []string{"/model", "/tools", "/files", "/thinking", "/candidates", "/reset", "/save", "/config", "/help"} {
+			fmt.Printf("  %s\n", slashCommands[name])
+		}
+		fmt.Printf("\n")
+		return nil
+
+	case "/config":
+		showCompactConfiguration(state.modelInfo, state.modelConfig)
+		return nil
+
+	case "/model":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", slashCommands[command])
+		}
+		model := resolveModelAlias(args[0])
+		modelInfo, err := state.client.Models.Get(ctx, model, nil)
+		if err != nil {
+			return fmt.Errorf("error [%w] getting AI model information", err)
+		}
+		if state.cacheName != "" && model != progConfig.GeminiAiModel {
+			// cache is model specific
+			fmt.Printf("Note: AI model specific cache not usable with model '%s', cache removed.\n", model)
+			state.cacheName = ""
+		}
+		progConfig.GeminiAiModel = model
+		state.modelInfo = modelInfo
+		state.isImageRequest = strings.Contains(model, "image")
+		if state.chatSession != nil {
+			state.chatSession.Model = model
+		}
+
+	case "/tools":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: %s", slashCommands[command])
+		}
+		for _, arg := range args {
+			enable := !strings.HasPrefix(arg, "-")
+			tool := strings.TrimLeft(arg, "+-")
+			switch strings.ToLower(tool) {
+			case "google-search":
+				progConfig.GeminiGroundingWithGoogleSearch = enable
+			case "url-context":
+				progConfig.GeminiGroundingWithURLContext = enable
+			case "code-execution":
+				progConfig.GeminiGroundingWithCodeExecution = enable
+			case "google-maps":
+				progConfig.GeminiGroundigWithGoogleMaps = enable
+			case "function-calling":
+				progConfig.FunctionCalling = enable
+			case "workspace-tools":
+				progConfig.WorkspaceTools = enable
+			case "mcp-tools":
+				progConfig.MCPTools = enable
+				if enable {
+					startMCPServers(ctx)
+				}
+			default:
+				return fmt.Errorf("unsupported tool [%s]", tool)
+			}
+		}
+
+	case "/files":
+		switch {
+		case len(args) == 0:
+			if len(filesToHandle) == 0 {
+				fmt.Printf("Files: none\n")
+			}
+			for _, fileToHandle := range filesToHandle {
+				fmt.Printf("  %-5s %s\n", fileToHandle.State, fileToHandle.Filepath)
+			}
+			return nil
+		case strings.ToLower(args[0]) == "add" && len(args) > 1:
+			addedFiles := buildGivenFiles(args[1:], nil)
+			for _, addedFile := range addedFiles {
+				if addedFile.State == "error" {
+					fmt.Printf("  %-5s %s %s\n", addedFile.State, addedFile.Filepath, addedFile.ErrorMessage)
+				}
+			}
+			filesToHandle = append(filesToHandle, addedFiles...)
+			if *chatmode && state.chatNumber > 1 {
+				// files of running chat are only sent with the initial prompt
+				state.pendingFiles = append(state.pendingFiles, addedFiles...)
+				fmt.Printf("Note: added %s will be sent with the next prompt.\n", pluralize(len(addedFiles), "file"))
+			}
+		case strings.ToLower(args[0]) == "clear" && len(args) == 1:
+			filesToHandle = nil
+			state.pendingFiles = nil
+			if *chatmode && state.chatNumber > 1 {
+				fmt.Printf("Note: files already sent remain part of the chat history (use /reset for a new chat).\n")
+			}
+		default:
+			return fmt.Errorf("usage: %s", slashCommands[command])
+		}
+		if state.chatSession != nil {
+			state.chatSession.Files = nil
+			for _, fileToHandle := range filesToHandle {
+				if fileToHandle.State != "error" {
+					state.chatSession.Files = append(state.chatSession.Files, fileToHandle.Filepath)
+				}
+			}
+		}
+
+	case "/thinking":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", slashCommands[command])
+		}
+		switch level := strings.ToLower(args[0]); level {
+		case "minimal", "low", "medium", "high":
+			progConfig.GeminiThinkingLevel = level
+			progConfig.GeminiMaxThinkingBudget = nil
+		default:
+			return fmt.Errorf("unsupported thinking level [%s]", args[0])
+		}
+
+	case "/candidates":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s", slashCommands[command])
+		}
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count <= 0 || count > 8 {
+			return fmt.Errorf("invalid number of candidates [%s] (1-8)", args[0])
+		}
+		candidateCount := int32(count)
+		progConfig.GeminiCandidateCount = &candidateCount
+
+	case "/reset":
+		if !*chatmode {
+			return fmt.Errorf("nothing to reset in non-chat mode")
+		}
+		chat, err := state.client.Chats.Create(ctx, progConfig.GeminiAiModel, state.modelConfig, nil)
+		if err != nil {
+			return fmt.Errorf("error [%w] creating Gemini chat mode session", err)
+		}
+		state.chat = chat
+		state.chatNumber = 1
+		state.pendingFiles = nil
+		if progConfig.ChatSessionHistory {
+			state.chatSession = newChatSession(state.cacheName)
+		}
+		fmt.Printf("New chat started.\n")
+		return nil
+
+	case "/save":
+		if !*chatmode {
+			return fmt.Errorf("nothing to save in non-chat mode")
+		}
+		if state.chatSession == nil {
+			state.chatSession = newChatSession(state.cacheName)
+		}
+		if progConfig.ChatSessionDirectory == "" {
+			return fmt.Errorf("empty ChatSessionDirectory not allowed")
+		}
+		err := os.MkdirAll(progConfig.ChatSessionDirectory, 0750)
+		if err != nil {
+			return fmt.Errorf("error [%w] creating chat session directory", err)
+		}
+		saveChatSession(state.chatSession, state.chat, state.chatNumber-1)
+		fmt.Printf("Chat session saved (resume with '-resume %s').\n", state.chatSession.ID)
+		return nil
+	}
+
+	// rebuild model configuration and show new configuration
+	state.modelConfig = generateGeminiModelConfig(state.isImageRequest, state.cacheName, state.stores)
+	if *chatmode {
+		chat, err := state.client.Chats.Create(ctx, progConfig.GeminiAiModel, state.modelConfig, state.chat.History(true))
+		if err != nil {
+			return fmt.Errorf("error [%w] recreating Gemini chat mode session", err)
+		}
+		state.chat = chat
+	}
+	showCompactConfiguration(state.modelInfo, state.modelConfig)
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// retryAttempt records a failed attempt of a Gemini API call
+type retryAttempt struct {
+	number int
+	err    error
+	delay  time.Duration // waiting time before the next attempt (0 = no further attempt)
+}
+
+// streamInterruptedError is a stream error after text has already been emitted, it is neither retried nor
+// answered by a fallback model (the text would be emitted twice)
+type streamInterruptedError struct {
+	err error
+}
+
+/*
+Error implements the error interface.
+*/
+func (e *streamInterruptedError) Error() string {
+	return fmt.Sprintf("stream interrupted after partial output: %v", e.err)
+}
+
+/*
+Unwrap returns the original stream error.
+*/
+func (e *streamInterruptedError) Unwrap() error {
+	return e.err
+}
+
+// retryAttempts holds the failed attempts of the current request (shown in response metadata)
+var retryAttempts []retryAttempt
+
+/*
+withRetry executes a Gemini API call and retries it on quota (429) and transient (500, 503, 504) errors.
+The delay grows exponentially from RetryBaseDelay up to RetryMaxDelay, with random jitter. A retry delay
+requested by Gemini (RetryInfo detail of the error) takes precedence. Waiting is interrupted if the context
+is cancelled (e.g. Ctrl-C). All failed attempts are returned for documentation purposes.
+*/
+func withRetry[T any](ctx context.Context, action string, operation func() (T, error)) (T, []retryAttempt, error) {
+	attempts := []retryAttempt{}
+	maxAttempts := max(progConfig.RetryMaxAttempts, 1)
+
+	for number := 1; ; number++ {
+		result, err := operation()
+		if err == nil || number >= maxAttempts || !isRetryableError(err) || ctx.Err() != nil {
+			if err != nil && len(attempts) > 0 {
+				attempts = append(attempts, retryAttempt{number: number, err: err})
+			}
+			return result, attempts, err
+		}
+
+		delay := retryDelay(number, err)
+		maxDelay := time.Duration(progConfig.RetryMaxDelay) * time.Second
+		if maxDelay > 0 && delay > maxDelay {
+			fmt.Printf("%s failed (%s), retry delay requested by Gemini (%v) exceeds RetryMaxDelay\n", action, shortErrorText(err), delay)
+			attempts = append(attempts, retryAttempt{number: number, err: err})
+			return result, attempts, err
+		}
+		attempts = append(attempts, retryAttempt{number: number, err: err, delay: delay})
+
+		now := time.Now()
+		fmt.Printf("%02d:%02d:%02d: %s failed (%s), attempt %d of %d, retrying in %.1f secs ...\n",
+			now.Hour(), now.Minute(), now.Second(), action, shortErrorText(err), number, maxAttempts, delay.Seconds())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, attempts, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+/*
+isRetryableError reports whether an error is a quota or transient server error worth retrying. Stream errors
+after partial output are not retried.
+*/
+func isRetryableError(err error) bool {
+	var interruptedErr *streamInterruptedError
+	if errors.As(err, &interruptedErr) {
+		return false
+	}
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case 429, 500, 503, 504:
+		return true
+	}
+	switch apiErr.Status {
+	case "RESOURCE_EXHAUSTED", "UNAVAILABLE", "DEADLINE_EXCEEDED":
+		return true
+	}
+	return false
+}
+
+/*
+retryDelay calculates the waiting time before the next attempt. A delay requested by Gemini (RetryInfo)
+is used as is, otherwise exponential backoff with jitter is applied.
+*/
+func retryDelay(number int, err error) time.Duration {
+	requestedDelay := retryDelayFromError(err)
+	if requestedDelay > 0 {
+		return requestedDelay
+	}
+
+	baseDelay := time.Duration(max(progConfig.RetryBaseDelay, 1)) * time.Second
+	delay := baseDelay << (number - 1)
+	maxDelay := time.Duration(progConfig.RetryMaxDelay) * time.Second
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	// jitter (e.g. 20% -> delay +/- 20%)
+	if progConfig.RetryJitter > 0 {
+		jitter := float64(delay) * float64(progConfig.RetryJitter) / 100.0
+		delay += time.Duration((rand.Float64()*2.0 - 1.0) * jitter)
+	}
+	return delay
+}
+
+/*
+retryDelayFromError extracts the retry delay from the 'google.rpc.RetryInfo' detail of a Gemini API error
+(e.g. "retryDelay": "26s"). It returns 0 if no retry delay is given.
+*/
+func retryDelayFromError(err error) time.Duration {
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	for _, detail := range apiErr.Details {
+		detailType, _ := detail["@type"].(string)
+		if !strings.HasSuffix(detailType, "google.rpc.RetryInfo") {
+			continue
+		}
+		retryDelay, _ := detail["retryDelay"].(string)
+		delay, err := time.ParseDuration(retryDelay)
+		if err == nil && delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+/*
+shortErrorText returns a short description of an error (e.g. '429 RESOURCE_EXHAUSTED').
+*/
+func shortErrorText(err error) string {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return strings.TrimSpace(fmt.Sprintf("%d %s", apiErr.Code, apiErr.Status))
+	}
+	return err.Error()
+}
+
+/*
+retryAttemptsSummary summarizes the failed attempts of the current request for the response metadata
+(e.g. '3 (retried after 429 RESOURCE_EXHAUSTED, 503 UNAVAILABLE)').
+*/
+func retryAttemptsSummary(succeeded bool) string {
+	failures := []string{}
+	for _, attempt := range retryAttempts {
+		failures = append(failures, shortErrorText(attempt.err))
+	}
+	if succeeded {
+		return fmt.Sprintf("%d (retried after %s)", len(retryAttempts)+1, strings.Join(failures, ", "))
+	}
+	return fmt.Sprintf("%d (all failed: %s)", len(retryAttempts), strings.Join(failures, ", "))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// ChatSession represents a persisted chat session (curated chat history plus session metadata)
+type ChatSession struct {
+	ID         string           `json:"id"`
+	Created    time.Time        `json:"created"`
+	Updated    time.Time        `json:"updated"`
+	Model      string           `json:"model"`
+	ChatNumber int              `json:"chatNumber"` // number of completed prompts
+	Files      []string         `json:"files,omitempty"`
+	Stores     []string         `json:"stores,omitempty"`
+	Cache      string           `json:"cache,omitempty"`
+	History    []*genai.Content `json:"history"`
+}
+
+/*
+newChatSession creates a new chat session with the current model, files, stores and cache as metadata.
+The session ID is the start time of the session plus a random suffix, so that sessions started in the same
+second don't overwrite each other (schema = yyyymmdd-hhmmss-xxxx).
+*/
+func newChatSession(cacheName string) *ChatSession {
+	now := time.Now()
+	id := fmt.Sprintf("%s-%04x", now.Format("20060102-150405"), rand.IntN(0x10000))
+	for fileExists(chatSessionFilename(id)) {
+		id = fmt.Sprintf("%s-%04x", now.Format("20060102-150405"), rand.IntN(0x10000))
+	}
+	chatSession := &ChatSession{
+		ID:      id,
+		Created: now,
+		Model:   progConfig.GeminiAiModel,
+		Stores:  append([]string{}, includeStores...),
+		Cache:   cacheName,
+	}
+	for _, fileToHandle := range filesToHandle {
+		if fileToHandle.State != "error" {
+			chatSession.Files = append(chatSession.Files, fileToHandle.Filepath)
+		}
+	}
+	return chatSession
+}
+
+/*
+restoreChatSessionCache returns the cache of a resumed chat session, if it still exists. An expired or deleted
+cache is dropped with a note, the session continues without cache (dry-run: not checked).
+*/
+func restoreChatSessionCache(ctx context.Context, client *genai.Client, chatSession *ChatSession) string {
+	if chatSession.Cache == "" || *dryRun {
+		return chatSession.Cache
+	}
+	_, err := client.Caches.Get(ctx, chatSession.Cache, nil)
+	if err != nil {
+		fmt.Printf("Note: cache '%s' of chat session not available (%v), session continues without cache\n", chatSession.Cache, err)
+		return ""
+	}
+	return chatSession.Cache
+}
+
+/*
+chatSessionFilename builds the path of the session file from a session ID.
+*/
+func chatSessionFilename(id string) string {
+	return filepath.Join(progConfig.ChatSessionDirectory, strings.TrimSuffix(id, ".json")+".json")
+}
+
+/*
+saveChatSession saves the curated history of the chat together with the session metadata as JSON file.
+The file is written to a temporary file first and then renamed, so that a crash never leaves a damaged
+session file behind.
+*/
+func saveChatSession(chatSession *ChatSession, chat *genai.Chat, chatNumber int) {
+	chatSession.Updated = time.Now()
+	chatSession.ChatNumber = chatNumber
+	chatSession.History = chat.History(true)
+
+	data, err := json.MarshalIndent(chatSession, "", "  ")
+	if err != nil {
+		fmt.Printf("error [%v] at json.MarshalIndent()\n", err)
+		return
+	}
+
+	filename := chatSessionFilename(chatSession.ID)
+	temporaryFilename := filename + ".tmp"
+	err = os.WriteFile(temporaryFilename, data, 0600)
+	if err != nil {
+		fmt.Printf("error [%v] at os.WriteFile()\n", err)
+		return
+	}
+	err = os.Rename(temporaryFilename, filename)
+	if err != nil {
+		fmt.Printf("error [%v] at os.Rename()\n", err)
+	}
+}
+
+/*
+loadChatSession loads a chat session by its ID (or by the path of the session file).
+*/
+func loadChatSession(id string) (*ChatSession, error) {
+	filename := id
+	if !fileExists(filename) {
+		filename = chatSessionFilename(id)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error [%w] reading chat session file", err)
+	}
+
+	chatSession := &ChatSession{}
+	err = json.Unmarshal(data, chatSession)
+	if err != nil {
+		return nil, fmt.Errorf("error [%w] unmarshalling chat session file [%s]", err, filename)
+	}
+	if chatSession.Model == "" {
+		return nil, fmt.Errorf("chat session file [%s] without model", filename)
+	}
+
+	return chatSession, nil
+}
+
+/*
+firstPromptOfChatSession returns the text of the first user prompt in the chat history (used as title).
+*/
+func firstPromptOfChatSession(history []*genai.Content) string {
+	for _, content := range history {
+		if content == nil || content.Role != genai.RoleUser {
+			continue
+		}
+		// text prompt is the last text part (file data precedes the prompt)
+		for i := len(content.Parts) - 1; i >= 0; i-- {
+			if content.Parts[i] != nil && content.Parts[i].Text != "" {
+				return strings.Join(strings.Fields(content.Parts[i].Text), " ")
+			}
+		}
+	}
+	return ""
+}
+
+/*
+listChatSessions lists all saved chat sessions (ID, last update, model, number of prompts, first prompt).
+*/
+func listChatSessions(indent string) {
+	entries, err := os.ReadDir(progConfig.ChatSessionDirectory)
+	if err != nil {
+		fmt.Printf("%serror [%v] reading chat session directory\n", indent, err)
+		return
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) == 0 {
+		fmt.Printf("%snone\n", indent)
+		return
+	}
+
+	for _, id := range ids {
+		chatSession, err := loadChatSession(id)
+		if err != nil {
+			fmt.Printf("%s%s  error [%v]\n", indent, id, err)
+			continue
+		}
+		title := firstPromptOfChatSession(chatSession.History)
+		if len(title) > 60 {
+			title = strings.TrimSpace(strings.ToValidUTF8(title[:60], "")) + " ..."
+		}
+		fmt.Printf("%s%s  %s  %-28s  %3d %-8s  %s\n", indent, chatSession.ID, chatSession.Updated.Local().Format("2006-01-02 15:04"),
+			strings.TrimPrefix(chatSession.Model, "models/"), chatSession.ChatNumber, pluralize(chatSession.ChatNumber, "prompt"), title)
+	}
+}
+
+/*
+handleStandaloneSessionActions handles listing of saved chat sessions.
+*/
+func handleStandaloneSessionActions() {
+	if *listSessions {
+		fmt.Printf("\nListing chat sessions in '%s':\n", progConfig.ChatSessionDirectory)
+		listChatSessions("  ")
+		fmt.Printf("\n")
+		os.Exit(0)
+	}
+}
@@ -14,10 +14,7 @@ listGeminiFileSearchStores lists all available FileSearchStores in a detailed bl
 */
 func listGeminiFileSearchStores(indent string) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -27,13 +24,11 @@ func listGeminiFileSearchStores(indent string) {
 	fmt.Printf("%s  Create Time, Update Time\n", indent)
 	fmt.Printf("%s  Active Docs, Total Size\n\n", indent)
 
-	found := false
-	for store, err := range client.FileSearchStores.All(ctx) {
-		if err != nil {
-			log.Fatalf("error [%v] retrieving FileSearchStores", err)
-		}
-
-		found = true
+	stores, err := fileSearchStores(ctx, client)
+	if err != nil {
+		log.Fatalf("error [%v] retrieving FileSearchStores", err)
+	}
+	for _, store := range stores {
 		createTimeStr := store.CreateTime.Local().Format("20060102-150405")
 		updateTimeStr := store.UpdateTime.Local().Format("20060102-150405")
 		sizeKiB := float64(store.SizeBytes) / 1024.0
@@ -45,21 +40,31 @@ func listGeminiFileSearchStores(indent string) {
 		fmt.Printf("%s  %d active documents, %.1f KiB\n\n", indent, store.ActiveDocumentsCount, sizeKiB)
 	}
 
-	if !found {
+	if len(stores) == 0 {
 		fmt.Printf("%sno FileSearchStores found\n", indent+"  ")
 	}
 }
 
+/*
+fileSearchStores returns all FileSearchStores.
+*/
+func fileSearchStores(ctx context.Context, client *genai.Client) ([]*genai.FileSearchStore, error) {
+	stores := []*genai.FileSearchStore{}
+	for store, err := range client.FileSearchStores.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
 /*
 createGeminiFileSearchStore creates a new FileSearchStore.
 */
 func createGeminiFileSearchStore(displayName string) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx,
-		&genai.ClientConfig{
-			APIKey:  progConfig.GeminiAPIKey,
-			Backend: genai.BackendGeminiAPI,
-		})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -81,10 +86,7 @@ deleteGeminiFileSearchStore deletes a store by its name (ID).
 */
 func deleteGeminiFileSearchStore(storeName string) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -112,10 +114,7 @@ func addFilesToFileSearchStore(storeName string, files []FileToHandle) {
 	}
 
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -135,13 +134,15 @@ func addFilesToFileSearchStore(storeName string, files []FileToHandle) {
 			}
 		}
 
-		op, err := client.FileSearchStores.UploadToFileSearchStoreFromPath(ctx,
-			fileToHandle.Filepath,
-			storeName,
-			&genai.UploadToFileSearchStoreConfig{
-				DisplayName: fileToHandle.Filepath,
-				MIMEType:    mimeType,
-			})
+		op, _, err := withRetry(ctx, "Upload", func() (*genai.UploadToFileSearchStoreOperation, error) {
+			return client.FileSearchStores.UploadToFileSearchStoreFromPath(ctx,
+				fileToHandle.Filepath,
+				storeName,
+				&genai.UploadToFileSearchStoreConfig{
+					DisplayName: fileToHandle.Filepath,
+					MIMEType:    mimeType,
+				})
+		})
 		if err != nil {
 			fmt.Printf("FAILED: %v\n", err)
 			continue
@@ -177,10 +178,7 @@ func deleteFileFromFileSearchStore(documentName string) {
 	}
 
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -203,10 +201,7 @@ storeName is the ID/name of the store (e.g., "fileSearchStores/12345").
 */
 func listFilesInFileSearchStore(storeName string, indent string) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		log.Fatalf("error [%v] creating Gemini AI client", err)
 	}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+/*
+streamResponse consumes a streamed Gemini AI response chunk by chunk. The text of the first candidate is
+printed raw to the terminal as it arrives, and the markdown prompt/response file is rewritten after each
+chunk (prompt + partial response), so that editors can live-preview the answer. All chunks are aggregated
+into one response, which is returned for the regular (final) response processing. In case of an error the
+partial response received so far is returned together with the error. If given, streamText receives the
+text of the first candidate as it arrives (e.g. for server-sent events).
+*/
+func streamResponse(stream iter.Seq2[*genai.GenerateContentResponse, error], streamText func(string)) (*genai.GenerateContentResponse, error) {
+	// markdown file contains the prompt (written by processPrompt)
+	promptMarkdown, err := os.ReadFile(progConfig.MarkdownPromptResponseFile)
+	if err != nil {
+		fmt.Printf("error [%v] at os.ReadFile()\n", err)
+	}
+
+	aggregated := &genai.GenerateContentResponse{}
+	var streamErr error
+	terminalOutput := progConfig.AnsiOutput
+	emitted := false // text already written to terminal or caller
+
+	if terminalOutput {
+		fmt.Printf("\n")
+	}
+	for chunk, err := range stream {
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if chunk == nil {
+			continue
+		}
+
+		// print text of first candidate raw to terminal (multiple candidates would interleave)
+		if terminalOutput || streamText != nil {
+			for _, candidate := range chunk.Candidates {
+				if candidate.Index != 0 || candidate.Content == nil {
+					continue
+				}
+				for _, part := range candidate.Content.Parts {
+					if part.Thought || part.Text == "" {
+						continue
+					}
+					if terminalOutput {
+						fmt.Print(part.Text)
+					}
+					if streamText != nil {
+						streamText(part.Text)
+					}
+					emitted = true
+				}
+			}
+		}
+
+		mergeResponseChunk(aggregated, chunk)
+
+		// update markdown file (live preview)
+		liveMarkdown := string(promptMarkdown) + buildStreamingMarkdown(aggregated)
+		err = os.WriteFile(progConfig.MarkdownPromptResponseFile, []byte(liveMarkdown), 0600)
+		if err != nil {
+			fmt.Printf("error [%v] at os.WriteFile()\n", err)
+		}
+	}
+	if terminalOutput {
+		fmt.Printf("\n\n")
+	}
+
+	// restore markdown file (prompt only), final response is appended by regular response processing
+	err = os.WriteFile(progConfig.MarkdownPromptResponseFile, promptMarkdown, 0600)
+	if err != nil {
+		fmt.Printf("error [%v] at os.WriteFile()\n", err)
+	}
+
+	if streamErr != nil && emitted {
+		// a retry would emit the text again
+		return aggregated, &streamInterruptedError{err: streamErr}
+	}
+	if streamErr != nil {
+		return aggregated, streamErr
+	}
+	if len(aggregated.Candidates) == 0 && aggregated.PromptFeedback == nil && aggregated.UsageMetadata == nil {
+		return nil, fmt.Errorf("stream ended without any response data")
+	}
+	return aggregated, nil
+}
+
+/*
+mergeResponseChunk merges a streamed response chunk into the aggregated response. Text fragments are
+concatenated to complete text parts, all other parts are appended. Metadata (usage, grounding, citations,
+finish reason) is taken from the chunks as it arrives; the final chunk carries the complete values.
+*/
+func mergeResponseChunk(aggregated *genai.GenerateContentResponse, chunk *genai.GenerateContentResponse) {
+	if chunk.ModelVersion != "" {
+		aggregated.ModelVersion = chunk.ModelVersion
+	}
+	if chunk.ResponseID != "" {
+		aggregated.ResponseID = chunk.ResponseID
+	}
+	if !chunk.CreateTime.IsZero() {
+		aggregated.CreateTime = chunk.CreateTime
+	}
+	if chunk.PromptFeedback != nil {
+		aggregated.PromptFeedback = chunk.PromptFeedback
+	}
+	if chunk.UsageMetadata != nil {
+		aggregated.UsageMetadata = chunk.UsageMetadata
+	}
+
+	for _, chunkCandidate := range chunk.Candidates {
+		// find corresponding candidate in aggregated response
+		var candidate *genai.Candidate
+		for _, existing := range aggregated.Candidates {
+			if existing.Index == chunkCandidate.Index {
+				candidate = existing
+				break
+			}
+		}
+		if candidate == nil {
+			candidate = &genai.Candidate{Index: chunkCandidate.Index}
+			aggregated.Candidates = append(aggregated.Candidates, candidate)
+		}
+
+		if chunkCandidate.Content != nil {
+			if candidate.Content == nil {
+				candidate.Content = &genai.Content{Role: chunkCandidate.Content.Role}
+			}
+			for _, part := range chunkCandidate.Content.Parts {
+				appendStreamedPart(candidate.Content, part)
+			}
+		}
+
+		if chunkCandidate.FinishReason != "" {
+			candidate.FinishReason = chunkCandidate.FinishReason
+		}
+		if chunkCandidate.FinishMessage != "" {
+			candidate.FinishMessage = chunkCandidate.FinishMessage
+		}
+		if chunkCandidate.CitationMetadata != nil {
+			if candidate.CitationMetadata == nil {
+				candidate.CitationMetadata = &genai.CitationMetadata{}
+			}
+			candidate.CitationMetadata.Citations = append(candidate.CitationMetadata.Citations, chunkCandidate.CitationMetadata.Citations...)
+		}
+		if chunkCandidate.GroundingMetadata != nil {
+			candidate.GroundingMetadata = chunkCandidate.GroundingMetadata
+		}
+		if chunkCandidate.URLContextMetadata != nil {
+			candidate.URLContextMetadata = chunkCandidate.URLContextMetadata
+		}
+		if chunkCandidate.SafetyRatings != nil {
+			candidate.SafetyRatings = chunkCandidate.SafetyRatings
+		}
+		if chunkCandidate.TokenCount > 0 {
+			candidate.TokenCount = chunkCandidate.TokenCount
+		}
+	}
+}
+
+/*
+appendStreamedPart appends a streamed part to the content. A pure text part is concatenated with a
+preceding pure text part of the same kind (thought or regular text), because a streamed text is split
+into many small fragments.
+*/
+func appendStreamedPart(content *genai.Content, part *genai.Part) {
+	if part == nil {
+		return
+	}
+	if isPureTextPart(part) && len(content.Parts) > 0 {
+		last := content.Parts[len(content.Parts)-1]
+		if isPureTextPart(last) && last.Thought == part.Thought {
+			last.Text += part.Text
+			if len(part.ThoughtSignature) > 0 {
+				last.ThoughtSignature = part.ThoughtSignature
+			}
+			return
+		}
+	}
+
+	// copy part, text of copy may be extended by further chunks
+	partCopy := *part
+	content.Parts = append(content.Parts, &partCopy)
+}
+
+/*
+isPureTextPart checks if a part contains only text (regular text or thought).
+*/
+func isPureTextPart(part *genai.Part) bool {
+	return part.InlineData == nil && part.FileData == nil && part.FunctionCall == nil &&
+		part.FunctionResponse == nil && part.ExecutableCode == nil && part.CodeExecutionResult == nil &&
+		part.VideoMetadata == nil
+}
+
+/*
+buildStreamingMarkdown builds the (preliminary) markdown of the response received so far.
+Only regular text is included, thoughts and other parts are shown in the final response.
+*/
+func buildStreamingMarkdown(resp *genai.GenerateContentResponse) string {
+	var sb strings.Builder
+
+	for i, candidate := range resp.Candidates {
+		if len(resp.Candidates) > 1 {
+			sb.WriteString(fmt.Sprintf("**Response from Gemini (Candidate #%d, streaming ...):**\n\n", (i + 1)))
+		} else {
+			sb.WriteString("**Response from Gemini (streaming ...):**\n\n")
+		}
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if !part.Thought && part.Text != "" {
+					sb.WriteString(part.Text)
+				}
+			}
+		}
+		sb.WriteString("\n\n***\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+// structuredFeedbackMax is the maximum number of validation errors sent back to the model
+const structuredFeedbackMax = 20
+
+// responseSchema is the JSON Schema of structured output mode (nil = Markdown text responses)
+var responseSchema map[string]any
+
+// structuredResult holds the (pretty-printed) JSON of the current response in structured output mode
+var structuredResult []byte
+
+// structuredOutputInfo describes the validation of the current structured response (shown in response metadata)
+var structuredOutputInfo string
+
+/*
+loadResponseSchema reads the JSON Schema for structured output mode (GeminiResponseSchemaFile, option -schema).
+Without schema file the responses are Markdown text.
+*/
+func loadResponseSchema() error {
+	responseSchema = nil
+	if progConfig.GeminiResponseSchemaFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(progConfig.GeminiResponseSchemaFile)
+	if err != nil {
+		return fmt.Errorf("error [%w] reading JSON Schema file", err)
+	}
+	schema := map[string]any{}
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		return fmt.Errorf("error [%w] parsing JSON Schema file [%s]", err, progConfig.GeminiResponseSchemaFile)
+	}
+	responseSchema = schema
+	return nil
+}
+
+/*
+structuredOutputActive reports whether responses are requested as JSON according to a JSON Schema.
+*/
+func structuredOutputActive() bool {
+	return responseSchema != nil
+}
+
+/*
+structuredSlug returns the slug of structured responses (they contain no metadata slug): the title of the schema
+or the name of the schema file.
+*/
+func structuredSlug() string {
+	if title, ok := responseSchema["title"].(string); ok && strings.TrimSpace(title) != "" {
+		return sanitizeSlug(title)
+	}
+	base := filepath.Base(progConfig.GeminiResponseSchemaFile)
+	return sanitizeSlug(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+/*
+runStructuredOutputCheck validates the JSON response against the schema. If the response is invalid, the model is
+re-asked once (sendCorrection) with its answer and the validation errors. The validated JSON is kept as
+structuredResult (history artifact), the outcome is described in structuredOutputInfo.
+*/
+func runStructuredOutputCheck(ctx context.Context, resp *genai.GenerateContentResponse,
+	sendCorrection func(invalidContent *genai.Content, feedback string) (*genai.GenerateContentResponse, error)) (*genai.GenerateContentResponse, error) {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return resp, nil
+	}
+
+	text := structuredResponseText(resp.Candidates[0])
+	problems := validateStructuredJSON(text, responseSchema)
+	reasked := false
+	if len(problems) > 0 && ctx.Err() == nil {
+		fmt.Printf("Note: response does not match JSON Schema (%d %s), asking model once more ...\n",
+			len(problems), pluralize(len(problems), "error"))
+		_, answer := splitThoughtParts(resp.Candidates[0].Content.Parts)
+		next, err := sendCorrection(genai.NewContentFromParts(answer, genai.RoleModel), structuredFeedback(problems))
+		if err != nil {
+			return next, err
+		}
+		next.UsageMetadata = addUsageMetadata(resp.UsageMetadata, next.UsageMetadata)
+		resp = next
+		reasked = true
+		if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+			text = structuredResponseText(resp.Candidates[0])
+			problems = validateStructuredJSON(text, responseSchema)
+		}
+	}
+
+	structuredOutputInfo = "valid JSON"
+	if len(problems) > 0 {
+		structuredOutputInfo = fmt.Sprintf("invalid JSON (%s)", strings.Join(problems, "; "))
+		fmt.Printf("Note: response does not match JSON Schema: %s\n", strings.Join(problems, "; "))
+	}
+	if reasked {
+		structuredOutputInfo += ", re-asked once"
+	}
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, []byte(text), "", "  ") == nil {
+		structuredResult = pretty.Bytes()
+	}
+	return resp, nil
+}
+
+/*
+structuredFeedback builds the correction prompt from the validation errors.
+*/
+func structuredFeedback(problems []string) string {
+	if len(problems) > structuredFeedbackMax {
+		problems = append(problems[:structuredFeedbackMax:structuredFeedbackMax], fmt.Sprintf("... (%d more)", len(problems)-structuredFeedbackMax))
+	}
+	return "Your previous response is not valid according to the required JSON Schema:\n- " + strings.Join(problems, "\n- ") +
+		"\nReturn the complete corrected JSON only, without explanations and without code fences."
+}
+
+/*
+structuredResponseText returns the JSON text of a candidate (answer parts without thoughts, surrounding code fences
+removed).
+*/
+func structuredResponseText(candidate *genai.Candidate) string {
+	var sb strings.Builder
+	_, answer := splitThoughtParts(candidate.Content.Parts)
+	for _, part := range answer {
+		sb.WriteString(part.Text)
+	}
+	return stripJSONFences(sb.String())
+}
+
+/*
+stripJSONFences removes a Markdown code fence (```json ... ```) around a JSON text.
+*/
+func stripJSONFences(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	_, body, found := strings.Cut(text, "\n")
+	if !found {
+		return text
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body), "```"))
+}
+
+/*
+renderStructuredText renders the JSON text of a structured response as pretty-printed JSON code block (Markdown).
+Text which is not JSON is shown unchanged in the code block.
+*/
+func renderStructuredText(text string) string {
+	text = stripJSONFences(text)
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, []byte(text), "", "  ") == nil {
+		text = pretty.String()
+	}
+	return "```json\n" + text + "\n```"
+}
+
+/*
+validateStructuredJSON parses a JSON text and validates it against the schema. It returns the validation errors
+(empty if valid).
+*/
+func validateStructuredJSON(text string, schema map[string]any) []string {
+	var value any
+	err := json.Unmarshal([]byte(text), &value)
+	if err != nil {
+		return []string{fmt.Sprintf("response is no valid JSON: %v", err)}
+	}
+	return validateJSONSchema(value, schema, schema, "$")
+}
+
+/*
+validateJSONSchema validates a JSON value (decoded with encoding/json) against a JSON Schema. Supported keywords:
+type, enum, const, properties, required, additionalProperties, items, minItems, maxItems, uniqueItems, minLength,
+maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, anyOf, oneOf, allOf and local $ref
+('#/$defs/...', '#/definitions/...'). Other keywords (e.g. format) are ignored.
+*/
+func validateJSONSchema(value any, schema map[string]any, root map[string]any, path string) []string {
+	problems := []string{}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, found := resolveSchemaRef(root, ref)
+		if !found {
+			return []string{fmt.Sprintf("%s: unresolvable $ref [%s]", path, ref)}
+		}
+		schema = resolved
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.Contains(types, jsonType(value)) {
+		if !(jsonType(value) == "integer" && slices.Contains(types, "number")) {
+			return []string{fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(value))}
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(allowed any) bool { return jsonEqual(allowed, value) }) {
+		problems = append(problems, fmt.Sprintf("%s: value not in enum %s", path, compactJSON(enum)))
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		problems = append(problems, fmt.Sprintf("%s: value must be %s", path, compactJSON(constant)))
+	}
+
+	switch typed := value.(type) {
+	case map[string]any:
+		problems = append(problems, validateJSONObject(typed, schema, root, path)...)
+	case []any:
+		problems = append(problems, validateJSONArray(typed, schema, root, path)...)
+	case string:
+		length := utf8.RuneCountInString(typed)
+		if minLength, ok := schemaNumber(schema, "minLength"); ok && float64(length) < minLength {
+			problems = append(problems, fmt.Sprintf("%s: string shorter than %v characters", path, minLength))
+		}
+		if maxLength, ok := schemaNumber(schema, "maxLength"); ok && float64(length) > maxLength {
+			problems = append(problems, fmt.Sprintf("%s: string longer than %v characters", path, maxLength))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			matched, err := regexp.MatchString(pattern, typed)
+			if err == nil && !matched {
+				problems = append(problems, fmt.Sprintf("%s: string does not match pattern [%s]", path, pattern))
+			}
+		}
+	case float64:
+		if minimum, ok := schemaNumber(schema, "minimum"); ok && typed < minimum {
+			problems = append(problems, fmt.Sprintf("%s: %v is less than minimum %v", path, typed, minimum))
+		}
+		if maximum, ok := schemaNumber(schema, "maximum"); ok && typed > maximum {
+			problems = append(problems, fmt.Sprintf("%s: %v is greater than maximum %v", path, typed, maximum))
+		}
+		if minimum, ok := schemaNumber(schema, "exclusiveMinimum"); ok && typed <= minimum {
+			problems = append(problems, fmt.Sprintf("%s: %v must be greater than %v", path, typed, minimum))
+		}
+		if maximum, ok := schemaNumber(schema, "exclusiveMaximum"); ok && typed >= maximum {
+			problems = append(problems, fmt.Sprintf("%s: %v must be less than %v", path, typed, maximum))
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, subschema := range allOf {
+			if sub, ok := subschema.(map[string]any); ok {
+				problems = append(problems, validateJSONSchema(value, sub, root, path)...)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && countMatchingSchemas(value, anyOf, root, path) == 0 {
+		problems = append(problems, fmt.Sprintf("%s: value matches none of the 'anyOf' schemas", path))
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		if matches := countMatchingSchemas(value, oneOf, root, path); matches != 1 {
+			problems = append(problems, fmt.Sprintf("%s: value matches %d of the 'oneOf' schemas (exactly one required)", path, matches))
+		}
+	}
+
+	return problems
+}
+
+/*
+validateJSONObject validates the properties of a JSON object (properties, required, additionalProperties).
+*/
+func validateJSONObject(object map[string]any, schema map[string]any, root map[string]any, path string) []string {
+	problems := []string{}
+	properties, _ := schema["properties"].(map[string]any)
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, found := object[key]; !found {
+					problems = append(problems, fmt.Sprintf("%s: required property '%s' missing", path, key))
+				}
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		propertyPath := path + "." + key
+		if propertySchema, ok := properties[key].(map[string]any); ok {
+			problems = append(problems, validateJSONSchema(object[key], propertySchema, root, propertyPath)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				problems = append(problems, fmt.Sprintf("%s: additional property not allowed", propertyPath))
+			}
+		case map[string]any:
+			problems = append(problems, validateJSONSchema(object[key], additional, root, propertyPath)...)
+		}
+	}
+	return problems
+}
+
+/*
+validateJSONArray validates the items of a JSON array (items, minItems, maxItems, uniqueItems).
+*/
+func validateJSONArray(array []any, schema map[string]any, root map[string]any, path string) []string {
+	problems := []string{}
+	if minItems, ok := schemaNumber(schema, "minItems"); ok && float64(len(array)) < minItems {
+		problems = append(problems, fmt.Sprintf("%s: fewer than %v items", path, minItems))
+	}
+	if maxItems, ok := schemaNumber(schema, "maxItems"); ok && float64(len(array)) > maxItems {
+		problems = append(problems, fmt.Sprintf("%s: more than %v items", path, maxItems))
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := range array {
+			if slices.ContainsFunc(array[:i], func(other any) bool { return jsonEqual(other, array[i]) }) {
+				problems = append(problems, fmt.Sprintf("%s[%d]: duplicate item", path, i))
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		for i, item := range array {
+			problems = append(problems, validateJSONSchema(item, items, root, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return problems
+}
+
+/*
+countMatchingSchemas returns the number of schemas (of anyOf / oneOf) the value is valid against.
+*/
+func countMatchingSchemas(value any, schemas []any, root map[string]any, path string) int {
+	matches := 0
+	for _, subschema := range schemas {
+		if sub, ok := subschema.(map[string]any); ok && len(validateJSONSchema(value, sub, root, path)) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+/*
+resolveSchemaRef resolves a local reference (e.g. '#/$defs/address') within the root schema.
+*/
+func resolveSchemaRef(root map[string]any, ref string) (map[string]any, bool) {
+	if ref == "#" {
+		return root, true
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	var current any = root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[token]
+		if !ok {
+			return nil, false
+		}
+	}
+	resolved, ok := current.(map[string]any)
+	return resolved, ok
+}
+
+/*
+schemaTypes returns the allowed types of a schema ('type' as string or array of strings).
+*/
+func schemaTypes(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		types := []string{}
+		for _, item := range typed {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+/*
+schemaNumber returns a numeric keyword of a schema (e.g. 'minimum').
+*/
+func schemaNumber(schema map[string]any, keyword string) (float64, bool) {
+	number, ok := schema[keyword].(float64)
+	return number, ok
+}
+
+/*
+jsonType returns the JSON Schema type name of a decoded JSON value (whole numbers are 'integer').
+*/
+func jsonType(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if typed == math.Trunc(typed) && !math.IsInf(typed, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+/*
+jsonEqual reports whether two decoded JSON values are equal.
+*/
+func jsonEqual(a any, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+/*
+compactJSON returns the compact JSON text of a value (map keys sorted).
+*/
+func compactJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
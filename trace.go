@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"google.golang.org/genai"
+)
+
+// TraceRecord represents one request to Gemini in the JSONL trace file
+type TraceRecord struct {
+	ID         string      `json:"id"`
+	Start      time.Time   `json:"start"`
+	Finish     time.Time   `json:"finish"`
+	DurationMs int64       `json:"durationMs"`
+	Model      string      `json:"model"`
+	Mode       string      `json:"mode"` // 'chat' or 'non-chat' (optional suffix ', stream')
+	Attempts   []string    `json:"attempts,omitempty"`
+	Fallback   string      `json:"fallback,omitempty"`
+	Config     any         `json:"config"`
+	Contents   any         `json:"contents"`
+	Response   any         `json:"response,omitempty"`
+	Error      *TraceError `json:"error,omitempty"`
+}
+
+// TraceError represents the error of a request (details of Gemini API errors included)
+type TraceError struct {
+	Message string           `json:"message"`
+	Code    int              `json:"code,omitempty"`
+	Status  string           `json:"status,omitempty"`
+	Details []map[string]any `json:"details,omitempty"`
+}
+
+// apiKeyPattern matches API keys passed as URL parameter (e.g. in error messages)
+var apiKeyPattern = regexp.MustCompile(`([?&]key=)[^&"\s]+`)
+
+/*
+newTraceRecord creates the trace record of a request before it is sent. Contents and configuration are
+converted to their JSON form immediately, as the chat history changes with the request.
+*/
+func newTraceRecord(contents []*genai.Content, modelConfig *genai.GenerateContentConfig, mode string) *TraceRecord {
+	if !progConfig.TraceRequests {
+		return nil
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		fmt.Printf("error [%v] at uuid.NewV4()\n", err)
+		return nil
+	}
+	return &TraceRecord{
+		ID:       id.String(),
+		Start:    time.Now(),
+		Model:    progConfig.GeminiAiModel,
+		Mode:     mode,
+		Config:   summarizeForTrace(modelConfig),
+		Contents: summarizeForTrace(contents),
+	}
+}
+
+/*
+finishTraceRecord completes the trace record with response, error, attempts and fallback information and
+appends it to the trace file.
+*/
+func finishTraceRecord(record *TraceRecord, resp *genai.GenerateContentResponse, respErr error) {
+	if record == nil {
+		return
+	}
+	record.Finish = time.Now()
+	record.DurationMs = record.Finish.Sub(record.Start).Milliseconds()
+	record.Model = progConfig.GeminiAiModel // answering model (fallback)
+	record.Fallback = fallbackInfo
+	for _, attempt := range retryAttempts {
+		record.Attempts = append(record.Attempts, fmt.Sprintf("#%d %s", attempt.number, shortErrorText(attempt.err)))
+	}
+	if resp != nil {
+		record.Response = summarizeForTrace(resp)
+	}
+	if respErr != nil {
+		record.Error = &TraceError{Message: respErr.Error()}
+		var apiErr genai.APIError
+		if errors.As(respErr, &apiErr) {
+			record.Error.Code = apiErr.Code
+			record.Error.Status = apiErr.Status
+			record.Error.Details = apiErr.Details
+		}
+	}
+	writeTraceRecord(record)
+}
+
+/*
+summarizeForTrace converts an object into its JSON form, with binary data (inline data, thought
+signatures) replaced by size and SHA-256 hash.
+*/
+func summarizeForTrace(object any) any {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Sprintf("error [%v] at json.Marshal()", err)
+	}
+	var generic any
+	err = json.Unmarshal(data, &generic)
+	if err != nil {
+		return fmt.Sprintf("error [%v] at json.Unmarshal()", err)
+	}
+	return summarizeBinaryData(generic, "")
+}
+
+/*
+summarizeBinaryData walks through a JSON object and replaces base64 encoded binary data (inline data,
+thought signatures) by a summary.
+*/
+func summarizeBinaryData(value any, key string) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for childKey, child := range typed {
+			encoded, isString := child.(string)
+			if isString && ((key == "inlineData" && childKey == "data") || childKey == "thoughtSignature") {
+				typed[childKey] = summarizeBase64(encoded)
+				continue
+			}
+			typed[childKey] = summarizeBinaryData(child, childKey)
+		}
+		return typed
+	case []any:
+		for i, child := range typed {
+			typed[i] = summarizeBinaryData(child, key)
+		}
+		return typed
+	}
+	return value
+}
+
+/*
+summarizeBase64 summarizes base64 encoded binary data by size and SHA-256 hash.
+*/
+func summarizeBase64(encoded string) string {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Sprintf("[%d bytes base64]", len(encoded))
+	}
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("[%d bytes, sha256:%s]", len(data), hex.EncodeToString(hash[:]))
+}
+
+/*
+redactAPIKey removes the Gemini API key from a trace line.
+*/
+func redactAPIKey(line string) string {
+	if len(progConfig.GeminiAPIKey) > 0 {
+		line = strings.ReplaceAll(line, progConfig.GeminiAPIKey, "[REDACTED]")
+	}
+	return apiKeyPattern.ReplaceAllString(line, "${1}[REDACTED]")
+}
+
+/*
+writeTraceRecord appends a trace record (one line of JSON) to the trace file. The trace file is rotated
+before it exceeds TraceMaxSize (e.g. 'gemini-trace.jsonl' -> 'gemini-trace.jsonl.1'), at most TraceMaxFiles
+rotated files are kept.
+*/
+func writeTraceRecord(record *TraceRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Printf("error [%v] at json.Marshal()\n", err)
+		return
+	}
+	line := redactAPIKey(string(data)) + "\n"
+
+	maxSize := int64(progConfig.TraceMaxSize) * 1024 * 1024
+	fileInfo, err := os.Stat(progConfig.TraceFile)
+	if err == nil && maxSize > 0 && fileInfo.Size()+int64(len(line)) > maxSize {
+		rotateTraceFiles()
+	}
+
+	file, err := os.OpenFile(progConfig.TraceFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("error [%v] at os.OpenFile()\n", err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = file.WriteString(line)
+	if err != nil {
+		fmt.Printf("error [%v] at file.WriteString()\n", err)
+	}
+}
+
+/*
+rotateTraceFiles shifts the rotated trace files by one (the oldest is removed) and renames the current
+trace file to '.1'.
+*/
+func rotateTraceFiles() {
+	if progConfig.TraceMaxFiles <= 0 {
+		_ = os.Remove(progConfig.TraceFile)
+		return
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", progConfig.TraceFile, progConfig.TraceMaxFiles))
+	for i := progConfig.TraceMaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", progConfig.TraceFile, i), fmt.Sprintf("%s.%d", progConfig.TraceFile, i+1))
+	}
+	err := os.Rename(progConfig.TraceFile, progConfig.TraceFile+".1")
+	if err != nil {
+		fmt.Printf("error [%v] at os.Rename()\n", err)
+	}
+}
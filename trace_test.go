@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTraceRecordRotation(t *testing.T) {
+	record := &TraceRecord{ID: "test", Model: "models/test", Mode: "non-chat"}
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineLength := int64(len(data)) + 1
+	const megabyte = 1024 * 1024
+
+	tests := []struct {
+		name        string
+		maxSize     int     // MB
+		maxFiles    int     // rotated files kept
+		size        int64   // size of existing trace file
+		rotated     int     // number of existing rotated files
+		wantSize    int64   // size of trace file afterwards
+		wantRotated []int64 // sizes of the rotated files afterwards (.1, .2, ...)
+	}{
+		{name: "below threshold", maxSize: 1, maxFiles: 2, size: 100, wantSize: 100 + lineLength},
+		{name: "exactly at threshold", maxSize: 1, maxFiles: 2, size: megabyte - lineLength, wantSize: megabyte},
+		{name: "above threshold", maxSize: 1, maxFiles: 2, size: megabyte - lineLength + 1, wantSize: lineLength,
+			wantRotated: []int64{megabyte - lineLength + 1}},
+		{name: "oldest rotated file removed", maxSize: 1, maxFiles: 2, size: megabyte, rotated: 2, wantSize: lineLength,
+			wantRotated: []int64{megabyte, 2}},
+		{name: "no rotation", maxSize: 0, maxFiles: 2, size: megabyte, wantSize: megabyte + lineLength},
+		{name: "no rotated files kept", maxSize: 1, maxFiles: 0, size: megabyte, wantSize: lineLength},
+	}
+
+	savedConfig := progConfig
+	defer func() { progConfig = savedConfig }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			traceFile := filepath.Join(t.TempDir(), "gemini-trace.jsonl")
+			progConfig.TraceFile = traceFile
+			progConfig.TraceMaxSize = test.maxSize
+			progConfig.TraceMaxFiles = test.maxFiles
+
+			err := os.WriteFile(traceFile, []byte(strings.Repeat("x", int(test.size))), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// existing rotated files: '.1' is one byte larger than '.2' (identifies the shifted files)
+			for i := 1; i <= test.rotated; i++ {
+				err = os.WriteFile(fmt.Sprintf("%s.%d", traceFile, i), []byte(strings.Repeat("r", test.rotated-i+1)), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writeTraceRecord(record)
+
+			fileInfo, err := os.Stat(traceFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileInfo.Size() != test.wantSize {
+				t.Errorf("got trace file size %d, want %d", fileInfo.Size(), test.wantSize)
+			}
+			for i := 1; i <= test.maxFiles+1; i++ {
+				rotatedFile := fmt.Sprintf("%s.%d", traceFile, i)
+				fileInfo, err := os.Stat(rotatedFile)
+				switch {
+				case i <= len(test.wantRotated) && err != nil:
+					t.Errorf("rotated file '.%d' missing", i)
+				case i <= len(test.wantRotated) && fileInfo.Size() != test.wantRotated[i-1]:
+					t.Errorf("got size %d of rotated file '.%d', want %d", fileInfo.Size(), i, test.wantRotated[i-1])
+				case i > len(test.wantRotated) && err == nil:
+					t.Errorf("unexpected rotated file '.%d'", i)
+				}
+			}
+		})
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"os"
 	"strings"
-
-	"google.golang.org/genai"
 )
 
 /*
@@ -25,6 +23,8 @@ func printUsage() {
 	// Piping
 	fmt.Printf("  %-30s %s\n", "[Piped Input]", "cat task.txt | "+progName+" -out result")
 	fmt.Printf("  %-30s %s\n", "[Pure Response]", "echo \"Hello\" | "+progName+" -pure-response")
+	fmt.Printf("  %-30s %s\n", "[Long answers]", progName+" -auto-continue  (continues at MAX_TOKENS)")
+	fmt.Printf("  %-30s %s\n", "[Structured output]", progName+" -schema invoice.schema.json invoice.pdf")
 
 	// Files
 	fmt.Printf("  %-30s %s\n", "[Local source files]", progName+" -pro main.go utils.go")
@@ -33,6 +33,10 @@ func printUsage() {
 	// Grounding
 	fmt.Printf("  %-30s %s\n", "[Web-Search & No Maps]", progName+" -google-search -google-maps=false")
 	fmt.Printf("  %-30s %s\n", "[Research specific URL]", progName+" -url-context https://go.dev")
+	fmt.Printf("  %-30s %s\n", "[Call local functions]", progName+" -function-calling  (section 'Functions' in YAML)")
+	fmt.Printf("  %-30s %s\n", "[Explore repository]", progName+" -workspace-tools  (read-only, sandboxed)")
+	fmt.Printf("  %-30s %s\n", "[Use MCP server tools]", progName+" -mcp-tools  (section 'MCPServers' in YAML)")
+	fmt.Printf("  %-30s %s\n", "[Serve tools to agents]", progName+" -mcp-server  (MCP over stdio, e.g. for IDEs)")
 
 	// RAG / Stores
 	fmt.Printf("  %-30s %s\n", "[Create Knowledge Base]", progName+" -create-store \"LegalDocs\"")
@@ -42,18 +46,24 @@ func printUsage() {
 	// Caching
 	fmt.Printf("  %-30s %s\n", "[Cache large files]", progName+" -create-cache *.pdf")
 
+	// Batch
+	fmt.Printf("  %-30s %s\n", "[Submit batch job]", progName+" -flash -batch-submit requests.jsonl")
+	fmt.Printf("  %-30s %s\n", "[Fetch batch results]", progName+" -batch-fetch batches/12345")
+
 	// Groups
 	groups := []struct {
 		name  string
 		flags []string
 	}{
 		{"Model Selection", []string{"lite", "flash", "pro", "flash-image", "pro-image", "default", "list-models"}},
-		{"Generation Parameters", []string{"candidates", "pure-response"}},
-		{"Grounding & Tools", []string{"code-execution", "google-search", "url-context", "google-maps"}},
-		{"Chat & Interaction", []string{"chatmode", "verbose", "config", "filelist"}},
+		{"Generation Parameters", []string{"candidates", "pure-response", "stream", "auto-continue", "schema"}},
+		{"Grounding & Tools", []string{"code-execution", "google-search", "url-context", "google-maps", "function-calling", "workspace-tools", "mcp-tools"}},
+		{"Chat & Interaction", []string{"chatmode", "list-sessions", "resume", "verbose", "config", "profile", "show-effective-config", "filelist", "dry-run", "dry-run-json", "fake-server", "mcp-server"}},
+		{"Usage & Cost", []string{"usage-report", "ignore-budget", "count-tokens"}},
 		{"Output Control", []string{"out"}},
 		{"Context: Caching (High Perf)", []string{"create-cache", "include-cache", "list-cache", "delete-cache"}},
 		{"Context: Google File Store", []string{"upload-files", "include-files", "list-files", "delete-files"}},
+		{"Batch Mode (50% Price)", []string{"batch-submit", "batch-status", "batch-list", "batch-cancel", "batch-delete", "batch-fetch"}},
 		{"Context: RAG (Persistent)", []string{"list-stores", "create-store", "delete-store", "add-to-store", "delete-from-store", "include-store", "list-store-content"}},
 	}
 
@@ -101,17 +111,48 @@ func printUsage() {
 
 	fmt.Printf("\nCore Concepts:\n")
 	fmt.Printf("  %-30s %s\n", "[Input Channels]", "Interactive Terminal, File-Watch (prompt-input.txt), localhost:4242.")
+	fmt.Printf("  %-30s %s\n", "[JSON API]", "POST localhost:4242/v1/prompt {\"prompt\": \"...\", \"model\": \"flash\", \"tools\": [...],")
+	fmt.Printf("  %-30s %s\n", "", "\"files\": [...]} returns Markdown, HTML, slug, token usage and history files.")
+	fmt.Printf("  %-30s %s\n", "", "Requires 'Authorization: Bearer <InputLocalhostAPIToken>', files within InputLocalhostFilesRoot.")
 	fmt.Printf("  %-30s %s\n", "[Terminal Inject]", "Type '<<< filename.txt' in terminal to load file content as prompt.")
+	fmt.Printf("  %-30s %s\n", "[Slash Commands]", "Type '/help' in terminal: /model, /tools, /files, /thinking, /candidates,")
+	fmt.Printf("  %-30s %s\n", "", "/reset, /save, /config change the running session without restart.")
 	fmt.Printf("  %-30s %s\n", "[Output Formats]", "Markdown (raw), ANSI (terminal color), HTML (browser with JS features).")
 	fmt.Printf("  %-30s %s\n", "[Chat Mode]", "AI remembers history. Files are sent only with the FIRST prompt.")
+	fmt.Printf("  %-30s %s\n", "[Chat Sessions]", "Saved after every response, resume with -resume <id> (see -list-sessions).")
 	fmt.Printf("  %-30s %s\n", "[Non-Chat Mode]", "Each prompt is isolated. Files are sent with EVERY prompt.")
 	fmt.Printf("  %-30s %s\n", "[File Lists]", "Files passed via -filelist can contain comments (# or //)")
 	fmt.Printf("  %-30s %s\n", "", "and empty lines, which will be ignored during processing.")
-	fmt.Printf("  %-30s %s\n", "[Exit Interactive]", "Type Ctrl+C to quit.")
+	fmt.Printf("  %-30s %s\n", "[Batch Requests]", "JSONL file, one request per line: {\"prompt\": \"...\", \"model\": \"flash\",")
+	fmt.Printf("  %-30s %s\n", "", "\"tools\": [\"google-search\"]}. Model and tools are optional.")
+	fmt.Printf("  %-30s %s\n", "[Usage Ledger]", "Token usage of every request and cache is appended to usage-ledger.jsonl,")
+	fmt.Printf("  %-30s %s\n", "", "-usage-report day|month|model prints totals and cost (UsagePrices).")
+	fmt.Printf("  %-30s %s\n", "[Spending Budget]", "Daily/monthly limits (tokens or cost) warn at a threshold and block requests")
+	fmt.Printf("  %-30s %s\n", "", "in all input modes once exhausted (override with -ignore-budget).")
+	fmt.Printf("  %-30s %s\n", "[Pre-flight Check]", "Counts tokens per file before sending and warns or aborts if the input")
+	fmt.Printf("  %-30s %s\n", "", "token limit of the model would be exceeded (PreflightTokenCount).")
+	fmt.Printf("  %-30s %s\n", "[Dry Run]", "-dry-run prints the assembled request (files, MIME types, contents, system")
+	fmt.Printf("  %-30s %s\n", "", "instruction, tools, cache, stores) without sending it, -dry-run-json as wire payload.")
+	fmt.Printf("  %-30s %s\n", "[Layered Config]", "Defaults < ~/.config/gem-pro/config.yaml < project gem-pro.yaml < profile <")
+	fmt.Printf("  %-30s %s\n", "", "GEMPRO_* env < flags, -show-effective-config prints values with origins.")
+	fmt.Printf("  %-30s %s\n", "[Profiles]", "-profile name applies a named profile (section 'Profiles') on top of the base")
+	fmt.Printf("  %-30s %s\n", "", "configuration, e.g. model, tools, temperature, thinking, system instruction.")
+	fmt.Printf("  %-30s %s\n", "[Vertex AI]", "GeminiBackend: vertex sends requests to Vertex AI (project, location, ADC or")
+	fmt.Printf("  %-30s %s\n", "", "service account), Cloud Storage files (VertexFileURIs) replace the file store.")
+	fmt.Printf("  %-30s %s\n", "[Fake Server]", "-fake-server serves canned or scripted responses (FakeServerScript) offline,")
+	fmt.Printf("  %-30s %s\n", "", "point a second configuration to it via GeminiBaseURL.")
+	fmt.Printf("  %-30s %s\n", "[Cancel Request]", "Type Ctrl+C during a running request to cancel only this request.")
+	fmt.Printf("  %-30s %s\n", "[Exit Interactive]", "Type Ctrl+C to quit (twice while a request is running).")
 
 	fmt.Printf("\nEnvironment Variables:\n")
 	fmt.Printf("  %-30s %s\n", "[GEMINI_API_KEY]", "Your API Key from ai.google.dev (Mandatory).")
 	fmt.Printf("  %-30s %s\n", "[HTTPS_PROXY]", "Used if set and no proxy is defined in YAML.")
+	fmt.Printf("  %-30s %s\n", "[NO_PROXY]", "Hosts excluded from proxying (also with proxy defined in YAML).")
+	fmt.Printf("  %-30s %s\n", "[GEMPRO_*]", "Overrides configuration values (e.g. GEMPRO_GEMINI_TEMPERATURE=0.7).")
+	fmt.Printf("  %-30s %s\n", "[GOOGLE_CLOUD_PROJECT]", "Vertex AI project, if VertexProject is empty.")
+	fmt.Printf("  %-30s %s\n", "[GOOGLE_CLOUD_LOCATION]", "Vertex AI location, if VertexLocation is empty.")
+	fmt.Printf("  %s\n", "[GOOGLE_APPLICATION_CREDENTIALS]")
+	fmt.Printf("  %-30s %s\n", "", "Service account file for Application Default Credentials (Vertex AI).")
 
 	fmt.Printf("\nTerms & Privacy:\n")
 	fmt.Printf("  %-30s %s\n", "[Free Tier]", "Google uses your data to improve their models. DO NOT use private data.")
@@ -129,10 +170,7 @@ console, including model names, descriptions, and token limits.
 func showAvailableGeminiModels(terminalWidth int) {
 	// create AI client
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  progConfig.GeminiAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := newGeminiClient(ctx)
 	if err != nil {
 		fmt.Printf("error [%v] creating AI client\n", err)
 		os.Exit(1)
@@ -11,7 +11,6 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gofrs/uuid"
 	"github.com/mitchellh/go-wordwrap"
@@ -284,6 +283,26 @@ func getPassword(passPhrase string) (string, error) {
 	return password, nil
 }
 
+/*
+getCredentials obtains a credentials document (e.g. a service account JSON file). Like getPassword, but
+'file:pathname' returns the complete file instead of its first line.
+*/
+func getCredentials(source string) ([]byte, error) {
+	items := strings.SplitN(source, ":", 2)
+	if len(items) == 2 && strings.ToLower(items[0]) == "file" {
+		data, err := os.ReadFile(items[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to read credentials from file, error = [%w], file = [%v]", err, items[1])
+		}
+		return data, nil
+	}
+	credentials, err := getPassword(source)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(credentials), nil
+}
+
 /*
 slurpFile reads all lines from a text file and returns them as a slice of strings. It reads the content of a
 text file line by line and returns each line as an element in a string slice.
@@ -330,22 +349,6 @@ func getFileMimeType(filepath string) (string, error) {
 	return mimeType, nil
 }
 
-/*
-dumpDataToFile writes an arbitrary data object to a file in a human-readable format using `spew.Sdump`. It
-serializes any given Go data object into a human-readable string format using `spew.Sdump` and writes this
-string to a file, useful for debugging and logging purposes.
-*/
-func dumpDataToFile(flag int, objectname string, object interface{}) {
-	data := fmt.Sprintf("---------- %s ----------\n%s\n", objectname, spew.Sdump(object))
-	file, err := os.OpenFile("gemini.raw", flag, 0600)
-	if err != nil {
-		fmt.Printf("error [%v] at os.OpenFile()\n", err)
-		return
-	}
-	defer func() { _ = file.Close() }()
-	_, _ = fmt.Fprint(file, data)
-}
-
 /*
 writeDataToFile writes the provided byte slice data to a file. It generates a unique filename based on the
 current timestamp, a UUID, and the provided mimeType. It saves the file in the "files" directory. It returns
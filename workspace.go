@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"google.golang.org/genai"
+)
+
+// workspaceTool is a built-in function with read-only access to the workspace (sandbox root)
+type workspaceTool struct {
+	declaration *genai.FunctionDeclaration
+	run         func(args map[string]any) (any, error)
+}
+
+// workspaceLineMaxLength is the maximum length of a line returned by grep
+const workspaceLineMaxLength = 300
+
+// workspaceTools lists the built-in workspace tools (WorkspaceTools: true)
+var workspaceTools = []workspaceTool{
+	{
+		declaration: &genai.FunctionDeclaration{
+			Name:        "read_file",
+			Description: "Reads a text file of the workspace. Large files are truncated; use start_line and max_lines to read a part.",
+			ParametersJsonSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path":       map[string]any{"type": "string", "description": "file path relative to the workspace root"},
+					"start_line": map[string]any{"type": "integer", "description": "first line to read (1-based, default 1)"},
+					"max_lines":  map[string]any{"type": "integer", "description": "maximum number of lines to read (default all)"},
+				},
+				"required": []string{"path"},
+			},
+		},
+		run: workspaceReadFile,
+	},
+	{
+		declaration: &genai.FunctionDeclaration{
+			Name:        "list_directory",
+			Description: "Lists the entries (name, type, size) of a directory of the workspace.",
+			ParametersJsonSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path": map[string]any{"type": "string", "description": "directory path relative to the workspace root (default '.')"},
+				},
+			},
+		},
+		run: workspaceListDirectory,
+	},
+	{
+		declaration: &genai.FunctionDeclaration{
+			Name:        "glob",
+			Description: "Finds files of the workspace matching a glob pattern, e.g. '**/*.go' or 'docs/*.md' ('**' matches any number of directories).",
+			ParametersJsonSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"pattern": map[string]any{"type": "string", "description": "glob pattern relative to the workspace root"},
+				},
+				"required": []string{"pattern"},
+			},
+		},
+		run: workspaceGlob,
+	},
+	{
+		declaration: &genai.FunctionDeclaration{
+			Name:        "grep",
+			Description: "Searches the text files of the workspace for lines matching a regular expression (RE2 syntax).",
+			ParametersJsonSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"pattern":     map[string]any{"type": "string", "description": "regular expression"},
+					"path":        map[string]any{"type": "string", "description": "file or directory to search, relative to the workspace root (default '.')"},
+					"include":     map[string]any{"type": "string", "description": "glob pattern of file names to search, e.g. '*.go'"},
+					"ignore_case": map[string]any{"type": "boolean", "description": "case insensitive search"},
+				},
+				"required": []string{"pattern"},
+			},
+		},
+		run: workspaceGrep,
+	},
+	{
+		declaration: &genai.FunctionDeclaration{
+			Name:        "file_stat",
+			Description: "Returns type, size, modification time and MIME type of a file or directory of the workspace.",
+			ParametersJsonSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"path": map[string]any{"type": "string", "description": "path relative to the workspace root"},
+				},
+				"required": []string{"path"},
+			},
+		},
+		run: workspaceFileStat,
+	},
+}
+
+/*
+validateWorkspaceConfiguration validates the configuration of the workspace tools (limits, deny-list patterns).
+*/
+func validateWorkspaceConfiguration() error {
+	if progConfig.WorkspaceMaxFileSize < 1 || progConfig.WorkspaceMaxResults < 1 {
+		return fmt.Errorf("WorkspaceMaxFileSize and WorkspaceMaxResults must be at least 1")
+	}
+	for _, pattern := range progConfig.WorkspaceDenyList {
+		_, err := filepath.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid WorkspaceDenyList pattern [%s]", pattern)
+		}
+	}
+	return nil
+}
+
+/*
+findWorkspaceTool returns the built-in workspace tool with the given name (nil if unknown or not enabled).
+*/
+func findWorkspaceTool(name string) *workspaceTool {
+	if !progConfig.WorkspaceTools {
+		return nil
+	}
+	for i := range workspaceTools {
+		if workspaceTools[i].declaration.Name == name {
+			return &workspaceTools[i]
+		}
+	}
+	return nil
+}
+
+/*
+isWorkspaceToolName reports whether a name is reserved for a built-in workspace tool.
+*/
+func isWorkspaceToolName(name string) bool {
+	for _, tool := range workspaceTools {
+		if tool.declaration.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+workspaceRootDir returns the absolute sandbox root of the workspace tools (WorkspaceRoot, default: current
+directory) with symbolic links resolved.
+*/
+func workspaceRootDir() (string, error) {
+	root := progConfig.WorkspaceRoot
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+/*
+resolveWorkspacePath resolves a path given by the model within the sandbox root. Paths outside of the root
+(also via symbolic links) and denied paths (WorkspaceDenyList) are rejected. It returns the absolute path
+and the path relative to the root (slash separated).
+*/
+func resolveWorkspacePath(name string) (string, string, error) {
+	root, err := workspaceRootDir()
+	if err != nil {
+		return "", "", fmt.Errorf("workspace root not available")
+	}
+	if name == "" {
+		name = "."
+	}
+	target := filepath.FromSlash(name)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	target = filepath.Clean(target)
+
+	relative, ok := workspaceRelativePath(root, target)
+	if !ok {
+		return "", "", fmt.Errorf("path [%s] outside of workspace", name)
+	}
+	if isWorkspacePathDenied(relative) {
+		return "", "", fmt.Errorf("access to [%s] denied", name)
+	}
+
+	// symbolic links must not lead out of the workspace or to denied files
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", "", fmt.Errorf("path [%s] not found", name)
+	}
+	resolvedRelative, ok := workspaceRelativePath(root, resolved)
+	if !ok {
+		return "", "", fmt.Errorf("path [%s] outside of workspace", name)
+	}
+	if isWorkspacePathDenied(resolvedRelative) {
+		return "", "", fmt.Errorf("access to [%s] denied", name)
+	}
+	return resolved, relative, nil
+}
+
+/*
+workspaceRelativePath returns the path relative to the root (slash separated) and whether it is inside the root.
+*/
+func workspaceRelativePath(root string, target string) (string, bool) {
+	relative, err := filepath.Rel(root, target)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(relative), true
+}
+
+/*
+isWorkspacePathDenied reports whether a path (relative to the root) matches the deny-list: the patterns are
+matched against each path element (e.g. '.env', '*.key') and against the whole path (e.g. 'config/secrets/*').
+*/
+func isWorkspacePathDenied(relative string) bool {
+	return pathMatchesDenyList(progConfig.WorkspaceDenyList, relative)
+}
+
+/*
+pathMatchesDenyList reports whether a relative path (slash separated) or one of its elements matches a pattern
+of the deny list.
+*/
+func pathMatchesDenyList(denyList []string, relative string) bool {
+	if relative == "." {
+		return false
+	}
+	for _, pattern := range denyList {
+		if matched, _ := filepath.Match(pattern, relative); matched {
+			return true
+		}
+		for _, element := range strings.Split(relative, "/") {
+			if matched, _ := filepath.Match(pattern, element); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+workspaceMimeType returns the MIME type of a file (content based, replacement MIME types applied) and
+whether the file contains text.
+*/
+func workspaceMimeType(path string) (string, bool) {
+	mimeType, err := getFileMimeType(path)
+	if err != nil {
+		return mimeType, false
+	}
+	isText := strings.HasPrefix(mimeType, "text/")
+	for m := mimetype.Lookup(mimeType); m != nil && !isText; m = m.Parent() {
+		isText = m.Is("text/plain")
+	}
+	if replacement, ok := ReplacementMIMETypeMap[mimeType]; ok {
+		mimeType = replacement
+	}
+	return mimeType, isText
+}
+
+/*
+workspaceReadFile implements the tool 'read_file': text files only, truncated at WorkspaceMaxFileSize KiB.
+*/
+func workspaceReadFile(args map[string]any) (any, error) {
+	path, relative, err := resolveWorkspacePath(stringArgument(args, "path"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("[%s] is not a regular file", relative)
+	}
+	mimeType, isText := workspaceMimeType(path)
+	if !isText {
+		return nil, fmt.Errorf("[%s] is not a text file (%s)", relative, mimeType)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	maxSize := int64(progConfig.WorkspaceMaxFileSize) * 1024
+	data, err := io.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	truncated := info.Size() > maxSize
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	startLine := max(intArgument(args, "start_line"), 1)
+	endLine := len(lines)
+	if maxLines := intArgument(args, "max_lines"); maxLines > 0 {
+		endLine = min(endLine, startLine+maxLines-1)
+	}
+	content := ""
+	if startLine <= endLine {
+		content = strings.Join(lines[startLine-1:endLine], "")
+	}
+
+	return map[string]any{
+		"path":       relative,
+		"mime_type":  mimeType,
+		"size":       info.Size(),
+		"start_line": startLine,
+		"end_line":   endLine,
+		"truncated":  truncated,
+		"content":    content,
+	}, nil
+}
+
+/*
+workspaceListDirectory implements the tool 'list_directory' (denied entries are not listed).
+*/
+func workspaceListDirectory(args map[string]any) (any, error) {
+	path, relative, err := resolveWorkspacePath(stringArgument(args, "path"))
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []map[string]any{}
+	truncated := false
+	for _, dirEntry := range dirEntries {
+		if isWorkspacePathDenied(joinWorkspacePath(relative, dirEntry.Name())) {
+			continue
+		}
+		if len(entries) == progConfig.WorkspaceMaxResults {
+			truncated = true
+			break
+		}
+		entry := map[string]any{"name": dirEntry.Name(), "type": workspaceEntryType(dirEntry.Type())}
+		if info, err := dirEntry.Info(); err == nil && info.Mode().IsRegular() {
+			entry["size"] = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	return map[string]any{"path": relative, "entries": entries, "truncated": truncated}, nil
+}
+
+/*
+workspaceGlob implements the tool 'glob': all files of the workspace matching the pattern.
+*/
+func workspaceGlob(args map[string]any) (any, error) {
+	pattern := stringArgument(args, "pattern")
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	matcher, err := globToRegexp(strings.TrimPrefix(pattern, "./"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern [%s]", pattern)
+	}
+	root, _, err := resolveWorkspacePath(".")
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	truncated := false
+	err = walkWorkspace(root, func(path string, relative string) bool {
+		if !matcher.MatchString(relative) {
+			return true
+		}
+		if len(matches) == progConfig.WorkspaceMaxResults {
+			truncated = true
+			return false
+		}
+		matches = append(matches, relative)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"pattern": pattern, "matches": matches, "truncated": truncated}, nil
+}
+
+/*
+workspaceGrep implements the tool 'grep': matching lines of all text files up to WorkspaceMaxFileSize KiB.
+*/
+func workspaceGrep(args map[string]any) (any, error) {
+	expression := stringArgument(args, "pattern")
+	if expression == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if ignoreCase, _ := args["ignore_case"].(bool); ignoreCase {
+		expression = "(?i)" + expression
+	}
+	matcher, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression [%s]", stringArgument(args, "pattern"))
+	}
+	include := stringArgument(args, "include")
+	if _, err := filepath.Match(include, ""); err != nil {
+		return nil, fmt.Errorf("invalid include pattern [%s]", include)
+	}
+	path, _, err := resolveWorkspacePath(stringArgument(args, "path"))
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []map[string]any{}
+	truncated := false
+	maxSize := int64(progConfig.WorkspaceMaxFileSize) * 1024
+	err = walkWorkspace(path, func(file string, relative string) bool {
+		if include != "" {
+			if matched, _ := filepath.Match(include, filepath.Base(file)); !matched {
+				return true
+			}
+		}
+		info, err := os.Stat(file)
+		if err != nil || info.Size() > maxSize {
+			return true
+		}
+		if _, isText := workspaceMimeType(file); !isText {
+			return true
+		}
+		fileMatches, complete := grepWorkspaceFile(file, relative, matcher, progConfig.WorkspaceMaxResults-len(matches))
+		matches = append(matches, fileMatches...)
+		if !complete {
+			truncated = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"pattern": stringArgument(args, "pattern"), "matches": matches, "truncated": truncated}, nil
+}
+
+/*
+grepWorkspaceFile returns up to limit matching lines of a file and whether all matches were returned.
+*/
+func grepWorkspaceFile(file string, relative string, matcher *regexp.Regexp, limit int) ([]map[string]any, bool) {
+	matches := []map[string]any{}
+	handle, err := os.Open(file)
+	if err != nil {
+		return matches, true
+	}
+	defer func() { _ = handle.Close() }()
+
+	scanner := bufio.NewScanner(handle)
+	scanner.Buffer(make([]byte, 64*1024), progConfig.WorkspaceMaxFileSize*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if !matcher.MatchString(line) {
+			continue
+		}
+		if len(matches) == limit {
+			return matches, false
+		}
+		if len(line) > workspaceLineMaxLength {
+			line = line[:workspaceLineMaxLength] + " ..."
+		}
+		matches = append(matches, map[string]any{"path": relative, "line": lineNumber, "text": line})
+	}
+	return matches, true
+}
+
+/*
+workspaceFileStat implements the tool 'file_stat'.
+*/
+func workspaceFileStat(args map[string]any) (any, error) {
+	path, relative, err := resolveWorkspacePath(stringArgument(args, "path"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := map[string]any{
+		"path":     relative,
+		"type":     workspaceEntryType(info.Mode().Type()),
+		"size":     info.Size(),
+		"modified": info.ModTime().Format(time.RFC3339),
+	}
+	if info.Mode().IsRegular() {
+		stat["mime_type"], stat["text"] = workspaceMimeType(path)
+	}
+	return stat, nil
+}
+
+/*
+walkWorkspace calls visit for each regular file below path (symbolic links are not followed, denied entries
+are skipped) until visit returns false. A single file is visited directly.
+*/
+func walkWorkspace(path string, visit func(file string, relative string) bool) error {
+	root, err := workspaceRootDir()
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if file == path {
+				return err
+			}
+			return nil // unreadable entry
+		}
+		relative, ok := workspaceRelativePath(root, file)
+		if !ok || isWorkspacePathDenied(relative) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if !visit(file, relative) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+/*
+globToRegexp converts a glob pattern into a regular expression: '**' matches any number of directories,
+'*' and '?' match within a path element.
+*/
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			sb.WriteString("[^/]*")
+		case pattern[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+/*
+joinWorkspacePath joins a relative directory path and an entry name (slash separated).
+*/
+func joinWorkspacePath(directory string, name string) string {
+	if directory == "." {
+		return name
+	}
+	return directory + "/" + name
+}
+
+/*
+workspaceEntryType returns the type of a directory entry ('file', 'directory', 'symlink' or 'other').
+*/
+func workspaceEntryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "file"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+/*
+stringArgument returns a string argument of a function call (empty if missing).
+*/
+func stringArgument(args map[string]any, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+/*
+intArgument returns an integer argument of a function call (0 if missing, JSON numbers are float64).
+*/
+func intArgument(args map[string]any, name string) int {
+	switch value := args[name].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+	return 0
+}